@@ -0,0 +1,19 @@
+package protoc
+
+// AllocObserver is notified of the guest memory the host allocates and
+// frees through the module's exported allocator, such as the argv of every
+// run and the arguments held by PreparedRun, to track down leaks. Guest
+// allocations made by protoc itself are not reported.
+//
+// The methods are called with the Protoc's lock held and must not call
+// back into it.
+type AllocObserver interface {
+	// OnMalloc is called after malloc returned ptr for size bytes.
+	OnMalloc(size, ptr uint32)
+	// OnFree is called before ptr is freed.
+	OnFree(ptr uint32)
+	// OnRealloc is called after realloc resized oldPtr to size bytes at
+	// newPtr, or allocated newPtr if oldPtr is 0. The argv array of Run
+	// is grown this way and kept until Close.
+	OnRealloc(oldPtr, size, newPtr uint32)
+}
@@ -0,0 +1,57 @@
+package protoc
+
+import (
+	"context"
+	"testing"
+)
+
+// recordingAllocObserver records the live allocations.
+type recordingAllocObserver struct {
+	live     map[uint32]uint32
+	mallocs  int
+	reallocs int
+}
+
+func (o *recordingAllocObserver) OnMalloc(size, ptr uint32) {
+	o.live[ptr] = size
+	o.mallocs++
+}
+
+func (o *recordingAllocObserver) OnFree(ptr uint32) {
+	delete(o.live, ptr)
+}
+
+func (o *recordingAllocObserver) OnRealloc(oldPtr, size, newPtr uint32) {
+	delete(o.live, oldPtr)
+	o.live[newPtr] = size
+	o.reallocs++
+}
+
+func TestProtocAllocObserver(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	observer := &recordingAllocObserver{live: make(map[uint32]uint32)}
+	p := newTestProtoc(t, &Config{FS: memFS, AllocObserver: observer})
+
+	args := []string{"protoc", "-I/", "--descriptor_set_out=/dev/null", "foo.proto"}
+	for range 2 {
+		if _, err := p.Run(ctx, args); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	}
+	// One allocation per argument and run, and one for the retained argv.
+	if observer.mallocs != 2*len(args) || observer.reallocs != 1 {
+		t.Errorf("expected %d allocations and 1 reallocation, got %d and %d", 2*len(args), observer.mallocs, observer.reallocs)
+	}
+	if len(observer.live) != 1 {
+		t.Errorf("expected only argv to be retained, got %v", observer.live)
+	}
+
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(observer.live) != 0 {
+		t.Errorf("expected every allocation to be freed, got %v", observer.live)
+	}
+}
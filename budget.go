@@ -0,0 +1,85 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// ErrBudgetExceeded is returned when a run exceeds Config.MaxInstructions.
+var ErrBudgetExceeded = errors.New("protoc exceeded MaxInstructions")
+
+// ErrNoInstructionCounting is returned by Init, and by RunWithLimits before
+// running, when an instruction limit is set but the module was compiled
+// without WithInstructionCounting.
+var ErrNoInstructionCounting = errors.New("MaxInstructions requires a module compiled with WithInstructionCounting")
+
+// WithInstructionCounting returns a context that makes CompileProtoc, or
+// wazero.Runtime.CompileModule, instrument the module for
+// Config.MaxInstructions. NewProtoc does this automatically if
+// MaxInstructions is set; use it when sharing a compiled module with
+// NewProtocWithModule. Instrumented modules run noticeably slower.
+func WithInstructionCounting(ctx context.Context) context.Context {
+	return experimental.WithFunctionListenerFactory(ctx, budgetListener{})
+}
+
+// countsInstructions reports whether mod was compiled with
+// WithInstructionCounting, by calling malloc and free with a budget and
+// checking that they were charged to it.
+func countsInstructions(ctx context.Context, malloc, free api.Function) (bool, error) {
+	ctx, b := withBudget(ctx, math.MaxUint64)
+	results, err := malloc.Call(ctx, 1)
+	if err != nil {
+		return false, err
+	}
+	if _, err := free.Call(ctx, results[0]); err != nil {
+		return false, err
+	}
+	return b.used != 0, nil
+}
+
+// budgetKey is the context key of the *budget of the current run.
+type budgetKey struct{}
+
+// budget counts the guest function calls of a run.
+type budget struct {
+	used, max uint64
+}
+
+// withBudget returns ctx with a budget of max calls.
+func withBudget(ctx context.Context, max uint64) (context.Context, *budget) {
+	b := &budget{max: max}
+	return context.WithValue(ctx, budgetKey{}, b), b
+}
+
+// budgetListener charges every guest function call to the budget of the
+// run, aborting the run once it is exhausted. wazero does not expose
+// instruction counts, so calls approximate the work done.
+type budgetListener struct{}
+
+// NewFunctionListener implements experimental.FunctionListenerFactory.
+func (l budgetListener) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return l
+}
+
+// Before implements experimental.FunctionListener.
+func (budgetListener) Before(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	b, _ := ctx.Value(budgetKey{}).(*budget)
+	if b == nil {
+		return
+	}
+	b.used++
+	if b.used > b.max {
+		// wazero recovers the panic and returns it from the call.
+		panic(ErrBudgetExceeded)
+	}
+}
+
+// After implements experimental.FunctionListener.
+func (budgetListener) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+// Abort implements experimental.FunctionListener.
+func (budgetListener) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
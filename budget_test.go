@@ -0,0 +1,52 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestProtocMaxInstructions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message A { string name = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS, MaxInstructions: 1000})
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got: %v", err)
+	}
+	// Every run gets the full budget, on a fresh instance after an abort.
+	_, err = p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got: %v", err)
+	}
+
+	p = newTestProtoc(t, &Config{FS: memFS, MaxInstructions: 1 << 40})
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}}); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+}
+
+func TestProtocMaxInstructionsUninstrumented(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+
+	compiled, err := CompileProtoc(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProtocWithModule(ctx, r, compiled, &Config{MaxInstructions: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(ctx)
+	if err := p.Init(ctx); !errors.Is(err, ErrNoInstructionCounting) {
+		t.Errorf("expected ErrNoInstructionCounting, got: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// PluginCache stores serialized CodeGeneratorResponses for a
+// CachingPluginHandler, e.g. in memory, on disk or in a shared build
+// cache. Implementations must be safe for concurrent use.
+type PluginCache interface {
+	// Get returns the response stored under key, if any.
+	Get(key string) ([]byte, bool)
+	// Put stores output under key.
+	Put(key string, output []byte)
+}
+
+// MemoryPluginCache is a PluginCache holding responses in memory.
+// The zero value is ready to use.
+type MemoryPluginCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+// Get implements PluginCache.
+func (c *MemoryPluginCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	output, ok := c.entries[key]
+	return output, ok
+}
+
+// Put implements PluginCache.
+func (c *MemoryPluginCache) Put(key string, output []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string][]byte)
+	}
+	c.entries[key] = output
+}
+
+// CachingPluginHandler forwards plugin invocations to Handler and serves
+// repeated identical requests from Cache, so that expensive plugins don't
+// run again while their inputs are unchanged. Responses are keyed by the
+// SHA-256 of the program name and the serialized CodeGeneratorRequest,
+// which includes the parameters and the compiler version.
+//
+// Caching assumes that the plugins produce identical responses for
+// identical requests. Failed invocations and responses reporting an error
+// are not cached.
+type CachingPluginHandler struct {
+	// Handler handles the invocations missing from the cache.
+	// Default: DefaultPluginHandler.
+	Handler PluginHandler
+	// Cache stores the responses.
+	// Default: a MemoryPluginCache private to the handler.
+	Cache PluginCache
+
+	defaultCache MemoryPluginCache
+}
+
+// Communicate returns the cached response for the invocation or forwards
+// it to Handler and caches the result.
+func (h *CachingPluginHandler) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	cache := h.Cache
+	if cache == nil {
+		cache = &h.defaultCache
+	}
+	key := pluginCacheKey(program, input)
+	if output, ok := cache.Get(key); ok {
+		return bytes.Clone(output), nil
+	}
+
+	handler := h.Handler
+	if handler == nil {
+		handler = &DefaultPluginHandler{}
+	}
+	output, err := handler.Communicate(ctx, program, searchPath, input)
+	if err != nil {
+		return output, err
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if proto.Unmarshal(output, resp) == nil && resp.Error == nil {
+		cache.Put(key, bytes.Clone(output))
+	}
+	return output, nil
+}
+
+// pluginCacheKey returns the cache key of an invocation of program with
+// input, length-prefixing the name so that no two invocations share a key.
+func pluginCacheKey(program string, input []byte) string {
+	h := sha256.New()
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint64(lenBuf[:], uint64(len(program)))
+	h.Write(lenBuf[:])
+	h.Write([]byte(program))
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package protoc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestCachingPluginHandler(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	calls := 0
+	fakeGo := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		calls++
+		req := &pluginpb.CodeGeneratorRequest{}
+		if err := proto.Unmarshal(input, req); err != nil {
+			return nil, err
+		}
+		resp := &pluginpb.CodeGeneratorResponse{
+			File: []*pluginpb.CodeGeneratorResponse_File{
+				{Name: proto.String("foo.pb.go"), Content: proto.String("// " + req.GetParameter() + "\n")},
+			},
+		}
+		if req.GetParameter() == "fail" {
+			resp = &pluginpb.CodeGeneratorResponse{Error: proto.String("failed")}
+		}
+		return proto.Marshal(resp)
+	})
+	p := newTestProtoc(t, &Config{FS: memFS, PluginHandler: &CachingPluginHandler{Handler: fakeGo}})
+
+	generate := func(params ...string) (map[string]map[string][]byte, error) {
+		return p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go", Params: params}})
+	}
+	for range 2 {
+		outputs, err := generate("a")
+		if err != nil {
+			t.Fatalf("RunGenerators failed: %v", err)
+		}
+		if got := string(outputs["go"]["foo.pb.go"]); got != "// a\n" {
+			t.Errorf("unexpected output %q", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the plugin to run once for identical requests, got %d", calls)
+	}
+
+	// A different request misses the cache.
+	if _, err := generate("b"); err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the plugin to run for a new request, got %d calls", calls)
+	}
+
+	// Errors are not cached.
+	for range 2 {
+		if _, err := generate("fail"); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if calls != 4 {
+		t.Errorf("expected failed responses to not be cached, got %d calls", calls)
+	}
+}
@@ -0,0 +1,142 @@
+package protoc
+
+import (
+	"cmp"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// CanonicalizeDescriptorSet sorts an encoded FileDescriptorSet into a
+// canonical order, so that sets compiled from differently ordered sources
+// compare and diff equal. Files are ordered by name with dependencies before
+// the files importing them, as required to build a registry. Messages,
+// enums, services, methods and extensions are sorted by name, fields by
+// number, keeping the members of each oneof together, and enum values by
+// number after the first value, which is kept first as it is the default.
+// Oneofs keep their order since fields refer to them by index.
+//
+// Source code info refers to declarations by index and is removed.
+func CanonicalizeDescriptorSet(data []byte) ([]byte, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	for _, file := range set.GetFile() {
+		file.SourceCodeInfo = nil
+		canonicalizeMessages(file.GetMessageType())
+		canonicalizeEnums(file.GetEnumType())
+		canonicalizeExtensions(file.GetExtension())
+		slices.SortFunc(file.GetService(), func(a, b *descriptorpb.ServiceDescriptorProto) int {
+			return cmp.Compare(a.GetName(), b.GetName())
+		})
+		for _, svc := range file.GetService() {
+			slices.SortFunc(svc.GetMethod(), func(a, b *descriptorpb.MethodDescriptorProto) int {
+				return cmp.Compare(a.GetName(), b.GetName())
+			})
+		}
+	}
+	set.File = sortFilesByDependency(set.GetFile())
+	return proto.MarshalOptions{Deterministic: true}.Marshal(set)
+}
+
+// canonicalizeMessages sorts msgs and, recursively, their declarations.
+func canonicalizeMessages(msgs []*descriptorpb.DescriptorProto) {
+	slices.SortFunc(msgs, func(a, b *descriptorpb.DescriptorProto) int {
+		return cmp.Compare(a.GetName(), b.GetName())
+	})
+	for _, msg := range msgs {
+		sortFields(msg.GetField())
+		canonicalizeMessages(msg.GetNestedType())
+		canonicalizeEnums(msg.GetEnumType())
+		canonicalizeExtensions(msg.GetExtension())
+	}
+}
+
+// sortFields sorts fields by number. The members of a oneof must be declared
+// consecutively, so they are kept together and placed at their lowest
+// number.
+func sortFields(fields []*descriptorpb.FieldDescriptorProto) {
+	oneofNumber := make(map[int32]int32)
+	for _, field := range fields {
+		if field.OneofIndex == nil {
+			continue
+		}
+		if n, ok := oneofNumber[field.GetOneofIndex()]; !ok || field.GetNumber() < n {
+			oneofNumber[field.GetOneofIndex()] = field.GetNumber()
+		}
+	}
+	position := func(field *descriptorpb.FieldDescriptorProto) int32 {
+		if field.OneofIndex != nil {
+			return oneofNumber[field.GetOneofIndex()]
+		}
+		return field.GetNumber()
+	}
+	slices.SortFunc(fields, func(a, b *descriptorpb.FieldDescriptorProto) int {
+		return cmp.Or(
+			cmp.Compare(position(a), position(b)),
+			cmp.Compare(a.GetNumber(), b.GetNumber()),
+		)
+	})
+}
+
+// canonicalizeEnums sorts enums by name and their values by number, keeping
+// the default value first.
+func canonicalizeEnums(enums []*descriptorpb.EnumDescriptorProto) {
+	slices.SortFunc(enums, func(a, b *descriptorpb.EnumDescriptorProto) int {
+		return cmp.Compare(a.GetName(), b.GetName())
+	})
+	for _, enum := range enums {
+		if values := enum.GetValue(); len(values) > 1 {
+			slices.SortStableFunc(values[1:], func(a, b *descriptorpb.EnumValueDescriptorProto) int {
+				return cmp.Compare(a.GetNumber(), b.GetNumber())
+			})
+		}
+	}
+}
+
+// canonicalizeExtensions sorts exts by extended message and number.
+func canonicalizeExtensions(exts []*descriptorpb.FieldDescriptorProto) {
+	slices.SortFunc(exts, func(a, b *descriptorpb.FieldDescriptorProto) int {
+		return cmp.Or(
+			cmp.Compare(a.GetExtendee(), b.GetExtendee()),
+			cmp.Compare(a.GetNumber(), b.GetNumber()),
+		)
+	})
+}
+
+// sortFilesByDependency orders files by name, moving each file after the
+// files it depends on. Dependencies missing from files are ignored.
+func sortFilesByDependency(
+	files []*descriptorpb.FileDescriptorProto,
+) []*descriptorpb.FileDescriptorProto {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(files))
+	for _, file := range files {
+		byName[file.GetName()] = file
+	}
+	slices.SortFunc(files, func(a, b *descriptorpb.FileDescriptorProto) int {
+		return cmp.Compare(a.GetName(), b.GetName())
+	})
+
+	sorted := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	visited := make(map[string]bool, len(files))
+	var visit func(file *descriptorpb.FileDescriptorProto)
+	visit = func(file *descriptorpb.FileDescriptorProto) {
+		if visited[file.GetName()] {
+			return
+		}
+		visited[file.GetName()] = true
+		deps := slices.Sorted(slices.Values(file.GetDependency()))
+		for _, dep := range deps {
+			if depFile := byName[dep]; depFile != nil {
+				visit(depFile)
+			}
+		}
+		sorted = append(sorted, file)
+	}
+	for _, file := range files {
+		visit(file)
+	}
+	return sorted
+}
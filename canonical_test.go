@@ -0,0 +1,142 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCanonicalizeDescriptorSet(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a/schema.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "common.proto";
+
+message Person {
+  string name = 1;
+  int32 age = 2;
+  Common common = 3;
+}
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_DELETED = 2;
+}
+
+message Address {
+  string street = 1;
+  string city = 2;
+}
+`)},
+		"b/schema.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "common.proto";
+
+message Address {
+  string city = 2;
+  string street = 1;
+}
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_DELETED = 2;
+  STATUS_ACTIVE = 1;
+}
+
+message Person {
+  Common common = 3;
+  int32 age = 2;
+  string name = 1;
+}
+`)},
+		"common.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package test; message Common {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	var canonical [][]byte
+	for _, dir := range []string{"/a", "/b"} {
+		data, err := p.Compile(ctx, CompileOptions{
+			IncludePaths:      []string{dir, "/"},
+			Files:             []string{"schema.proto"},
+			IncludeImports:    true,
+			IncludeSourceInfo: true,
+		})
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		data, err = CanonicalizeDescriptorSet(data)
+		if err != nil {
+			t.Fatalf("CanonicalizeDescriptorSet failed: %v", err)
+		}
+		canonical = append(canonical, data)
+	}
+	if !bytes.Equal(canonical[0], canonical[1]) {
+		t.Fatal("expected identical canonical descriptor sets")
+	}
+
+	// The canonical set still builds a registry.
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(canonical[0], set); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Fatalf("NewFiles failed: %v", err)
+	}
+	if name := set.GetFile()[0].GetName(); name != "common.proto" {
+		t.Errorf("expected common.proto first, got %s", name)
+	}
+}
+
+func TestCanonicalizeDescriptorSetOneofs(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"m.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package t;
+message M {
+  oneof o {
+    int32 a = 1;
+    int32 b = 3;
+  }
+  int32 c = 2;
+  optional int32 d = 5;
+  int32 e = 4;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+	data, err := p.Compile(ctx, CompileOptions{Files: []string{"m.proto"}})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	data, err = CanonicalizeDescriptorSet(data)
+	if err != nil {
+		t.Fatalf("CanonicalizeDescriptorSet failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Fatalf("NewFiles failed: %v", err)
+	}
+	var names []string
+	for _, field := range set.GetFile()[0].GetMessageType()[0].GetField() {
+		names = append(names, field.GetName())
+	}
+	if !slices.Equal(names, []string{"a", "b", "c", "e", "d"}) {
+		t.Errorf("unexpected field order %v", names)
+	}
+}
@@ -0,0 +1,80 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// RunCLI runs protoc like the protoc binary, for porting scripts that invoke
+// it. args are the arguments following the program name. workingFS is the
+// working directory, so relative paths in -I flags, input files and outputs
+// are resolved against it; it must be a *MemFS for protoc to write outputs
+// into it. The captured stdout and stderr are returned with the exit code.
+//
+// RunCLI creates a Protoc instance for every call, so r must not hold
+// another instance; it removes the modules it added from r when done. Use
+// NewProtoc to run protoc repeatedly.
+func RunCLI(ctx context.Context, r wazero.Runtime, args []string, workingFS fs.FS) (exitCode int, stdout, stderr []byte, err error) {
+	var outBuf, errBuf bytes.Buffer
+	cfg := &Config{
+		Stdout: &outBuf,
+		Stderr: &errBuf,
+		FS:     workingFS,
+	}
+	err = withTransientProtoc(ctx, r, cfg, func(p *Protoc) error {
+		var err error
+		exitCode, err = p.Run(ctx, append([]string{"protoc"}, args...))
+		return err
+	})
+	if err != nil {
+		return 1, nil, nil, err
+	}
+	return exitCode, outBuf.Bytes(), errBuf.Bytes(), nil
+}
+
+// withTransientProtoc creates and initializes a Protoc instance in r, calls
+// fn with it and removes it and the modules it added from r again, so that
+// r can be reused. r must not hold another instance.
+func withTransientProtoc(ctx context.Context, r wazero.Runtime, cfg *Config, fn func(p *Protoc) error) error {
+	return withTransientModules(ctx, r, func() error {
+		p, err := NewProtoc(ctx, r, cfg)
+		if err != nil {
+			return err
+		}
+		defer p.Close(ctx)
+
+		if err := p.Init(ctx); err != nil {
+			return err
+		}
+		return fn(p)
+	})
+}
+
+// withTransientModules calls fn, which brings up a Protoc instance in r,
+// and closes the host and WASI modules it instantiated afterwards. Modules
+// that were already in r, such as a WASI module of the caller, are left in
+// place. r must not hold another instance.
+func withTransientModules(ctx context.Context, r wazero.Runtime, fn func() error) error {
+	if r.Module(ImportModuleProtoc) != nil {
+		return errors.New("runtime already holds a protoc instance")
+	}
+	var added []string
+	for _, name := range []string{ImportModuleProtoc, wasi_snapshot_preview1.ModuleName} {
+		if r.Module(name) == nil {
+			added = append(added, name)
+		}
+	}
+	defer func() {
+		for _, name := range added {
+			if mod := r.Module(name); mod != nil {
+				mod.Close(ctx)
+			}
+		}
+	}()
+	return fn()
+}
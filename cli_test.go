@@ -0,0 +1,82 @@
+package protoc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestRunCLI(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+
+	workingFS, err := NewWritableMapFS(map[string][]byte{
+		"foo.proto": []byte(`syntax = "proto3"; package foo; message Foo {}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// protoc -I. --descriptor_set_out=out.pb foo.proto
+	exitCode, _, stderr, err := RunCLI(ctx, r, []string{"-I.", "--descriptor_set_out=out.pb", "foo.proto"}, workingFS)
+	if err != nil {
+		t.Fatalf("RunCLI failed: %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("protoc exited with code %d: %s", exitCode, stderr)
+	}
+	data, err := workingFS.ReadFile("out.pb")
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.GetFile()) != 1 || set.GetFile()[0].GetName() != "foo.proto" {
+		t.Errorf("unexpected descriptor set: %v", set)
+	}
+
+	exitCode, _, stderr, err = RunCLI(ctx, r, []string{"-I.", "--descriptor_set_out=out.pb", "missing.proto"}, workingFS)
+	if err != nil {
+		t.Fatalf("RunCLI failed: %v", err)
+	}
+	if exitCode == 0 || !strings.Contains(string(stderr), "missing.proto") {
+		t.Errorf("expected failure mentioning missing.proto, got code %d: %s", exitCode, stderr)
+	}
+
+	exitCode, stdout, _, err := RunCLI(ctx, r, []string{"--version"}, nil)
+	if err != nil || exitCode != 0 || !strings.HasPrefix(string(stdout), "libprotoc ") {
+		t.Errorf("unexpected --version result %d %q: %v", exitCode, stdout, err)
+	}
+}
+
+func TestRunCLIKeepsCallerModules(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+
+	// A WASI module instantiated by the caller is used and not closed.
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	exitCode, stdout, _, err := RunCLI(ctx, r, []string{"--version"}, nil)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("RunCLI failed: %d, %v", exitCode, err)
+	}
+	if !strings.HasPrefix(string(stdout), "libprotoc") {
+		t.Errorf("unexpected version output %q", stdout)
+	}
+	if r.Module(wasi_snapshot_preview1.ModuleName) == nil {
+		t.Error("expected the caller's WASI module to be kept")
+	}
+	if r.Module(ImportModuleProtoc) != nil {
+		t.Error("expected the host module to be removed")
+	}
+}
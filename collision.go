@@ -0,0 +1,77 @@
+package protoc
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Collision is a fully-qualified name declared by more than one file.
+type Collision struct {
+	// Name is the fully-qualified name of the message, enum or service.
+	Name string
+	// Files are the files declaring Name, in the order of the files passed
+	// to DetectSymbolCollisions.
+	Files []string
+}
+
+// DetectSymbolCollisions compiles each of files on its own and returns the
+// fully-qualified message, enum and service names, including nested ones,
+// declared by more than one of them, sorted by name. protoc rejects such
+// duplicates only within a single compilation, so this finds conflicts
+// between files that are never compiled together, e.g. before merging
+// them. File names are cleaned and must be relative to an include path.
+//
+// If protoc fails for a file the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) DetectSymbolCollisions(ctx context.Context, includePaths, files []string) ([]Collision, error) {
+	declared := make(map[string][]string)
+	var seen []string
+	for _, file := range files {
+		file = path.Clean(file)
+		if slices.Contains(seen, file) {
+			continue
+		}
+		seen = append(seen, file)
+
+		set, err := p.compileDescriptorSet(ctx, CompileOptions{
+			IncludePaths: includePaths,
+			Files:        []string{file},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, fd := range set.GetFile() {
+			add := func(name string) {
+				declared[name] = append(declared[name], fd.GetName())
+			}
+			addEnums := func(scope string, enums []*descriptorpb.EnumDescriptorProto) {
+				for _, enum := range enums {
+					add(qualifiedName(scope, enum.GetName()))
+				}
+			}
+			addEnums(fd.GetPackage(), fd.GetEnumType())
+			forEachMessage(fd.GetPackage(), fd.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+				add(name)
+				addEnums(name, msg.GetEnumType())
+			})
+			for _, svc := range fd.GetService() {
+				add(qualifiedName(fd.GetPackage(), svc.GetName()))
+			}
+		}
+	}
+
+	var collisions []Collision
+	for name, declaredBy := range declared {
+		if len(declaredBy) > 1 {
+			collisions = append(collisions, Collision{Name: name, Files: declaredBy})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Name < collisions[j].Name
+	})
+	return collisions, nil
+}
@@ -0,0 +1,51 @@
+package protoc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocDetectSymbolCollisions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package pkg;
+message Shared { enum Kind { KIND_UNSPECIFIED = 0; } }
+message OnlyA {}
+service Svc {}
+`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package pkg;
+message Shared { enum Kind { KIND_UNSPECIFIED = 0; } }
+enum Svc { SVC_UNSPECIFIED = 0; }
+`)},
+		"c.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package other; message Shared {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	collisions, err := p.DetectSymbolCollisions(ctx, nil, []string{"a.proto", "./b.proto", "c.proto", "b.proto"})
+	if err != nil {
+		t.Fatalf("DetectSymbolCollisions failed: %v", err)
+	}
+	expected := []Collision{
+		{Name: "pkg.Shared", Files: []string{"a.proto", "b.proto"}},
+		{Name: "pkg.Shared.Kind", Files: []string{"a.proto", "b.proto"}},
+		{Name: "pkg.Svc", Files: []string{"a.proto", "b.proto"}},
+	}
+	if !reflect.DeepEqual(collisions, expected) {
+		t.Errorf("unexpected collisions %+v", collisions)
+	}
+
+	collisions, err = p.DetectSymbolCollisions(ctx, nil, []string{"a.proto", "c.proto"})
+	if err != nil {
+		t.Fatalf("DetectSymbolCollisions failed: %v", err)
+	}
+	if len(collisions) != 0 {
+		t.Errorf("expected no collisions, got %+v", collisions)
+	}
+}
@@ -0,0 +1,226 @@
+package protoc
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Incompatibility describes a field whose encoding changed incompatibly.
+type Incompatibility struct {
+	// Message is the fully-qualified name of the message.
+	Message string
+	// Field is the field number.
+	Field int32
+	// Reason describes the change.
+	Reason string
+}
+
+// String formats the incompatibility as "message field N: reason".
+func (i Incompatibility) String() string {
+	return fmt.Sprintf("%s field %d: %s", i.Message, i.Field, i.Reason)
+}
+
+// fieldEncoding identifies how a field value is encoded on the wire. Types
+// with the same encoding can be decoded as each other.
+type fieldEncoding int
+
+const (
+	encodingVarint fieldEncoding = iota
+	encodingZigZag
+	encodingFixed32
+	encodingFloat
+	encodingFixed64
+	encodingDouble
+	encodingBytes
+	encodingGroup
+)
+
+// encodingOf returns the encoding of a field type. Fixed-width integers and
+// floating point share a wire type but not an interpretation, so they are
+// kept apart.
+func encodingOf(typ descriptorpb.FieldDescriptorProto_Type) fieldEncoding {
+	switch typ {
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SINT64:
+		return encodingZigZag
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return encodingFixed32
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return encodingFloat
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return encodingFixed64
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return encodingDouble
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return encodingBytes
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return encodingGroup
+	default:
+		// int32, int64, uint32, uint64, bool and enum.
+		return encodingVarint
+	}
+}
+
+// WireCompatible compares two encoded FileDescriptorSets and reports whether
+// messages encoded with the old schema decode correctly with the new one.
+//
+// Only the binary encoding is checked: a field number kept in a message must
+// keep a compatible encoding, e.g. int32 to int64 is compatible but int32 to
+// string is not. A message or enum field may change to another type only if
+// that type is compatible in turn: messages are compared field by field
+// like this, and enums must keep the numbers of all old values. Repeated
+// scalar fields must stay repeated, since packed repeated values cannot be
+// read as a singular field; singular fields can become repeated. Renames,
+// removed fields and removed messages do not affect the wire format and are
+// allowed.
+func WireCompatible(old, new []byte) (bool, []Incompatibility, error) {
+	c := &wireComparison{comparing: make(map[[2]string]bool)}
+	var err error
+	c.oldMsgs, c.oldEnums, err = descriptorSetTypes(old)
+	if err != nil {
+		return false, nil, err
+	}
+	c.newMsgs, c.newEnums, err = descriptorSetTypes(new)
+	if err != nil {
+		return false, nil, err
+	}
+
+	names := make([]string, 0, len(c.oldMsgs))
+	for name := range c.oldMsgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var incompat []Incompatibility
+	for _, name := range names {
+		newMsg, ok := c.newMsgs[name]
+		if !ok {
+			continue
+		}
+		newFields := make(map[int32]*descriptorpb.FieldDescriptorProto, len(newMsg.GetField()))
+		for _, field := range newMsg.GetField() {
+			newFields[field.GetNumber()] = field
+		}
+		for _, oldField := range c.oldMsgs[name].GetField() {
+			newField, ok := newFields[oldField.GetNumber()]
+			if !ok {
+				continue
+			}
+			if reason := c.fieldIncompatibility(oldField, newField); reason != "" {
+				incompat = append(incompat, Incompatibility{
+					Message: name,
+					Field:   oldField.GetNumber(),
+					Reason:  reason,
+				})
+			}
+		}
+	}
+	return len(incompat) == 0, incompat, nil
+}
+
+// wireComparison holds the types of the old and new descriptor sets of
+// WireCompatible, keyed by fully-qualified name.
+type wireComparison struct {
+	oldMsgs, newMsgs   map[string]*descriptorpb.DescriptorProto
+	oldEnums, newEnums map[string]*descriptorpb.EnumDescriptorProto
+	// comparing holds the pairs of old and new type names being compared,
+	// which are assumed compatible so that recursive messages terminate.
+	comparing map[[2]string]bool
+}
+
+// fieldIncompatibility returns why newField cannot decode values of
+// oldField, or "" if it can.
+func (c *wireComparison) fieldIncompatibility(oldField, newField *descriptorpb.FieldDescriptorProto) string {
+	oldType, newType := oldField.GetType(), newField.GetType()
+	oldEnc := encodingOf(oldType)
+	if oldEnc != encodingOf(newType) {
+		return fmt.Sprintf("type changed from %s to %s", typeName(oldType), typeName(newType))
+	}
+	oldName := strings.TrimPrefix(oldField.GetTypeName(), ".")
+	newName := strings.TrimPrefix(newField.GetTypeName(), ".")
+	if oldType == newType && oldName != newName && !c.typeCompatible(oldType, oldName, newName) {
+		// Messages and enums of another type share the encoding but not
+		// necessarily the meaning of their fields and values.
+		return fmt.Sprintf("%s type changed from %s to %s", typeName(oldType), oldName, newName)
+	}
+	// Repeated fields accept both packed and unpacked values, but a
+	// singular scalar field cannot read packed values.
+	oldRepeated := oldField.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	newRepeated := newField.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	if oldRepeated && !newRepeated && oldEnc != encodingBytes && oldEnc != encodingGroup {
+		return fmt.Sprintf("cardinality of %s field changed between repeated and singular", typeName(oldType))
+	}
+	return ""
+}
+
+// typeCompatible reports whether values of the message or enum oldName can
+// be read as newName. Types missing from either set are incompatible.
+func (c *wireComparison) typeCompatible(typ descriptorpb.FieldDescriptorProto_Type, oldName, newName string) bool {
+	key := [2]string{oldName, newName}
+	if c.comparing[key] {
+		return true
+	}
+	c.comparing[key] = true
+	defer delete(c.comparing, key)
+
+	if typ == descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+		oldEnum, newEnum := c.oldEnums[oldName], c.newEnums[newName]
+		if oldEnum == nil || newEnum == nil {
+			return false
+		}
+		for _, oldValue := range oldEnum.GetValue() {
+			if !slices.ContainsFunc(newEnum.GetValue(), func(newValue *descriptorpb.EnumValueDescriptorProto) bool {
+				return newValue.GetNumber() == oldValue.GetNumber()
+			}) {
+				return false
+			}
+		}
+		return true
+	}
+	oldMsg, newMsg := c.oldMsgs[oldName], c.newMsgs[newName]
+	if oldMsg == nil || newMsg == nil {
+		return false
+	}
+	for _, oldField := range oldMsg.GetField() {
+		for _, newField := range newMsg.GetField() {
+			if newField.GetNumber() == oldField.GetNumber() && c.fieldIncompatibility(oldField, newField) != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// typeName returns the proto type name of typ, e.g. "int32".
+func typeName(typ descriptorpb.FieldDescriptorProto_Type) string {
+	return strings.ToLower(strings.TrimPrefix(typ.String(), "TYPE_"))
+}
+
+// descriptorSetTypes decodes a FileDescriptorSet and returns its messages
+// and enums keyed by fully-qualified name.
+func descriptorSetTypes(data []byte) (map[string]*descriptorpb.DescriptorProto, map[string]*descriptorpb.EnumDescriptorProto, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, nil, err
+	}
+	msgs := make(map[string]*descriptorpb.DescriptorProto)
+	enums := make(map[string]*descriptorpb.EnumDescriptorProto)
+	for _, file := range set.GetFile() {
+		for _, enum := range file.GetEnumType() {
+			enums[qualifiedName(file.GetPackage(), enum.GetName())] = enum
+		}
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			msgs[name] = msg
+			for _, enum := range msg.GetEnumType() {
+				enums[qualifiedName(name, enum.GetName())] = enum
+			}
+		})
+	}
+	return msgs, enums, nil
+}
@@ -0,0 +1,153 @@
+package protoc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWireCompatible(t *testing.T) {
+	ctx := context.Background()
+	memFS, err := NewWritableMapFS(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	compile := func(src string) []byte {
+		t.Helper()
+		if err := memFS.WriteFile("event.proto", []byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := p.Compile(ctx, CompileOptions{Files: []string{"event.proto"}})
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		return data
+	}
+
+	old := compile(`
+syntax = "proto3";
+package test;
+
+message Event {
+  int32 id = 1;
+  string name = 2;
+  repeated int32 tags = 3;
+  Foo foo = 5;
+  Kind kind = 6;
+  int32 count = 7;
+  Node node = 8;
+}
+
+message Foo { int32 a = 1; }
+message Bar { string a = 1; }
+message Node { Node next = 1; int32 value = 2; }
+enum Kind { KIND_UNSPECIFIED = 0; KIND_A = 1; }
+enum Other { OTHER_UNSPECIFIED = 0; }
+`)
+
+	for _, tt := range []struct {
+		name     string
+		src      string
+		expected []Incompatibility
+	}{{
+		name: "Int32ToInt64",
+		src: `
+syntax = "proto3";
+package test;
+
+message Event {
+  int64 id = 1;
+  bytes title = 2;
+  repeated int32 tags = 3;
+  bool extra = 4;
+}
+`,
+	}, {
+		name: "Int32ToString",
+		src: `
+syntax = "proto3";
+package test;
+
+message Event {
+  string id = 1;
+  string name = 2;
+  int32 tags = 3;
+}
+`,
+		expected: []Incompatibility{
+			{Message: "test.Event", Field: 1, Reason: "type changed from int32 to string"},
+			{Message: "test.Event", Field: 3, Reason: "cardinality of int32 field changed between repeated and singular"},
+		},
+	}, {
+		name: "TypeNames",
+		src: `
+syntax = "proto3";
+package test;
+
+message Event {
+  Bar foo = 5;
+  Other kind = 6;
+  repeated int32 count = 7;
+}
+
+message Foo { int32 a = 1; }
+message Bar { string a = 1; }
+enum Kind { KIND_UNSPECIFIED = 0; KIND_A = 1; }
+enum Other { OTHER_UNSPECIFIED = 0; }
+`,
+		expected: []Incompatibility{
+			{Message: "test.Event", Field: 5, Reason: "message type changed from test.Foo to test.Bar"},
+			{Message: "test.Event", Field: 6, Reason: "enum type changed from test.Kind to test.Other"},
+		},
+	}, {
+		name: "RenamedTypes",
+		src: `
+syntax = "proto3";
+package test;
+
+message Event {
+  Renamed foo = 5;
+  KindRenamed kind = 6;
+  List node = 8;
+}
+
+message Renamed { int64 a = 1; }
+message List { List next = 1; int32 value = 2; }
+enum KindRenamed { KIND_RENAMED_UNSPECIFIED = 0; KIND_RENAMED_A = 1; KIND_RENAMED_B = 2; }
+`,
+	}, {
+		name: "RenamedRecursiveType",
+		src: `
+syntax = "proto3";
+package test;
+
+message Event {
+  List node = 8;
+}
+
+message List { List next = 1; string value = 2; }
+`,
+		expected: []Incompatibility{
+			{Message: "test.Event", Field: 8, Reason: "message type changed from test.Node to test.List"},
+		},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, incompat, err := WireCompatible(old, compile(tt.src))
+			if err != nil {
+				t.Fatalf("WireCompatible failed: %v", err)
+			}
+			if ok != (len(tt.expected) == 0) {
+				t.Errorf("expected compatible=%v, got %v", len(tt.expected) == 0, ok)
+			}
+			if len(incompat) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, incompat)
+			}
+			for i := range tt.expected {
+				if incompat[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected[i], incompat[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,561 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// descriptorSetFile is the scratch file the compile helpers write the
+	// descriptor set to.
+	descriptorSetFile = "descriptor_set.pb"
+	// generatorOutDir is the scratch directory generators write to.
+	generatorOutDir = "out"
+	// inputDescriptorSetFile is the scratch file holding descriptors passed
+	// in by the caller.
+	inputDescriptorSetFile = "input.pb"
+
+	// singleFileName is the name CompileSingle compiles its source as.
+	singleFileName = "input.proto"
+
+	// stripNonfunctionalFlag is the protoc flag set by
+	// CompileOptions.StripNonfunctional.
+	stripNonfunctionalFlag = "--experimental_strip_nonfunctional_codegen"
+)
+
+// ErrStripNonfunctionalUnsupported is returned when
+// CompileOptions.StripNonfunctional is set but the protoc build does not
+// support --experimental_strip_nonfunctional_codegen.
+var ErrStripNonfunctionalUnsupported = errors.New("protoc build does not support " + stripNonfunctionalFlag)
+
+// CompileOptions configures a compilation to a FileDescriptorSet.
+//
+// The well-known types (google/protobuf/*.proto) are always available as
+// imports, even if they are not present in the filesystem.
+type CompileOptions struct {
+	// IncludePaths are the directories searched for imports.
+	// Default: the filesystem root.
+	IncludePaths []string
+	// Files are the .proto files to compile.
+	Files []string
+	// IncludeImports includes all dependencies of Files in the set, so that
+	// the set is self-contained. This includes files reached through
+	// `import public`, which are recorded in public_dependency.
+	IncludeImports bool
+	// IncludeSourceInfo retains source code info, such as locations and
+	// comments, in the set.
+	IncludeSourceInfo bool
+	// RetainOptions retains options that protoc strips by default, such as
+	// custom options declared with source retention.
+	RetainOptions bool
+	// StripNonfunctional passes --experimental_strip_nonfunctional_codegen,
+	// which strips output that does not affect behavior, such as version
+	// comments, for reproducibility checks. protoc only supports the flag
+	// in some builds; the embedded build does not, and the compile fails
+	// with ErrStripNonfunctionalUnsupported.
+	StripNonfunctional bool
+}
+
+// args returns the protoc arguments for o, excluding the output.
+func (o *CompileOptions) args() []string {
+	var args []string
+	if o.IncludeImports {
+		args = append(args, "--include_imports")
+	}
+	if o.IncludeSourceInfo {
+		args = append(args, "--include_source_info")
+	}
+	if o.RetainOptions {
+		args = append(args, "--retain_options")
+	}
+	if o.StripNonfunctional {
+		args = append(args, stripNonfunctionalFlag)
+	}
+	args = append(args, includeArgs(o.IncludePaths)...)
+	return append(args, o.Files...)
+}
+
+// Compile compiles opts.Files and returns the encoded FileDescriptorSet.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) Compile(ctx context.Context, opts CompileOptions) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		return nil, err
+	}
+	return res.descSet, nil
+}
+
+// WriteDescriptorSet compiles opts.Files like Compile and writes the encoded
+// FileDescriptorSet to w, returning the number of bytes written. The set is
+// moved out of the in-memory filesystem protoc writes it to rather than
+// copied, so it is held in memory only once, which matters for large sets
+// piped to a file or the network.
+//
+// If protoc fails the returned error is a *CompileError and nothing is
+// written. Init() must be called first.
+func (p *Protoc) WriteDescriptorSet(ctx context.Context, opts CompileOptions, w io.Writer) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, opts, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := res.err(); err != nil {
+		return 0, err
+	}
+	return w.Write(res.descSet)
+}
+
+// CompileBoth compiles opts.Files like Compile and returns the
+// FileDescriptorSet both encoded in binary and as protojson, without
+// compiling twice.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) CompileBoth(ctx context.Context, opts CompileOptions) (binary, json []byte, err error) {
+	binary, err = p.Compile(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(binary, set); err != nil {
+		return nil, nil, err
+	}
+	json, err = protojson.Marshal(set)
+	if err != nil {
+		return nil, nil, err
+	}
+	return binary, json, nil
+}
+
+// SelfContainedDescriptorSet compiles files into a set that also includes
+// all of their imports, including the well-known types, so that it can be
+// loaded with protodesc.NewFiles without any other descriptors. This is
+// the form most consumers need at runtime.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) SelfContainedDescriptorSet(ctx context.Context, includePaths, files []string) ([]byte, error) {
+	return p.Compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files, IncludeImports: true})
+}
+
+// InlineDescriptorFor compiles target into a self-contained set like
+// SelfContainedDescriptorSet, for distributing a single schema with all its
+// dependencies inlined. Files precede the files importing them, so the
+// target is always the last file in the set.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) InlineDescriptorFor(ctx context.Context, includePaths []string, target string) ([]byte, error) {
+	return p.SelfContainedDescriptorSet(ctx, includePaths, []string{target})
+}
+
+// CompileAll compiles every .proto file found under includePaths in
+// Config.FS into one descriptor set, sorted by name, so that the files don't
+// have to be listed. A file reachable from several include paths is
+// compiled once, named relative to the first. Copies of the well-known
+// types are skipped, as they are always available. CompileAll cannot list
+// the files of a Config.Resolver.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) CompileAll(ctx context.Context, includePaths []string) ([]byte, error) {
+	if p.sourceFS == nil || p.resolver != nil {
+		return nil, errors.New("CompileAll requires Config.FS")
+	}
+	dirs := includePaths
+	if len(dirs) == 0 {
+		dirs = []string{"/"}
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		root := strings.TrimPrefix(path.Clean("/"+dir), "/")
+		if root == "" {
+			root = "."
+		}
+		err := fs.WalkDir(p.sourceFS, root, func(fsPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || path.Ext(fsPath) != ".proto" || seen[fsPath] {
+				return nil
+			}
+			seen[fsPath] = true
+			name := fsPath
+			if root != "." {
+				name = strings.TrimPrefix(fsPath, root+"/")
+			}
+			if !isWellKnownType(name) {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no .proto files found in the include paths")
+	}
+	sort.Strings(files)
+	return p.Compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+}
+
+// CompileSingle compiles a single self-contained .proto source, named
+// input.proto, and returns the encoded FileDescriptorSet. It creates a
+// short-lived Protoc instance in r without a filesystem, which suits
+// one-off playground-style usage; to compile many sources, reuse an
+// instance with (*Protoc).CompileSingle instead, which avoids instantiating
+// and initializing protoc for every call.
+//
+// r must not hold another Protoc instance. If protoc fails the returned
+// error is a *CompileError.
+func CompileSingle(ctx context.Context, r wazero.Runtime, content string) (descSet []byte, err error) {
+	err = withTransientProtoc(ctx, r, nil, func(p *Protoc) error {
+		descSet, err = p.CompileSingle(ctx, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return descSet, nil
+}
+
+// CompileSingle compiles a single self-contained .proto source, named
+// input.proto, and returns the encoded FileDescriptorSet. It is a fast path
+// for playground-style usage: the source is not written to the configured
+// filesystem, and the well-known types are only loaded if content imports
+// them. Other imports cannot be resolved.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) CompileSingle(ctx context.Context, content string) ([]byte, error) {
+	// Fall back to loading the well-known types if the imports can't be
+	// scanned, letting protoc report the syntax error.
+	needWKT := true
+	if imports, err := ParseImports([]byte(content)); err == nil {
+		needWKT = slices.ContainsFunc(imports, func(imp string) bool {
+			return strings.HasPrefix(imp, "google/protobuf/")
+		})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scratch.Clear()
+	if err := p.scratch.WriteFile(path.Join(sourcesDir, singleFileName), []byte(content)); err != nil {
+		return nil, err
+	}
+	args := []string{"protoc", "--descriptor_set_out=" + path.Join(scratchDir, descriptorSetFile)}
+	if needWKT {
+		args = append(args, p.descriptorSetInArg())
+	}
+	args = append(args, "-I"+path.Join(scratchDir, sourcesDir), singleFileName)
+
+	exitCode, _, stderr, err := p.runCapture(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, &CompileError{ExitCode: exitCode, Diagnostics: ParseDiagnostics(stderr)}
+	}
+	return p.scratch.take(descriptorSetFile)
+}
+
+// CompileBundle compiles a bundle of .proto sources keyed by path, such as
+// "a.proto" and "types/b.proto", and returns the encoded FileDescriptorSet
+// of all of them. The sources import each other by these paths, and can
+// import the well-known types. Like CompileSingle it creates a short-lived
+// Protoc instance in r, which suits playgrounds with several files.
+//
+// r must not hold another Protoc instance. If protoc fails the returned
+// error is a *CompileError.
+func CompileBundle(ctx context.Context, r wazero.Runtime, files map[string]string) ([]byte, error) {
+	fsys := newMemFS()
+	names := make([]string, 0, len(files))
+	for name, content := range files {
+		if err := fsys.WriteFile(name, []byte(content)); err != nil {
+			return nil, err
+		}
+		names = append(names, path.Clean(name))
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no files to compile")
+	}
+	sort.Strings(names)
+
+	var descSet []byte
+	err := withTransientProtoc(ctx, r, &Config{FS: fsys}, func(p *Protoc) error {
+		var err error
+		descSet, err = p.Compile(ctx, CompileOptions{Files: names})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return descSet, nil
+}
+
+// compileResult is the outcome of a compile helper run.
+type compileResult struct {
+	exitCode    int
+	descSet     []byte
+	diagnostics []Diagnostic
+}
+
+// err returns a *CompileError if protoc failed.
+func (r *compileResult) err() error {
+	if r.exitCode == 0 {
+		return nil
+	}
+	return &CompileError{ExitCode: r.exitCode, Diagnostics: r.diagnostics}
+}
+
+// includeArgs returns the -I flags for includePaths.
+// If includePaths is empty the filesystem root is used.
+func includeArgs(includePaths []string) []string {
+	if len(includePaths) == 0 {
+		return []string{"-I/"}
+	}
+	args := make([]string, len(includePaths))
+	for i, includePath := range includePaths {
+		args[i] = "-I" + includePath
+	}
+	return args
+}
+
+// canonicalFilePaths returns files named relative to the longest of
+// includePaths containing them, as configured by
+// Config.CanonicalizeFilePaths. Like protoc, a file that exists in the
+// source filesystem is located relative to the root; other names are
+// import paths protoc resolves through the include paths and are only
+// cleaned. protoc resolves a relative name through the first include path
+// containing it, so an include path is only used if that resolves the
+// name back to the same file; otherwise the file is passed as given.
+func (p *Protoc) canonicalFilePaths(includePaths, files []string) []string {
+	if len(includePaths) == 0 {
+		includePaths = []string{"/"}
+	}
+	canonical := make([]string, len(files))
+	for i, file := range files {
+		canonical[i] = path.Clean(file)
+		abs := path.Join("/", file)
+		if p.sourceFS == nil {
+			continue
+		}
+		if _, err := fs.Stat(p.sourceFS, strings.TrimPrefix(abs, "/")); err != nil {
+			continue
+		}
+		best := ""
+		for _, dir := range includePaths {
+			dir = path.Join("/", dir)
+			rel, ok := strings.CutPrefix(abs, strings.TrimSuffix(dir, "/")+"/")
+			if ok && len(dir) >= len(best) && p.resolveInclude(includePaths, rel) == abs {
+				best, canonical[i] = dir, rel
+			}
+		}
+	}
+	return canonical
+}
+
+// resolveInclude returns the absolute path of the file protoc finds for the
+// relative name rel: rel in the first of includePaths containing it in the
+// source filesystem, or "" if none does.
+func (p *Protoc) resolveInclude(includePaths []string, rel string) string {
+	for _, dir := range includePaths {
+		abs := path.Join("/", dir, rel)
+		if _, err := fs.Stat(p.sourceFS, strings.TrimPrefix(abs, "/")); err == nil {
+			return abs
+		}
+	}
+	return ""
+}
+
+// preludeIncludePaths returns includePaths followed by preludeDir, if
+// Config.PreludeFiles is set.
+func (p *Protoc) preludeIncludePaths(includePaths []string) []string {
+	if p.prelude == nil {
+		return includePaths
+	}
+	if len(includePaths) == 0 {
+		includePaths = []string{"/"}
+	}
+	return append(slices.Clip(includePaths), preludeDir)
+}
+
+// compile compiles to a descriptor set in the scratch filesystem and runs
+// gens, leaving their outputs in the scratch filesystem for
+// generatorOutputs. Compile failures are reported in the result rather than
+// as an error. p.mu must be held.
+func (p *Protoc) compile(ctx context.Context, opts CompileOptions, gens []GeneratorSpec) (*compileResult, error) {
+	if p.canonicalizeFilePaths {
+		opts.Files = p.canonicalFilePaths(opts.IncludePaths, opts.Files)
+	}
+	if p.maxImportDepth > 0 {
+		if err := p.checkImportDepth(opts.IncludePaths, opts.Files); err != nil {
+			return nil, err
+		}
+	}
+	res, err := p.compileOnce(ctx, opts, gens)
+	if err != nil || res.exitCode == 0 || !p.allowMissingWeakImports {
+		return res, err
+	}
+	return p.compileWithWeakStubs(ctx, opts, gens, res)
+}
+
+// compileOnce runs a single compilation for compile. p.mu must be held.
+func (p *Protoc) compileOnce(ctx context.Context, opts CompileOptions, gens []GeneratorSpec) (*compileResult, error) {
+	p.scratch.Clear()
+
+	args := []string{"protoc", "--descriptor_set_out=" + path.Join(scratchDir, descriptorSetFile)}
+	for _, gen := range gens {
+		genArgs, err := p.generatorArgs(gen)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, genArgs...)
+	}
+	args = append(args, p.descriptorSetInArg())
+	opts.IncludePaths = p.preludeIncludePaths(opts.IncludePaths)
+	args = append(args, opts.args()...)
+
+	exitCode, _, stderr, err := p.runCapture(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &compileResult{exitCode: exitCode, diagnostics: ParseDiagnostics(stderr)}
+	if exitCode != 0 {
+		if opts.StripNonfunctional && rejectsFlag(res.diagnostics, stripNonfunctionalFlag) {
+			return nil, ErrStripNonfunctionalUnsupported
+		}
+		return res, nil
+	}
+	res.descSet, err = p.scratch.take(descriptorSetFile)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// generatorOutputs collects the files generated by gens in the last
+// compilation, keyed by generator name and then by path. p.mu must be held.
+func (p *Protoc) generatorOutputs(gens []GeneratorSpec) map[string]map[string][]byte {
+	if len(gens) == 0 {
+		return nil
+	}
+	outputs := make(map[string]map[string][]byte, len(gens))
+	for _, gen := range gens {
+		genOutputs := make(map[string][]byte)
+		for name, data := range p.scratch.files(path.Join(generatorOutDir, gen.Name)) {
+			if name := p.mapOutputPath(gen.outputPath(name)); name != "" {
+				genOutputs[name] = p.decorateOutput(name, data)
+			}
+		}
+		outputs[gen.Name] = genOutputs
+	}
+	return outputs
+}
+
+// generatorOutput reads the file generated by gen in the last compilation
+// that is collected at outputPath, without reading the other outputs.
+// p.mu must be held.
+func (p *Protoc) generatorOutput(gen GeneratorSpec, outputPath string) ([]byte, bool) {
+	root := path.Join(generatorOutDir, gen.Name)
+	var found string
+	fs.WalkDir(p.scratch, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, root+"/")
+		if p.mapOutputPath(gen.outputPath(rel)) == outputPath {
+			found = name
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if found == "" {
+		return nil, false
+	}
+	data, err := p.scratch.ReadFile(found)
+	if err != nil {
+		return nil, false
+	}
+	return p.decorateOutput(outputPath, data), true
+}
+
+// rejectsFlag reports whether diags contain the error protoc reports for an
+// unknown flag. Flags without a value that protoc does not know are
+// reported as missing a value instead.
+func rejectsFlag(diags []Diagnostic, flag string) bool {
+	for _, d := range diags {
+		if strings.HasPrefix(d.Message, "Unknown flag: "+flag) || d.Message == "Missing value for flag: "+flag {
+			return true
+		}
+	}
+	return false
+}
+
+// mapOutputPath applies Config.OutputPathMapper to the path of a generated
+// file. An empty result means the file is dropped.
+func (p *Protoc) mapOutputPath(name string) string {
+	if p.outputPathMapper == nil {
+		return name
+	}
+	return p.outputPathMapper(name)
+}
+
+// isText reports whether data is UTF-8 text without control characters
+// other than whitespace.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	return !slices.ContainsFunc(data, func(b byte) bool {
+		return b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' || b == 0x7f
+	})
+}
+
+// decorateOutput adds Config.FileHeader and Config.FileFooter to the
+// generated file name if it is a text file matching
+// Config.HeaderExtensions.
+func (p *Protoc) decorateOutput(name string, data []byte) []byte {
+	if p.fileHeader == "" && p.fileFooter == "" {
+		return data
+	}
+	if len(p.headerExtensions) != 0 && !slices.Contains(p.headerExtensions, path.Ext(name)) {
+		return data
+	}
+	if !isText(data) {
+		return data
+	}
+	out := make([]byte, 0, len(p.fileHeader)+len(data)+len(p.fileFooter))
+	out = append(out, p.fileHeader...)
+	out = append(out, data...)
+	return append(out, p.fileFooter...)
+}
@@ -0,0 +1,587 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtocCompileRetainOptions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"test/opts.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FileOptions {
+  string source_only = 50001 [retention = RETENTION_SOURCE];
+}
+
+option (source_only) = "retained";
+
+message Empty {}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// sourceOnly returns the raw source_only option of test/opts.proto.
+	sourceOnly := func(opts CompileOptions) []byte {
+		t.Helper()
+		data, err := p.Compile(ctx, opts)
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			t.Fatalf("invalid descriptor set: %v", err)
+		}
+		if len(set.GetFile()) != 1 || set.GetFile()[0].GetName() != "test/opts.proto" {
+			t.Fatalf("unexpected files in descriptor set: %v", set.GetFile())
+		}
+		raw := set.GetFile()[0].GetOptions().ProtoReflect().GetUnknown()
+		for len(raw) > 0 {
+			num, typ, n := protowire.ConsumeTag(raw)
+			if n < 0 {
+				t.Fatalf("invalid options: %v", protowire.ParseError(n))
+			}
+			raw = raw[n:]
+			if num == 50001 && typ == protowire.BytesType {
+				v, _ := protowire.ConsumeBytes(raw)
+				return v
+			}
+			raw = raw[protowire.ConsumeFieldValue(num, typ, raw):]
+		}
+		return nil
+	}
+
+	if v := sourceOnly(CompileOptions{Files: []string{"test/opts.proto"}}); v != nil {
+		t.Errorf("expected source retention option to be stripped, got %q", v)
+	}
+	if v := sourceOnly(CompileOptions{Files: []string{"test/opts.proto"}, RetainOptions: true}); string(v) != "retained" {
+		t.Errorf("expected retained option value, got %q", v)
+	}
+}
+
+func TestProtocCompileImportPublic(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"base.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Base {
+  string name = 1;
+}
+`)},
+		"forward.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+import public "base.proto";
+`)},
+		"user.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+import "forward.proto";
+
+message User {
+  Base base = 1;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.Compile(ctx, CompileOptions{
+		Files:          []string{"user.proto"},
+		IncludeImports: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		t.Fatalf("invalid descriptor set: %v", err)
+	}
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, file := range set.GetFile() {
+		files[file.GetName()] = file
+	}
+	if len(files) != 3 || files["base.proto"] == nil || files["user.proto"] == nil {
+		t.Fatalf("expected base, forward and user in descriptor set, got: %v", keys(files))
+	}
+
+	forward := files["forward.proto"]
+	if deps := forward.GetDependency(); len(deps) != 1 || deps[0] != "base.proto" {
+		t.Fatalf("unexpected forward.proto dependencies: %v", deps)
+	}
+	if pub := forward.GetPublicDependency(); len(pub) != 1 || pub[0] != 0 {
+		t.Errorf("expected public dependency index 0, got: %v", pub)
+	}
+	if typ := files["user.proto"].GetMessageType()[0].GetField()[0].GetTypeName(); typ != ".test.Base" {
+		t.Errorf("expected field type .test.Base, got %q", typ)
+	}
+}
+
+func TestProtocCompileError(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"bad.proto"}})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError, got: %v", err)
+	}
+	if len(compileErr.Diagnostics) == 0 {
+		t.Error("expected diagnostics")
+	}
+}
+
+func TestProtocCompileStripNonfunctional(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message A {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// The embedded build does not support the flag, which must be reported
+	// clearly rather than as a generic compile error.
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}, StripNonfunctional: true})
+	if !errors.Is(err, ErrStripNonfunctionalUnsupported) {
+		t.Fatalf("expected ErrStripNonfunctionalUnsupported, got: %v", err)
+	}
+
+	// The instance remains usable.
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}}); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+}
+
+func TestProtocPreludeFiles(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"src/order.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "acme/base/money.proto";
+message Order { acme.base.Money total = 1; }
+`)},
+	}
+	prelude := map[string][]byte{
+		"acme/base/money.proto": []byte(`syntax = "proto3"; package acme.base; message Money { int64 cents = 1; }`),
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS, PreludeFiles: prelude})
+
+	data, err := p.Compile(ctx, CompileOptions{
+		IncludePaths:   []string{"/src"},
+		Files:          []string{"order.proto"},
+		IncludeImports: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	if !slices.Equal(names, []string{"acme/base/money.proto", "order.proto"}) {
+		t.Errorf("unexpected files %v", names)
+	}
+
+	// The default include path is kept.
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"src/order.proto"}}); err != nil {
+		t.Errorf("Compile failed: %v", err)
+	}
+}
+
+func TestProtocWriteDescriptorSet(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+	opts := CompileOptions{
+		IncludePaths: []string{"/testdata/protos"},
+		Files:        []string{"example/v1/greeter.proto"},
+	}
+
+	name := filepath.Join(t.TempDir(), "greeter.pb")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.WriteDescriptorSet(ctx, opts, f)
+	if err != nil {
+		t.Fatalf("WriteDescriptorSet failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("expected %d bytes written, got %d", len(data), n)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatalf("invalid descriptor set: %v", err)
+	}
+	if len(set.GetFile()) != 1 || set.GetFile()[0].GetName() != "example/v1/greeter.proto" {
+		t.Errorf("unexpected descriptor set %v", set)
+	}
+}
+
+func TestProtocCompileAll(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"proto/acme/v1/user.proto":          &fstest.MapFile{Data: []byte(`syntax = "proto3"; package acme.v1; import "acme/v1/role.proto"; message User { Role role = 1; }`)},
+		"proto/acme/v1/role.proto":          &fstest.MapFile{Data: []byte(`syntax = "proto3"; package acme.v1; message Role {}`)},
+		"proto/acme/v2/user.proto":          &fstest.MapFile{Data: []byte(`syntax = "proto3"; package acme.v2; import "google/protobuf/empty.proto"; message User {}`)},
+		"proto/google/protobuf/empty.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package google.protobuf; message Empty {}`)},
+		"proto/README.md":                   &fstest.MapFile{Data: []byte("# protos")},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.CompileAll(ctx, []string{"/proto"})
+	if err != nil {
+		t.Fatalf("CompileAll failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	slices.Sort(names)
+	expected := []string{"acme/v1/role.proto", "acme/v1/user.proto", "acme/v2/user.proto"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	if _, err := p.CompileAll(ctx, []string{"/missing"}); err == nil {
+		t.Error("expected error for a missing include path")
+	}
+}
+
+func TestCompileSingle(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+
+	// The runtime can be reused, and well-known types load when imported.
+	for _, src := range []string{
+		`syntax = "proto3"; package single; message A { string name = 1; }`,
+		`syntax = "proto3"; package single; import "google/protobuf/timestamp.proto"; message A { google.protobuf.Timestamp at = 1; }`,
+	} {
+		data, err := CompileSingle(ctx, r, src)
+		if err != nil {
+			t.Fatalf("CompileSingle failed: %v", err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			t.Fatal(err)
+		}
+		if len(set.GetFile()) != 1 || set.GetFile()[0].GetName() != "input.proto" {
+			t.Errorf("unexpected descriptor set: %v", set)
+		}
+	}
+
+	_, err := CompileSingle(ctx, r, `syntax = "proto3"; import "other.proto";`)
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError, got: %v", err)
+	}
+}
+
+func TestProtocCompileSingle(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{})
+
+	for _, pkg := range []string{"one", "two"} {
+		data, err := p.CompileSingle(ctx, `syntax = "proto3"; package `+pkg+`; message A {}`)
+		if err != nil {
+			t.Fatalf("CompileSingle failed: %v", err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			t.Fatal(err)
+		}
+		if len(set.GetFile()) != 1 || set.GetFile()[0].GetPackage() != pkg {
+			t.Errorf("unexpected descriptor set: %v", set)
+		}
+	}
+}
+
+func TestCompileBundle(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+
+	data, err := CompileBundle(ctx, r, map[string]string{
+		"shop/order.proto": `
+syntax = "proto3";
+package shop;
+import "shop/types/money.proto";
+import "google/protobuf/timestamp.proto";
+message Order { types.Money total = 1; google.protobuf.Timestamp at = 2; }
+`,
+		"shop/types/money.proto": `syntax = "proto3"; package shop.types; message Money { int64 cents = 1; }`,
+	})
+	if err != nil {
+		t.Fatalf("CompileBundle failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"shop/order.proto", "shop/types/money.proto"}) {
+		t.Errorf("unexpected files %v", names)
+	}
+
+	_, err = CompileBundle(ctx, r, map[string]string{"a.proto": `syntax = "proto3"; import "b.proto";`})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError, got: %v", err)
+	}
+}
+
+// BenchmarkCompileSingle compares compiling a source on a transient
+// instance, as the package-level CompileSingle does, with compiling it on a
+// reused instance.
+func BenchmarkCompileSingle(b *testing.B) {
+	ctx := context.Background()
+	const src = `syntax = "proto3"; package single; message A { string name = 1; }`
+
+	b.Run("Transient", func(b *testing.B) {
+		r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+		defer r.Close(ctx)
+		for i := 0; i < b.N; i++ {
+			if _, err := CompileSingle(ctx, r, src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Reused", func(b *testing.B) {
+		p := newTestProtoc(b, &Config{})
+		for i := 0; i < b.N; i++ {
+			if _, err := p.CompileSingle(ctx, src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ReusedCompile", func(b *testing.B) {
+		p := newTestProtoc(b, &Config{FS: fstest.MapFS{"input.proto": &fstest.MapFile{Data: []byte(src)}}})
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Compile(ctx, CompileOptions{Files: []string{"input.proto"}}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProtocSelfContainedDescriptorSet(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"proto/event.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "google/protobuf/timestamp.proto";
+import "user.proto";
+message Event {
+  google.protobuf.Timestamp time = 1;
+  User user = 2;
+}
+`)},
+		"proto/user.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package test; message User {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.SelfContainedDescriptorSet(ctx, []string{"proto"}, []string{"event.proto"})
+	if err != nil {
+		t.Fatalf("SelfContainedDescriptorSet failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("set is not self-contained: %v", err)
+	}
+	for _, name := range []string{"google/protobuf/timestamp.proto", "user.proto", "event.proto"} {
+		if _, err := files.FindFileByPath(name); err != nil {
+			t.Errorf("expected %s in the set: %v", name, err)
+		}
+	}
+	if _, err := files.FindDescriptorByName("test.Event"); err != nil {
+		t.Errorf("expected test.Event to resolve: %v", err)
+	}
+}
+
+func TestProtocCompileBoth(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"foo.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "google/protobuf/empty.proto";
+message Foo { google.protobuf.Empty empty = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	opts := CompileOptions{Files: []string{"foo.proto"}, IncludeImports: true}
+	binary, json, err := p.CompileBoth(ctx, opts)
+	if err != nil {
+		t.Fatalf("CompileBoth failed: %v", err)
+	}
+	fromBinary := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(binary, fromBinary); err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := &descriptorpb.FileDescriptorSet{}
+	if err := protojson.Unmarshal(json, fromJSON); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(fromBinary.GetFile()) != 2 || !proto.Equal(fromBinary, fromJSON) {
+		t.Errorf("expected both encodings of the same schema, got %v and %v", fromBinary, fromJSON)
+	}
+
+	if _, _, err := p.CompileBoth(ctx, CompileOptions{Files: []string{"missing.proto"}}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestProtocCanonicalizeFilePaths(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"src/a/foo.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package a; message Foo {}`)},
+	}
+
+	// fileName compiles file and returns the name protoc gave it.
+	fileName := func(p *Protoc, includePaths []string, file string) (string, error) {
+		data, err := p.Compile(ctx, CompileOptions{IncludePaths: includePaths, Files: []string{file}, IncludeSourceInfo: true})
+		if err != nil {
+			return "", err
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			return "", err
+		}
+		return set.GetFile()[0].GetName(), nil
+	}
+
+	// protoc names the file after the first matching include path.
+	p := newTestProtoc(t, &Config{FS: memFS})
+	if name, err := fileName(p, []string{"/", "/src"}, "src/a/foo.proto"); err != nil || name != "src/a/foo.proto" {
+		t.Fatalf("expected src/a/foo.proto, got %q, %v", name, err)
+	}
+
+	p = newTestProtoc(t, &Config{FS: memFS, CanonicalizeFilePaths: true})
+	for _, tc := range []struct {
+		includePaths []string
+		file         string
+	}{
+		{[]string{"/src"}, "a/foo.proto"},
+		{[]string{"/src"}, "./a/foo.proto"},
+		{[]string{"/src"}, "/src/a/foo.proto"},
+		{[]string{"/src"}, "src/a/foo.proto"},
+		{[]string{"src"}, "/src/a/foo.proto"},
+		{[]string{"/", "/src"}, "src/a/foo.proto"},
+	} {
+		name, err := fileName(p, tc.includePaths, tc.file)
+		if err != nil {
+			t.Errorf("%v %s: Compile failed: %v", tc.includePaths, tc.file, err)
+		} else if name != "a/foo.proto" {
+			t.Errorf("%v %s: expected a/foo.proto, got %s", tc.includePaths, tc.file, name)
+		}
+	}
+
+	// A shorter name that an earlier include path resolves to another file
+	// is not used.
+	memFS["a/foo.proto"] = &fstest.MapFile{Data: []byte(`syntax = "proto3"; package wrong; message Foo {}`)}
+	memFS["src/a/foo.proto"] = &fstest.MapFile{Data: []byte(`syntax = "proto3"; package right; message Foo {}`)}
+	p = newTestProtoc(t, &Config{FS: memFS, CanonicalizeFilePaths: true})
+	data, err := p.Compile(ctx, CompileOptions{IncludePaths: []string{"/", "/src"}, Files: []string{"src/a/foo.proto"}})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	if file := set.GetFile()[0]; file.GetName() != "src/a/foo.proto" || file.GetPackage() != "right" {
+		t.Errorf("expected src/a/foo.proto in package right, got %s in %s", file.GetName(), file.GetPackage())
+	}
+}
+
+func TestProtocInlineDescriptorFor(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"api.proto":    &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "common.proto"; import "google/protobuf/any.proto"; message Api { Common c = 1; google.protobuf.Any any = 2; }`)},
+		"common.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "base.proto"; message Common { Base b = 1; }`)},
+		"base.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Base {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.InlineDescriptorFor(ctx, nil, "api.proto")
+	if err != nil {
+		t.Fatalf("InlineDescriptorFor failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"api.proto", "base.proto", "common.proto", "google/protobuf/any.proto"}) {
+		t.Errorf("expected the target with its dependencies, got %v", names)
+	}
+	if target := set.GetFile()[len(set.GetFile())-1].GetName(); target != "api.proto" {
+		t.Errorf("expected the target last, got %s", target)
+	}
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Errorf("set is not self-contained: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package protoc
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// unnamedFrameRe matches a stack trace frame of a function without a name
+// in the module's name section, e.g. ".$61(i32,i32) i32".
+var unnamedFrameRe = regexp.MustCompile(`\$(\d+)\(`)
+
+// trapError is a guest error with its stack trace symbolicated.
+type trapError struct {
+	err error
+	msg string
+}
+
+// Error returns the symbolicated error message.
+func (e *trapError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the original error.
+func (e *trapError) Unwrap() error {
+	return e.err
+}
+
+// debugNames maps function indices to export names, used to name stack
+// trace frames when the module lacks a name section.
+type debugNames struct {
+	once  sync.Once
+	names map[string]string
+}
+
+// symbolicate names the unnamed frames in the stack trace of err after the
+// exported functions, if Config.EnableDebugInfo is set.
+func (p *Protoc) symbolicate(err error) error {
+	if err == nil || p.debugNames == nil {
+		return err
+	}
+	p.debugNames.once.Do(func() {
+		p.debugNames.names = make(map[string]string)
+		for name, def := range p.compiled.ExportedFunctions() {
+			p.debugNames.names[strconv.FormatUint(uint64(def.Index()), 10)] = name
+		}
+	})
+
+	msg := unnamedFrameRe.ReplaceAllStringFunc(err.Error(), func(frame string) string {
+		idx := unnamedFrameRe.FindStringSubmatch(frame)[1]
+		if name, ok := p.debugNames.names[idx]; ok {
+			return name + "("
+		}
+		return frame
+	})
+	return &trapError{err: err, msg: msg}
+}
@@ -0,0 +1,36 @@
+package protoc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProtocEnableDebugInfo(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name     string
+		debug    bool
+		expected string
+	}{
+		{name: "Disabled", debug: false, expected: ".$"},
+		{name: "Enabled", debug: true, expected: ".protoc_run("},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProtoc(t, &Config{EnableDebugInfo: tt.debug})
+
+			// An invalid argv pointer makes protoc_run trap.
+			p.mu.Lock()
+			_, err := p.callRun(ctx, 1, 0xFFFFFFF0)
+			p.mu.Unlock()
+			if err == nil {
+				t.Fatal("expected trap error")
+			}
+			msg := err.Error()
+			if !strings.Contains(msg, "wasm stack trace") || !strings.Contains(msg, tt.expected) {
+				t.Errorf("expected stack trace containing %q, got: %s", tt.expected, msg)
+			}
+		})
+	}
+}
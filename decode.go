@@ -0,0 +1,30 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+)
+
+// DecodeRaw decodes data, a binary protobuf message of unknown type, with
+// protoc --decode_raw and returns the text format dump protoc prints, in
+// which fields are named by their numbers. This is useful to inspect
+// payloads without their schema.
+//
+// If protoc fails, e.g. because data is not a valid message, the returned
+// error is a *CompileError. Init() must be called first.
+func (p *Protoc) DecodeRaw(ctx context.Context, data []byte) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stdin.input = bytes.NewReader(data)
+	defer func() { p.stdin.input = nil }()
+
+	exitCode, stdout, stderr, err := p.runCapture(ctx, []string{"protoc", "--decode_raw"})
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", &CompileError{ExitCode: exitCode, Diagnostics: ParseDiagnostics(stderr)}
+	}
+	return string(stdout), nil
+}
@@ -0,0 +1,50 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProtocDecodeRaw(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, nil)
+
+	// Field 1: varint 150, field 2: "hello", field 3: message {field 1: 7}.
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.VarintType)
+	data = protowire.AppendVarint(data, 150)
+	data = protowire.AppendTag(data, 2, protowire.BytesType)
+	data = protowire.AppendString(data, "hello")
+	var nested []byte
+	nested = protowire.AppendTag(nested, 1, protowire.VarintType)
+	nested = protowire.AppendVarint(nested, 7)
+	data = protowire.AppendTag(data, 3, protowire.BytesType)
+	data = protowire.AppendBytes(data, nested)
+
+	text, err := p.DecodeRaw(ctx, data)
+	if err != nil {
+		t.Fatalf("DecodeRaw failed: %v", err)
+	}
+	for _, want := range []string{"1: 150", `2: "hello"`, "3 {", "  1: 7"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in output:\n%s", want, text)
+		}
+	}
+
+	// A truncated message fails.
+	_, err = p.DecodeRaw(ctx, data[:len(data)-1])
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("expected CompileError, got %v", err)
+	}
+
+	// The input is not left behind for later runs.
+	text, err = p.DecodeRaw(ctx, nil)
+	if err != nil || text != "" {
+		t.Errorf("expected empty output, got %q, %v", text, err)
+	}
+}
@@ -0,0 +1,101 @@
+package protoc
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Deprecation is a use of a message or enum marked deprecated = true.
+type Deprecation struct {
+	// File is the file containing the use.
+	File string
+	// Element is the fully-qualified name of the field, extension or method
+	// using the deprecated type.
+	Element string
+	// Deprecated is the fully-qualified name of the deprecated type.
+	Deprecated string
+}
+
+// CheckDeprecations compiles files and returns the uses of deprecated
+// messages and enums in them, in declaration order: fields and extensions
+// of a deprecated type and methods with a deprecated request or response.
+// The deprecated types may be declared in any imported file. Declaring a
+// deprecated field or type is not a use. File names are cleaned and must be
+// relative to an include path.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) CheckDeprecations(ctx context.Context, includePaths, files []string) ([]Deprecation, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deprecated := make(map[string]bool)
+	addEnums := func(scope string, enums []*descriptorpb.EnumDescriptorProto) {
+		for _, enum := range enums {
+			if enum.GetOptions().GetDeprecated() {
+				deprecated[qualifiedName(scope, enum.GetName())] = true
+			}
+		}
+	}
+	for _, file := range set.GetFile() {
+		addEnums(file.GetPackage(), file.GetEnumType())
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			if msg.GetOptions().GetDeprecated() {
+				deprecated[name] = true
+			}
+			addEnums(name, msg.GetEnumType())
+		})
+	}
+
+	targets := make([]string, len(files))
+	for i, file := range files {
+		targets[i] = path.Clean(file)
+	}
+	var uses []Deprecation
+	for _, file := range set.GetFile() {
+		if !slices.Contains(targets, file.GetName()) {
+			continue
+		}
+		addFields := func(scope string, fields []*descriptorpb.FieldDescriptorProto) {
+			for _, field := range fields {
+				if typeName := fieldTypeName(field); deprecated[typeName] {
+					uses = append(uses, Deprecation{
+						File:       file.GetName(),
+						Element:    qualifiedName(scope, field.GetName()),
+						Deprecated: typeName,
+					})
+				}
+			}
+		}
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			addFields(name, msg.GetField())
+			addFields(name, msg.GetExtension())
+		})
+		addFields(file.GetPackage(), file.GetExtension())
+		for _, svc := range file.GetService() {
+			svcName := qualifiedName(file.GetPackage(), svc.GetName())
+			for _, method := range svc.GetMethod() {
+				for _, typeName := range []string{method.GetInputType(), method.GetOutputType()} {
+					if typeName = strings.TrimPrefix(typeName, "."); deprecated[typeName] {
+						uses = append(uses, Deprecation{
+							File:       file.GetName(),
+							Element:    svcName + "." + method.GetName(),
+							Deprecated: typeName,
+						})
+					}
+				}
+			}
+		}
+	}
+	return uses, nil
+}
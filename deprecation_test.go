@@ -0,0 +1,62 @@
+package protoc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocCheckDeprecations(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"old.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+message Legacy {
+  option deprecated = true;
+}
+enum Color {
+  option deprecated = true;
+  COLOR_UNSPECIFIED = 0;
+}
+`)},
+		"api.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "old.proto";
+message Request {
+  Legacy legacy = 1;
+  string name = 2 [deprecated = true];
+  message Nested { Color color = 1; }
+}
+service Api {
+  rpc Get(Request) returns (Legacy);
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	uses, err := p.CheckDeprecations(ctx, nil, []string{"api.proto"})
+	if err != nil {
+		t.Fatalf("CheckDeprecations failed: %v", err)
+	}
+	expected := []Deprecation{
+		{File: "api.proto", Element: "test.Request.legacy", Deprecated: "test.Legacy"},
+		{File: "api.proto", Element: "test.Request.Nested.color", Deprecated: "test.Color"},
+		{File: "api.proto", Element: "test.Api.Get", Deprecated: "test.Legacy"},
+	}
+	if !reflect.DeepEqual(uses, expected) {
+		t.Errorf("expected %v, got %v", expected, uses)
+	}
+
+	// The file declaring the deprecated types does not use them.
+	uses, err = p.CheckDeprecations(ctx, nil, []string{"old.proto"})
+	if err != nil {
+		t.Fatalf("CheckDeprecations failed: %v", err)
+	}
+	if len(uses) != 0 {
+		t.Errorf("expected no uses, got %v", uses)
+	}
+}
@@ -0,0 +1,96 @@
+package protoc
+
+import (
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ImportDepthError is returned by the compilation helpers when a file's
+// import chain is longer than Config.MaxImportDepth.
+type ImportDepthError struct {
+	// MaxDepth is the configured limit.
+	MaxDepth int
+	// Chain is the offending import chain, starting with the compiled file.
+	Chain []string
+}
+
+// Error implements error.
+func (e *ImportDepthError) Error() string {
+	return "import depth exceeds " + strconv.Itoa(e.MaxDepth) + ": " + strings.Join(e.Chain, " -> ")
+}
+
+// checkImportDepth returns an *ImportDepthError if the import chain of any
+// of files is longer than p.maxImportDepth. The imports are scanned with
+// ParseImports from the source filesystem before protoc runs, so that deep
+// chains are rejected without parsing them. Files that cannot be read, such
+// as the well-known types, end a chain; protoc reports them if missing.
+func (p *Protoc) checkImportDepth(includePaths, files []string) error {
+	if len(includePaths) == 0 {
+		includePaths = []string{"/"}
+	}
+	// chains holds the longest import chain starting at each visited file.
+	chains := make(map[string][]string)
+	onStack := make(map[string]bool)
+	var longest func(name string, content []byte) []string
+	longest = func(name string, content []byte) []string {
+		if chain, ok := chains[name]; ok {
+			return chain
+		}
+		chain := []string{name}
+		imports, err := ParseImports(content)
+		if err != nil {
+			// Leave syntax errors to protoc.
+			imports = nil
+		}
+		onStack[name] = true
+		for _, imp := range imports {
+			// Import cycles are reported by protoc.
+			if onStack[imp] {
+				continue
+			}
+			impContent, ok := p.readSource(includePaths, imp)
+			if !ok {
+				continue
+			}
+			if sub := longest(imp, impContent); len(sub)+1 > len(chain) {
+				chain = append([]string{name}, sub...)
+			}
+		}
+		onStack[name] = false
+		chains[name] = chain
+		return chain
+	}
+
+	for _, file := range files {
+		content, ok := p.readSource(includePaths, file)
+		if !ok {
+			content, ok = p.readSource([]string{"/"}, file)
+		}
+		if !ok {
+			continue
+		}
+		if chain := longest(path.Clean(file), content); len(chain)-1 > p.maxImportDepth {
+			return &ImportDepthError{MaxDepth: p.maxImportDepth, Chain: chain[:p.maxImportDepth+2]}
+		}
+	}
+	return nil
+}
+
+// readSource reads name relative to the first of includePaths containing it
+// from the source filesystem, falling back to the prelude files.
+func (p *Protoc) readSource(includePaths []string, name string) ([]byte, bool) {
+	for _, dir := range includePaths {
+		fsPath := strings.TrimPrefix(path.Join("/", dir, name), "/")
+		if data, err := fs.ReadFile(p.sourceFS, fsPath); err == nil {
+			return data, true
+		}
+	}
+	if p.prelude != nil {
+		if data, err := p.prelude.ReadFile(name); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
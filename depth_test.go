@@ -0,0 +1,43 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocMaxImportDepth(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"src/a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "b.proto"; import "google/protobuf/empty.proto";`)},
+		"src/b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "c.proto";`)},
+		"src/c.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "d.proto";`)},
+		"src/d.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3";`)},
+	}
+	includePaths := []string{"/src"}
+
+	p := newTestProtoc(t, &Config{FS: memFS, MaxImportDepth: 2})
+
+	if _, err := p.Compile(ctx, CompileOptions{IncludePaths: includePaths, Files: []string{"b.proto"}}); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	_, err := p.Compile(ctx, CompileOptions{IncludePaths: includePaths, Files: []string{"a.proto"}})
+	var depthErr *ImportDepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("expected ImportDepthError, got %v", err)
+	}
+	if !slices.Equal(depthErr.Chain, []string{"a.proto", "b.proto", "c.proto", "d.proto"}) {
+		t.Errorf("unexpected chain %v", depthErr.Chain)
+	}
+	if err.Error() != "import depth exceeds 2: a.proto -> b.proto -> c.proto -> d.proto" {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	_, err = NewProtocWithModule(ctx, nil, nil, &Config{MaxImportDepth: 2})
+	if err == nil {
+		t.Error("expected error for MaxImportDepth without FS")
+	}
+}
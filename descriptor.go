@@ -0,0 +1,802 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ServiceInfo describes a service declared in a compiled file.
+type ServiceInfo struct {
+	// File is the name of the file declaring the service.
+	File string
+	// Name is the fully-qualified service name, e.g. "example.v1.Greeter".
+	Name string
+	// Methods are the service methods in declaration order.
+	Methods []MethodInfo
+}
+
+// MethodInfo describes a service method.
+type MethodInfo struct {
+	// Name is the method name, e.g. "SayHello".
+	Name string
+	// InputType is the fully-qualified request message name.
+	InputType string
+	// OutputType is the fully-qualified response message name.
+	OutputType string
+	// ClientStreaming is set if the client sends a stream of messages.
+	ClientStreaming bool
+	// ServerStreaming is set if the server sends a stream of messages.
+	ServerStreaming bool
+}
+
+// ListServices compiles files and returns the services they declare, in
+// declaration order. Services of imported files are not included.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) ListServices(ctx context.Context, includePaths, files []string) ([]ServiceInfo, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []ServiceInfo
+	for _, file := range set.GetFile() {
+		for _, svc := range file.GetService() {
+			info := ServiceInfo{
+				File:    file.GetName(),
+				Name:    qualifiedName(file.GetPackage(), svc.GetName()),
+				Methods: make([]MethodInfo, 0, len(svc.GetMethod())),
+			}
+			for _, method := range svc.GetMethod() {
+				info.Methods = append(info.Methods, MethodInfo{
+					Name:            method.GetName(),
+					InputType:       strings.TrimPrefix(method.GetInputType(), "."),
+					OutputType:      strings.TrimPrefix(method.GetOutputType(), "."),
+					ClientStreaming: method.GetClientStreaming(),
+					ServerStreaming: method.GetServerStreaming(),
+				})
+			}
+			services = append(services, info)
+		}
+	}
+	return services, nil
+}
+
+// EnumValue is a value of an enum.
+type EnumValue struct {
+	// Name is the value name, e.g. "STATUS_OK".
+	Name string
+	// Number is the value number.
+	Number int32
+}
+
+// ListEnums compiles files and returns the values of every enum they
+// declare, including enums nested in messages, keyed by fully-qualified
+// enum name. Values are in declaration order; aliases declared with
+// allow_alias appear as separate values sharing a number.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) ListEnums(ctx context.Context, includePaths, files []string) (map[string][]EnumValue, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	enums := make(map[string][]EnumValue)
+	addEnums := func(scope string, decls []*descriptorpb.EnumDescriptorProto) {
+		for _, enum := range decls {
+			values := make([]EnumValue, 0, len(enum.GetValue()))
+			for _, value := range enum.GetValue() {
+				values = append(values, EnumValue{Name: value.GetName(), Number: value.GetNumber()})
+			}
+			enums[qualifiedName(scope, enum.GetName())] = values
+		}
+	}
+	for _, file := range set.GetFile() {
+		addEnums(file.GetPackage(), file.GetEnumType())
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			addEnums(name, msg.GetEnumType())
+		})
+	}
+	return enums, nil
+}
+
+// maxFreeEnumNumbers is the maximum number of free numbers FreeEnumNumbers
+// lists per enum.
+const maxFreeEnumNumbers = 1000
+
+// FreeEnumNumbers compiles files and returns the unused value numbers of
+// every enum they declare, keyed by fully-qualified enum name, similar to
+// protoc's --print_free_field_numbers for messages.
+//
+// The free numbers are the gaps between the lowest and highest number that
+// is used by a value or reserved, in ascending order and limited to the
+// first 1000. Every number above the highest is also free. An enum without
+// gaps maps to an empty list.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) FreeEnumNumbers(ctx context.Context, includePaths, files []string) (map[string][]int, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	free := make(map[string][]int)
+	addEnums := func(scope string, decls []*descriptorpb.EnumDescriptorProto) {
+		for _, enum := range decls {
+			free[qualifiedName(scope, enum.GetName())] = freeEnumNumbers(enum)
+		}
+	}
+	for _, file := range set.GetFile() {
+		addEnums(file.GetPackage(), file.GetEnumType())
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			addEnums(name, msg.GetEnumType())
+		})
+	}
+	return free, nil
+}
+
+// freeEnumNumbers returns the gaps between the used and reserved numbers of
+// enum.
+func freeEnumNumbers(enum *descriptorpb.EnumDescriptorProto) []int {
+	// Inclusive ranges of taken numbers. int64 avoids overflow past the
+	// int32 bounds.
+	type numberRange struct{ start, end int64 }
+	var taken []numberRange
+	for _, value := range enum.GetValue() {
+		taken = append(taken, numberRange{int64(value.GetNumber()), int64(value.GetNumber())})
+	}
+	// Unlike message reserved ranges, the end of enum reserved ranges is
+	// inclusive.
+	for _, reserved := range enum.GetReservedRange() {
+		taken = append(taken, numberRange{int64(reserved.GetStart()), int64(reserved.GetEnd())})
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].start < taken[j].start })
+
+	free := []int{}
+	if len(taken) == 0 {
+		return free
+	}
+	next := taken[0].start
+	for _, r := range taken {
+		for ; next < r.start && len(free) < maxFreeEnumNumbers; next++ {
+			free = append(free, int(next))
+		}
+		next = max(next, r.end+1)
+	}
+	return free
+}
+
+// MapFieldInfo describes a map field.
+type MapFieldInfo struct {
+	// Message is the fully-qualified name of the message declaring the field.
+	Message string
+	// Name is the field name.
+	Name string
+	// Number is the field number.
+	Number int32
+	// KeyType is the key type, e.g. "string" or "int64".
+	KeyType string
+	// ValueType is the value type, a scalar type such as "bytes" or the
+	// fully-qualified name of a message or enum, e.g. "example.v1.Person".
+	ValueType string
+}
+
+// ListMapFields compiles files and returns the map fields of every message
+// they declare, including nested messages, keyed by fully-qualified field
+// name, e.g. "example.v1.Directory.people". The synthetic map entry messages
+// protoc generates for map fields are resolved to their key and value types.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) ListMapFields(ctx context.Context, includePaths, files []string) (map[string]MapFieldInfo, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]MapFieldInfo)
+	for _, file := range set.GetFile() {
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			entries := make(map[string]*descriptorpb.DescriptorProto)
+			for _, nested := range msg.GetNestedType() {
+				if nested.GetOptions().GetMapEntry() {
+					entries["."+qualifiedName(name, nested.GetName())] = nested
+				}
+			}
+			for _, field := range msg.GetField() {
+				entry := entries[field.GetTypeName()]
+				if entry == nil || field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+					continue
+				}
+				info := MapFieldInfo{Message: name, Name: field.GetName(), Number: field.GetNumber()}
+				for _, entryField := range entry.GetField() {
+					switch entryField.GetNumber() {
+					case 1:
+						info.KeyType = fieldTypeName(entryField)
+					case 2:
+						info.ValueType = fieldTypeName(entryField)
+					}
+				}
+				fields[qualifiedName(name, field.GetName())] = info
+			}
+		})
+	}
+	return fields, nil
+}
+
+// OptionalFields compiles files and returns the names of the fields declared
+// with proto3 optional, keyed by fully-qualified message name, in
+// declaration order. protoc represents these fields with a synthetic oneof
+// each, which generators should treat as explicit presence rather than a
+// real oneof. Messages without such fields are omitted.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) OptionalFields(ctx context.Context, includePaths, files []string) (map[string][]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	optional := make(map[string][]string)
+	for _, file := range set.GetFile() {
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			for _, field := range msg.GetField() {
+				if field.GetProto3Optional() {
+					optional[name] = append(optional[name], field.GetName())
+				}
+			}
+		})
+	}
+	return optional, nil
+}
+
+// FieldDocs compiles files and returns the comments documenting the fields
+// of every message they declare, including nested messages, keyed by
+// fully-qualified field name, e.g. "example.v1.HelloRequest.name". The
+// leading comment and the trailing comment on the same line are joined by
+// a blank line; comment markers and the space after them are removed.
+// Fields without comments are omitted.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) FieldDocs(ctx context.Context, includePaths, files []string) (map[string]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:      includePaths,
+		Files:             files,
+		IncludeSourceInfo: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(map[string]string)
+	for _, file := range set.GetFile() {
+		locations := make(map[string]*descriptorpb.SourceCodeInfo_Location)
+		for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+			locations[fmt.Sprint(loc.GetPath())] = loc
+		}
+		// Paths are message_type (4), nested_type (3) and field (2) indices.
+		var visit func(scope string, msgs []*descriptorpb.DescriptorProto, path []int32)
+		visit = func(scope string, msgs []*descriptorpb.DescriptorProto, path []int32) {
+			for i, msg := range msgs {
+				name := qualifiedName(scope, msg.GetName())
+				msgPath := append(slices.Clip(path), int32(i))
+				for j, field := range msg.GetField() {
+					loc := locations[fmt.Sprint(append(slices.Clip(msgPath), 2, int32(j)))]
+					if doc := commentText(loc); doc != "" {
+						docs[qualifiedName(name, field.GetName())] = doc
+					}
+				}
+				visit(name, msg.GetNestedType(), append(slices.Clip(msgPath), 3))
+			}
+		}
+		visit(file.GetPackage(), file.GetMessageType(), []int32{4})
+	}
+	return docs, nil
+}
+
+// commentText returns the leading and trailing comments of loc, without
+// the space following the comment markers on each line.
+func commentText(loc *descriptorpb.SourceCodeInfo_Location) string {
+	var parts []string
+	for _, comment := range []string{loc.GetLeadingComments(), loc.GetTrailingComments()} {
+		lines := strings.Split(strings.TrimRight(comment, " \n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t")
+		}
+		if text := strings.Join(lines, "\n"); strings.TrimSpace(text) != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// LangOptions are the file options that control the output of the
+// generators for each language. Options a file doesn't set are empty.
+type LangOptions struct {
+	// GoPackage is the go_package option, e.g.
+	// "example.com/api/example/v1;examplev1".
+	GoPackage string
+	// JavaPackage is the java_package option.
+	JavaPackage string
+	// JavaOuterClassname is the java_outer_classname option.
+	JavaOuterClassname string
+	// CsharpNamespace is the csharp_namespace option.
+	CsharpNamespace string
+	// ObjcClassPrefix is the objc_class_prefix option.
+	ObjcClassPrefix string
+	// PhpNamespace is the php_namespace option.
+	PhpNamespace string
+	// RubyPackage is the ruby_package option.
+	RubyPackage string
+	// SwiftPrefix is the swift_prefix option.
+	SwiftPrefix string
+}
+
+// LangFileOptions compiles files and returns the language-specific options
+// of each of them, keyed by file name, so that multi-language tooling can
+// plan the output layout without walking the descriptors.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) LangFileOptions(ctx context.Context, includePaths, files []string) (map[string]LangOptions, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]LangOptions, len(set.GetFile()))
+	for _, file := range set.GetFile() {
+		opts := file.GetOptions()
+		options[file.GetName()] = LangOptions{
+			GoPackage:          opts.GetGoPackage(),
+			JavaPackage:        opts.GetJavaPackage(),
+			JavaOuterClassname: opts.GetJavaOuterClassname(),
+			CsharpNamespace:    opts.GetCsharpNamespace(),
+			ObjcClassPrefix:    opts.GetObjcClassPrefix(),
+			PhpNamespace:       opts.GetPhpNamespace(),
+			RubyPackage:        opts.GetRubyPackage(),
+			SwiftPrefix:        opts.GetSwiftPrefix(),
+		}
+	}
+	return options, nil
+}
+
+// FileSyntaxes compiles files and returns the syntax of each of them, keyed
+// by file name: "proto2", "proto3" or, for files using editions, the
+// edition such as "2023".
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) FileSyntaxes(ctx context.Context, includePaths, files []string) (map[string]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	syntaxes := make(map[string]string, len(set.GetFile()))
+	for _, file := range set.GetFile() {
+		switch syntax := file.GetSyntax(); syntax {
+		case "":
+			// protoc leaves the syntax of proto2 files unset.
+			syntaxes[file.GetName()] = "proto2"
+		case "editions":
+			syntaxes[file.GetName()] = strings.TrimPrefix(file.GetEdition().String(), "EDITION_")
+		default:
+			syntaxes[file.GetName()] = syntax
+		}
+	}
+	return syntaxes, nil
+}
+
+// ReservedInfo lists the field numbers and names a message reserves.
+type ReservedInfo struct {
+	// Numbers are the individually reserved field numbers.
+	Numbers []int32
+	// Ranges are the reserved ranges of more than one field number.
+	Ranges []ReservedRange
+	// Names are the reserved field names.
+	Names []string
+}
+
+// ReservedRange is a range of reserved field numbers.
+type ReservedRange struct {
+	// Start is the first reserved number.
+	Start int32
+	// End is the last reserved number, inclusive as in .proto files.
+	End int32
+}
+
+// ReservedInfo compiles files and returns the reserved field numbers and
+// names of every message they declare, including nested messages, keyed by
+// fully-qualified message name, in declaration order. Messages without
+// reservations are omitted.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) ReservedInfo(ctx context.Context, includePaths, files []string) (map[string]ReservedInfo, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[string]ReservedInfo)
+	for _, file := range set.GetFile() {
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			var info ReservedInfo
+			for _, r := range msg.GetReservedRange() {
+				// Descriptor ranges are end-exclusive.
+				if start, end := r.GetStart(), r.GetEnd()-1; start == end {
+					info.Numbers = append(info.Numbers, start)
+				} else {
+					info.Ranges = append(info.Ranges, ReservedRange{Start: start, End: end})
+				}
+			}
+			info.Names = msg.GetReservedName()
+			if len(info.Numbers) != 0 || len(info.Ranges) != 0 || len(info.Names) != 0 {
+				reserved[name] = info
+			}
+		})
+	}
+	return reserved, nil
+}
+
+// OneofInfo describes a oneof declared in a compiled message.
+type OneofInfo struct {
+	// Name is the oneof name.
+	Name string
+	// Fields are the names of the member fields in declaration order.
+	Fields []string
+}
+
+// ListOneofs compiles files and returns the oneofs of every message they
+// declare, including nested messages, keyed by fully-qualified message name,
+// in declaration order. The synthetic oneofs protoc creates for proto3
+// optional fields are excluded; OptionalFields lists those fields. Messages
+// without oneofs are omitted.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) ListOneofs(ctx context.Context, includePaths, files []string) (map[string][]OneofInfo, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{IncludePaths: includePaths, Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	oneofs := make(map[string][]OneofInfo)
+	for _, file := range set.GetFile() {
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			infos := make([]OneofInfo, len(msg.GetOneofDecl()))
+			synthetic := make([]bool, len(msg.GetOneofDecl()))
+			for i, decl := range msg.GetOneofDecl() {
+				infos[i].Name = decl.GetName()
+			}
+			for _, field := range msg.GetField() {
+				if field.OneofIndex == nil {
+					continue
+				}
+				i := field.GetOneofIndex()
+				infos[i].Fields = append(infos[i].Fields, field.GetName())
+				// A synthetic oneof only holds its proto3 optional field.
+				synthetic[i] = field.GetProto3Optional()
+			}
+			for i, info := range infos {
+				if !synthetic[i] {
+					oneofs[name] = append(oneofs[name], info)
+				}
+			}
+		})
+	}
+	return oneofs, nil
+}
+
+// fieldTypeName returns the fully-qualified type name of a message or enum
+// field, or the name of a scalar type as written in .proto files.
+func fieldTypeName(field *descriptorpb.FieldDescriptorProto) string {
+	if typeName := field.GetTypeName(); typeName != "" {
+		return strings.TrimPrefix(typeName, ".")
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
+
+// DependencyGraph compiles files and returns the import graph of files and
+// their transitive imports, mapping each file name to its direct imports in
+// declaration order. Files without imports map to an empty list.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) DependencyGraph(ctx context.Context, includePaths, files []string) (map[string][]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(map[string][]string, len(set.GetFile()))
+	for _, file := range set.GetFile() {
+		graph[file.GetName()] = append([]string{}, file.GetDependency()...)
+	}
+	return graph, nil
+}
+
+// MinimalFileSet compiles target and returns it together with exactly its
+// transitive imports, dependencies before the files importing them, so
+// that only the files needed to compile target can be vendored.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) MinimalFileSet(ctx context.Context, includePaths []string, target string) ([]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          []string{target},
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, len(set.GetFile()))
+	for i, file := range set.GetFile() {
+		files[i] = file.GetName()
+	}
+	return files, nil
+}
+
+// PartitionFiles compiles files and groups them into connected components of
+// the import graph, so that each group can be compiled independently, e.g.
+// in parallel with a Pool. Files that import each other, directly or
+// transitively, or share an import are in the same group. The well-known
+// types are shared by every file and don't join groups. Groups are ordered
+// by their first file and keep the order of files; file names are cleaned
+// and must be relative to an include path.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) PartitionFiles(ctx context.Context, includePaths, files []string) ([][]string, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Union-find over file names.
+	parent := make(map[string]string)
+	var find func(name string) string
+	find = func(name string) string {
+		if next, ok := parent[name]; ok && next != name {
+			root := find(next)
+			parent[name] = root
+			return root
+		}
+		parent[name] = name
+		return name
+	}
+	for _, file := range set.GetFile() {
+		for _, dep := range file.GetDependency() {
+			if !strings.HasPrefix(dep, "google/protobuf/") {
+				parent[find(dep)] = find(file.GetName())
+			}
+		}
+	}
+
+	var groups [][]string
+	index := make(map[string]int)
+	for _, file := range files {
+		file = path.Clean(file)
+		root := find(file)
+		i, ok := index[root]
+		if !ok {
+			i = len(groups)
+			index[root] = i
+			groups = append(groups, nil)
+		}
+		if !slices.Contains(groups[i], file) {
+			groups[i] = append(groups[i], file)
+		}
+	}
+	return groups, nil
+}
+
+// WithCompiled compiles files with their transitive imports and source info,
+// builds a registry of the resulting descriptors and calls fn with it, so
+// that tools can walk descriptors without decoding them. The error
+// returned by fn is returned as is.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) WithCompiled(ctx context.Context, includePaths, files []string, fn func(*protoregistry.Files) error) error {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:      includePaths,
+		Files:             files,
+		IncludeImports:    true,
+		IncludeSourceInfo: true,
+	})
+	if err != nil {
+		return err
+	}
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return err
+	}
+	return fn(registry)
+}
+
+// MessageFactory compiles files with their transitive imports and returns
+// a function creating empty dynamicpb messages of the types they declare by
+// fully-qualified name, e.g. "example.v1.HelloRequest", for services that
+// accept schemas at runtime. The factory can be used concurrently and
+// after the Protoc is closed. Unknown names return an error wrapping
+// protoregistry.NotFound.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) MessageFactory(ctx context.Context, includePaths, files []string) (func(fullName string) (protoreflect.Message, error), error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return func(fullName string) (protoreflect.Message, error) {
+		desc, err := registry.FindDescriptorByName(protoreflect.FullName(fullName))
+		if err != nil {
+			return nil, fmt.Errorf("message %s: %w", fullName, err)
+		}
+		msg, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a message", fullName)
+		}
+		return dynamicpb.NewMessage(msg), nil
+	}, nil
+}
+
+// StripSourceInfo removes source code info, including comments, from an
+// encoded FileDescriptorSet. It is the inverse of
+// CompileOptions.IncludeSourceInfo, useful for minimizing descriptors
+// embedded in binaries.
+func StripSourceInfo(data []byte) ([]byte, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	for _, file := range set.GetFile() {
+		file.SourceCodeInfo = nil
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(set)
+}
+
+// TrimSourceInfo shrinks the source code info of an encoded
+// FileDescriptorSet. With keepComments only the locations with leading
+// comments are kept, with just their path, comment and a zeroed span, so
+// that documentation survives at a fraction of the size; the descriptor
+// APIs require a span for every location. Without keepComments the source
+// info is removed as by StripSourceInfo.
+func TrimSourceInfo(data []byte, keepComments bool) ([]byte, error) {
+	if !keepComments {
+		return StripSourceInfo(data)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	for _, file := range set.GetFile() {
+		var locations []*descriptorpb.SourceCodeInfo_Location
+		for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+			if loc.LeadingComments == nil {
+				continue
+			}
+			locations = append(locations, &descriptorpb.SourceCodeInfo_Location{
+				Path:            loc.GetPath(),
+				Span:            []int32{0, 0, 0},
+				LeadingComments: loc.LeadingComments,
+			})
+		}
+		file.SourceCodeInfo = nil
+		if len(locations) != 0 {
+			file.SourceCodeInfo = &descriptorpb.SourceCodeInfo{Location: locations}
+		}
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(set)
+}
+
+// ValidateDescriptorSet checks that protoc accepts data, an encoded
+// FileDescriptorSet such as one built by hand or modified, by loading every
+// file in it with --descriptor_set_in and emitting it again. Dependencies of
+// the files must be in data, be well-known types or precompiled imports.
+// This catches malformed descriptors, e.g. references to undefined types,
+// before they reach downstream tools.
+//
+// If data cannot be decoded its error is returned; if protoc rejects it
+// the returned error is a *CompileError. Init() must be called first.
+func (p *Protoc) ValidateDescriptorSet(ctx context.Context, data []byte) error {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return err
+	}
+	if len(set.GetFile()) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scratch.Clear()
+	if err := p.scratch.WriteFile(inputDescriptorSetFile, data); err != nil {
+		return err
+	}
+	args := []string{
+		"protoc",
+		"--descriptor_set_out=" + path.Join(scratchDir, descriptorSetFile),
+		p.descriptorSetInArg(path.Join(scratchDir, inputDescriptorSetFile)),
+	}
+	for _, file := range set.GetFile() {
+		args = append(args, file.GetName())
+	}
+	exitCode, _, stderr, err := p.runCapture(ctx, args)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return &CompileError{ExitCode: exitCode, Diagnostics: ParseDiagnostics(stderr)}
+	}
+	return nil
+}
+
+// compileDescriptorSet compiles opts and decodes the resulting set.
+func (p *Protoc) compileDescriptorSet(ctx context.Context, opts CompileOptions) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := p.Compile(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// forEachMessage calls fn with the fully-qualified name of each message in
+// msgs and, recursively, each message nested in them.
+func forEachMessage(scope string, msgs []*descriptorpb.DescriptorProto, fn func(name string, msg *descriptorpb.DescriptorProto)) {
+	for _, msg := range msgs {
+		name := qualifiedName(scope, msg.GetName())
+		fn(name, msg)
+		forEachMessage(name, msg.GetNestedType(), fn)
+	}
+}
+
+// qualifiedName returns name qualified by pkg.
+func qualifiedName(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
@@ -0,0 +1,729 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtocListServices(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"chat.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package chat;
+
+import "google/protobuf/empty.proto";
+
+message Message {
+  string text = 1;
+}
+
+service Chat {
+  rpc Send(Message) returns (google.protobuf.Empty);
+  rpc Subscribe(google.protobuf.Empty) returns (stream Message);
+  rpc Stream(stream Message) returns (stream Message);
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	services, err := p.ListServices(ctx, nil, []string{"chat.proto"})
+	if err != nil {
+		t.Fatalf("ListServices failed: %v", err)
+	}
+
+	expected := []ServiceInfo{{
+		File: "chat.proto",
+		Name: "chat.Chat",
+		Methods: []MethodInfo{
+			{Name: "Send", InputType: "chat.Message", OutputType: "google.protobuf.Empty"},
+			{Name: "Subscribe", InputType: "google.protobuf.Empty", OutputType: "chat.Message", ServerStreaming: true},
+			{Name: "Stream", InputType: "chat.Message", OutputType: "chat.Message", ClientStreaming: true, ServerStreaming: true},
+		},
+	}}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %+v, got %+v", expected, services)
+	}
+}
+
+func TestProtocListEnums(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"status.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+enum Status {
+  option allow_alias = true;
+  STATUS_UNSPECIFIED = 0;
+  STATUS_OK = 1;
+  STATUS_SUCCESS = 1;
+}
+
+message Job {
+  enum State {
+    STATE_UNSPECIFIED = 0;
+    STATE_DONE = 2;
+  }
+  State state = 1;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	enums, err := p.ListEnums(ctx, nil, []string{"status.proto"})
+	if err != nil {
+		t.Fatalf("ListEnums failed: %v", err)
+	}
+
+	expected := map[string][]EnumValue{
+		"test.Status": {
+			{Name: "STATUS_UNSPECIFIED", Number: 0},
+			{Name: "STATUS_OK", Number: 1},
+			{Name: "STATUS_SUCCESS", Number: 1},
+		},
+		"test.Job.State": {
+			{Name: "STATE_UNSPECIFIED", Number: 0},
+			{Name: "STATE_DONE", Number: 2},
+		},
+	}
+	if !reflect.DeepEqual(enums, expected) {
+		t.Errorf("expected %+v, got %+v", expected, enums)
+	}
+}
+
+func TestProtocFreeEnumNumbers(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"color.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+enum Color {
+  reserved 4 to 6, 9;
+  COLOR_UNSPECIFIED = 0;
+  COLOR_RED = 1;
+  COLOR_GREEN = 3;
+  COLOR_BLUE = 8;
+  COLOR_WHITE = 11;
+}
+
+message Light {
+  enum State {
+    STATE_OFF = 0;
+    STATE_ON = 1;
+  }
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	free, err := p.FreeEnumNumbers(ctx, nil, []string{"color.proto"})
+	if err != nil {
+		t.Fatalf("FreeEnumNumbers failed: %v", err)
+	}
+
+	expected := map[string][]int{
+		"test.Color":       {2, 7, 10},
+		"test.Light.State": {},
+	}
+	if !reflect.DeepEqual(free, expected) {
+		t.Errorf("expected %v, got %v", expected, free)
+	}
+}
+
+func TestProtocListMapFields(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"directory.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+enum Role {
+  ROLE_UNSPECIFIED = 0;
+}
+
+message Person {
+  string name = 1;
+}
+
+message Directory {
+  map<string, Person> people = 1;
+  repeated Person members = 2;
+  message Group {
+    map<int64, Role> roles = 3;
+  }
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	fields, err := p.ListMapFields(ctx, nil, []string{"directory.proto"})
+	if err != nil {
+		t.Fatalf("ListMapFields failed: %v", err)
+	}
+	expected := map[string]MapFieldInfo{
+		"test.Directory.people": {
+			Message:   "test.Directory",
+			Name:      "people",
+			Number:    1,
+			KeyType:   "string",
+			ValueType: "test.Person",
+		},
+		"test.Directory.Group.roles": {
+			Message:   "test.Directory.Group",
+			Name:      "roles",
+			Number:    3,
+			KeyType:   "int64",
+			ValueType: "test.Role",
+		},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+func TestProtocOptionalFields(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"optional.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Profile {
+  optional string nickname = 1;
+  string name = 2;
+  oneof contact {
+    string email = 3;
+    string phone = 4;
+  }
+  optional int32 age = 5;
+  message Settings {
+    optional bool dark_mode = 1;
+  }
+}
+
+message Plain {
+  string name = 1;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	fields, err := p.OptionalFields(ctx, nil, []string{"optional.proto"})
+	if err != nil {
+		t.Fatalf("OptionalFields failed: %v", err)
+	}
+	expected := map[string][]string{
+		"test.Profile":          {"nickname", "age"},
+		"test.Profile.Settings": {"dark_mode"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+func TestProtocDependencyGraph(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "b.proto"; message A { B b = 1; }`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "c.proto"; message B { C c = 1; }`)},
+		"c.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "google/protobuf/empty.proto"; message C { google.protobuf.Empty e = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	graph, err := p.DependencyGraph(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("DependencyGraph failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"a.proto":                     {"b.proto"},
+		"b.proto":                     {"c.proto"},
+		"c.proto":                     {"google/protobuf/empty.proto"},
+		"google/protobuf/empty.proto": {},
+	}
+	if !reflect.DeepEqual(graph, expected) {
+		t.Errorf("expected %v, got %v", expected, graph)
+	}
+}
+
+func TestProtocMinimalFileSet(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"app.proto":    &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "b.proto"; import "c.proto"; message App { B b = 1; C c = 2; }`)},
+		"b.proto":      &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "c.proto"; message B { C c = 1; }`)},
+		"c.proto":      &fstest.MapFile{Data: []byte(`syntax = "proto3"; message C {}`)},
+		"other.proto":  &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "c.proto"; message Other { C c = 1; }`)},
+		"unused.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Unused {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	files, err := p.MinimalFileSet(ctx, nil, "app.proto")
+	if err != nil {
+		t.Fatalf("MinimalFileSet failed: %v", err)
+	}
+	expected := []string{"c.proto", "b.proto", "app.proto"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestProtocPartitionFiles(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"billing/invoice.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "billing/money.proto"; import "google/protobuf/timestamp.proto"; message Invoice { Money total = 1; }`)},
+		"billing/refund.proto":  &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "billing/money.proto"; message Refund { Money amount = 1; }`)},
+		"billing/money.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Money {}`)},
+		"users/user.proto":      &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "users/role.proto"; import "google/protobuf/timestamp.proto"; message User { Role role = 1; }`)},
+		"users/role.proto":      &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Role {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	groups, err := p.PartitionFiles(ctx, nil, []string{"billing/invoice.proto", "users/user.proto", "billing/refund.proto", "users/role.proto"})
+	if err != nil {
+		t.Fatalf("PartitionFiles failed: %v", err)
+	}
+	expected := [][]string{
+		{"billing/invoice.proto", "billing/refund.proto"},
+		{"users/user.proto", "users/role.proto"},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+}
+
+func TestProtocFieldDocs(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package docs.v1;
+
+message User {
+  // The display name of the user.
+  string name = 1;
+
+  // Tags attached to the user,
+  // in insertion order.
+  repeated string tags = 2; // At most 10.
+
+  int32 age = 3; // Age in years.
+
+  message Address {
+    /* The street and number. */
+    string street = 1;
+  }
+
+  string undocumented = 4;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	docs, err := p.FieldDocs(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("FieldDocs failed: %v", err)
+	}
+	expected := map[string]string{
+		"docs.v1.User.name":           "The display name of the user.",
+		"docs.v1.User.tags":           "Tags attached to the user,\nin insertion order.\n\nAt most 10.",
+		"docs.v1.User.age":            "Age in years.",
+		"docs.v1.User.Address.street": "The street and number.",
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("expected %q, got %q", expected, docs)
+	}
+}
+
+func TestProtocLangFileOptions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package acme.v1;
+option go_package = "example.com/acme/v1;acmev1";
+option java_package = "com.example.acme.v1";
+`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package acme.v1;`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	options, err := p.LangFileOptions(ctx, nil, []string{"a.proto", "b.proto"})
+	if err != nil {
+		t.Fatalf("LangFileOptions failed: %v", err)
+	}
+	expected := map[string]LangOptions{
+		"a.proto": {GoPackage: "example.com/acme/v1;acmev1", JavaPackage: "com.example.acme.v1"},
+		"b.proto": {},
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected %+v, got %+v", expected, options)
+	}
+}
+
+func TestProtocReservedInfo(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package gov.v1;
+message Account {
+  reserved 2, 9 to 11, 100 to max;
+  reserved "legacy_id", "email";
+  string id = 1;
+  message Settings { reserved "theme"; }
+}
+message Plain { string id = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	reserved, err := p.ReservedInfo(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("ReservedInfo failed: %v", err)
+	}
+	expected := map[string]ReservedInfo{
+		"gov.v1.Account": {
+			Numbers: []int32{2},
+			Ranges:  []ReservedRange{{Start: 9, End: 11}, {Start: 100, End: 536870911}},
+			Names:   []string{"legacy_id", "email"},
+		},
+		"gov.v1.Account.Settings": {Names: []string{"theme"}},
+	}
+	if !reflect.DeepEqual(reserved, expected) {
+		t.Errorf("expected %+v, got %+v", expected, reserved)
+	}
+}
+
+func TestProtocListOneofs(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package shapes.v1;
+message Shape {
+  string id = 1;
+  oneof kind {
+    double radius = 2;
+    double side = 3;
+  }
+  optional string label = 4;
+}
+message Plain { optional int32 count = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	oneofs, err := p.ListOneofs(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("ListOneofs failed: %v", err)
+	}
+	// The synthetic oneofs of the optional fields are excluded.
+	expected := map[string][]OneofInfo{
+		"shapes.v1.Shape": {{Name: "kind", Fields: []string{"radius", "side"}}},
+	}
+	if !reflect.DeepEqual(oneofs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, oneofs)
+	}
+}
+
+func TestProtocValidateDescriptorSet(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+
+	valid, err := p.Compile(ctx, CompileOptions{
+		IncludePaths:   []string{"/testdata/protos"},
+		Files:          []string{"example/v1/greeter.proto"},
+		IncludeImports: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if err := p.ValidateDescriptorSet(ctx, valid); err != nil {
+		t.Errorf("ValidateDescriptorSet failed for a valid set: %v", err)
+	}
+
+	// A field referring to a type that is not defined.
+	corrupted, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:   proto.String("broken.proto"),
+			Syntax: proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Broken"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("missing"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".Missing"),
+				}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compileErr *CompileError
+	if err := p.ValidateDescriptorSet(ctx, corrupted); !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError for a corrupted set, got %v", err)
+	}
+
+	if err := p.ValidateDescriptorSet(ctx, []byte("not a descriptor set")); err == nil {
+		t.Error("expected error for undecodable data")
+	}
+}
+
+func TestProtocWithCompiled(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+
+	var messages []string
+	err := p.WithCompiled(ctx, []string{"/testdata/protos"}, []string{"example/v1/greeter.proto"}, func(files *protoregistry.Files) error {
+		files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			for i := range fd.Messages().Len() {
+				messages = append(messages, string(fd.Messages().Get(i).FullName()))
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithCompiled failed: %v", err)
+	}
+	sort.Strings(messages)
+	expected := []string{"example.v1.HelloReply", "example.v1.HelloRequest"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %v, got %v", expected, messages)
+	}
+
+	// Errors from the closure are returned as is.
+	errStop := errors.New("stop")
+	err = p.WithCompiled(ctx, []string{"/testdata/protos"}, []string{"example/v1/types.proto"}, func(*protoregistry.Files) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("expected errStop, got %v", err)
+	}
+}
+
+func TestStripSourceInfo(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"person.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+// Person is a person.
+message Person {
+  // name is the full name of the person.
+  string name = 1;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.Compile(ctx, CompileOptions{
+		Files:             []string{"person.proto"},
+		IncludeSourceInfo: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	stripped, err := StripSourceInfo(data)
+	if err != nil {
+		t.Fatalf("StripSourceInfo failed: %v", err)
+	}
+	if len(stripped) >= len(data) {
+		t.Errorf("expected stripped set to be smaller: %d >= %d bytes", len(stripped), len(data))
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(stripped, &set); err != nil {
+		t.Fatalf("stripped set does not decode: %v", err)
+	}
+	file := set.GetFile()[0]
+	if file.GetSourceCodeInfo() != nil {
+		t.Error("expected source code info to be removed")
+	}
+	if name := file.GetMessageType()[0].GetName(); name != "Person" {
+		t.Errorf("expected message Person, got %q", name)
+	}
+
+	if _, err := StripSourceInfo([]byte("not a descriptor set")); err == nil {
+		t.Error("expected error for invalid input")
+	}
+}
+
+func TestTrimSourceInfo(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"person.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+// Person is a person.
+message Person {
+  // name is the full name of the person.
+  string name = 1; // trailing
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.Compile(ctx, CompileOptions{
+		Files:             []string{"person.proto"},
+		IncludeSourceInfo: true,
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	trimmed, err := TrimSourceInfo(data, true)
+	if err != nil {
+		t.Fatalf("TrimSourceInfo failed: %v", err)
+	}
+	if len(trimmed) >= len(data) {
+		t.Errorf("expected trimmed set to be smaller: %d >= %d bytes", len(trimmed), len(data))
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(trimmed, &set); err != nil {
+		t.Fatalf("trimmed set does not decode: %v", err)
+	}
+	comments := make(map[string]bool)
+	for _, loc := range set.GetFile()[0].GetSourceCodeInfo().GetLocation() {
+		comments[loc.GetLeadingComments()] = true
+		if !reflect.DeepEqual(loc.GetSpan(), []int32{0, 0, 0}) {
+			t.Errorf("expected zeroed span, got %v", loc.GetSpan())
+		}
+		if loc.TrailingComments != nil || len(loc.GetLeadingDetachedComments()) != 0 {
+			t.Errorf("expected only leading comments, got %v", loc)
+		}
+	}
+	want := map[string]bool{
+		" Person is a person.\n":                  true,
+		" name is the full name of the person.\n": true,
+	}
+	if !reflect.DeepEqual(comments, want) {
+		t.Errorf("unexpected comments %v", comments)
+	}
+
+	stripped, err := TrimSourceInfo(data, false)
+	if err != nil {
+		t.Fatalf("TrimSourceInfo failed: %v", err)
+	}
+	if err := proto.Unmarshal(stripped, &set); err != nil {
+		t.Fatal(err)
+	}
+	if set.GetFile()[0].GetSourceCodeInfo() != nil {
+		t.Error("expected source code info to be removed")
+	}
+}
+
+func TestProtocMessageFactory(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"person.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+import "google/protobuf/timestamp.proto";
+message Person {
+  string name = 1;
+  google.protobuf.Timestamp born = 2;
+}
+enum Kind { KIND_UNSPECIFIED = 0; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	newMessage, err := p.MessageFactory(ctx, nil, []string{"person.proto"})
+	if err != nil {
+		t.Fatalf("MessageFactory failed: %v", err)
+	}
+	msg, err := newMessage("test.Person")
+	if err != nil {
+		t.Fatalf("creating test.Person failed: %v", err)
+	}
+	fields := msg.Descriptor().Fields()
+	msg.Set(fields.ByName("name"), protoreflect.ValueOfString("Ada"))
+	msg.Mutable(fields.ByName("born")).Message().Set(
+		fields.ByName("born").Message().Fields().ByName("seconds"), protoreflect.ValueOfInt64(42))
+
+	// The message round-trips through the wire format.
+	data, err := proto.Marshal(msg.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := newMessage("test.Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(data, decoded.Interface()); err != nil {
+		t.Fatal(err)
+	}
+	if name := decoded.Get(fields.ByName("name")).String(); name != "Ada" {
+		t.Errorf("expected name Ada, got %q", name)
+	}
+	if !proto.Equal(msg.Interface(), decoded.Interface()) {
+		t.Error("expected decoded message to equal the original")
+	}
+
+	if _, err := newMessage("test.Missing"); !errors.Is(err, protoregistry.NotFound) {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	if _, err := newMessage("test.Kind"); err == nil {
+		t.Error("expected error for an enum")
+	}
+}
+
+func TestProtocFileSyntaxes(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"implicit.proto": &fstest.MapFile{Data: []byte(`message A {}`)},
+		"v2.proto":       &fstest.MapFile{Data: []byte(`syntax = "proto2"; message B {}`)},
+		"v3.proto":       &fstest.MapFile{Data: []byte(`syntax = "proto3"; message C {}`)},
+		"ed.proto":       &fstest.MapFile{Data: []byte(`edition = "2023"; message D {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	syntaxes, err := p.FileSyntaxes(ctx, nil, []string{"implicit.proto", "v2.proto", "v3.proto", "ed.proto"})
+	if err != nil {
+		t.Fatalf("FileSyntaxes failed: %v", err)
+	}
+	expected := map[string]string{
+		"implicit.proto": "proto2",
+		"v2.proto":       "proto2",
+		"v3.proto":       "proto3",
+		"ed.proto":       "2023",
+	}
+	if !reflect.DeepEqual(syntaxes, expected) {
+		t.Errorf("expected %v, got %v", expected, syntaxes)
+	}
+}
@@ -0,0 +1,408 @@
+package protoc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity string
+
+const (
+	// SeverityError marks a diagnostic that fails the compilation.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a diagnostic that does not fail the compilation.
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a single error or warning reported by protoc.
+type Diagnostic struct {
+	// File is the file the diagnostic refers to, if any.
+	File string `json:"file,omitempty"`
+	// Line is the 1-based line number, or 0 if unknown.
+	Line int `json:"line,omitempty"`
+	// Column is the 1-based column number, or 0 if unknown.
+	Column int `json:"column,omitempty"`
+	// Severity is the diagnostic severity.
+	Severity Severity `json:"severity"`
+	// Message is the diagnostic text.
+	Message string `json:"message"`
+}
+
+// String formats the diagnostic the same way protoc does.
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	if d.File != "" {
+		sb.WriteString(d.File)
+		if d.Line > 0 {
+			sb.WriteString(":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column))
+		}
+		sb.WriteString(": ")
+	}
+	if d.Severity == SeverityWarning {
+		sb.WriteString("warning: ")
+	}
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// CompileError is returned when protoc exits with a non-zero code.
+type CompileError struct {
+	// ExitCode is the protoc exit code.
+	ExitCode int
+	// Diagnostics are the diagnostics protoc reported.
+	Diagnostics []Diagnostic
+}
+
+// Error implements error.
+func (e *CompileError) Error() string {
+	msg := "protoc exited with code " + strconv.Itoa(e.ExitCode)
+	for _, d := range e.Diagnostics {
+		if d.Severity == SeverityError {
+			return msg + ": " + d.String()
+		}
+	}
+	return msg
+}
+
+var (
+	// diagnosticPosRe matches "file:line:column: message".
+	diagnosticPosRe = regexp.MustCompile(`^(.+?):(\d+):(\d+): (.*)$`)
+	// diagnosticFileRe matches "file: message" where file has no spaces.
+	diagnosticFileRe = regexp.MustCompile(`^([^\s:]+): (.*)$`)
+)
+
+// ParseDiagnostics parses protoc stderr output in the default (gcc) error
+// format. Lines that do not reference a file are returned with only the
+// Message set.
+func ParseDiagnostics(stderr []byte) []Diagnostic {
+	var diags []Diagnostic
+	sc := bufio.NewScanner(bytes.NewReader(stderr))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		diags = append(diags, parseDiagnostic(line))
+	}
+	return diags
+}
+
+// normalizeDiagnostics returns stderr as valid UTF-8, with invalid bytes
+// replaced by U+FFFD, and with backslashes in the file names of diagnostic
+// lines replaced by slashes, as configured by Config.NormalizeOutput.
+func normalizeDiagnostics(stderr []byte) []byte {
+	stderr = bytes.ToValidUTF8(stderr, []byte("\uFFFD"))
+	for _, line := range bytes.Split(stderr, []byte("\n")) {
+		m := diagnosticPosRe.FindSubmatchIndex(line)
+		if m == nil {
+			m = diagnosticFileRe.FindSubmatchIndex(line)
+		}
+		if m == nil {
+			continue
+		}
+		// The lines share the memory of the copy made above.
+		for i := m[2]; i < m[3]; i++ {
+			if line[i] == '\\' {
+				line[i] = '/'
+			}
+		}
+	}
+	return stderr
+}
+
+// parseDiagnostic parses a single non-empty line of protoc output.
+func parseDiagnostic(line string) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Message: line}
+	if m := diagnosticPosRe.FindStringSubmatch(line); m != nil {
+		d.File = m[1]
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		d.Message = m[4]
+	} else if m := diagnosticFileRe.FindStringSubmatch(line); m != nil {
+		d.File = m[1]
+		d.Message = m[2]
+	}
+	if d.File != "" {
+		// protoc joins the include path and the virtual path, producing
+		// names such as "//foo.proto" for an include path of "/".
+		d.File = path.Clean(d.File)
+	}
+	if msg, ok := strings.CutPrefix(d.Message, "warning: "); ok {
+		d.Severity = SeverityWarning
+		d.Message = msg
+	}
+	return d
+}
+
+// diagnosticWriter is an io.Writer passing each line written to it, parsed
+// as a Diagnostic, to sink, as configured by Config.DiagnosticSink.
+type diagnosticWriter struct {
+	sink func(Diagnostic)
+	// normalize applies normalizeDiagnostics to each line, as configured by
+	// Config.NormalizeOutput.
+	normalize bool
+	// partial is the trailing line not yet terminated by a newline.
+	partial []byte
+}
+
+// Write implements io.Writer.
+func (w *diagnosticWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, b...)
+			return n, nil
+		}
+		w.emit(string(w.partial) + string(b[:i]))
+		w.partial = w.partial[:0]
+		b = b[i+1:]
+	}
+}
+
+// flush reports the trailing unterminated line, if any.
+func (w *diagnosticWriter) flush() {
+	if len(w.partial) != 0 {
+		w.emit(string(w.partial))
+		w.partial = w.partial[:0]
+	}
+}
+
+// reset discards the trailing unterminated line.
+func (w *diagnosticWriter) reset() {
+	w.partial = w.partial[:0]
+}
+
+// emit passes line to the sink unless it is blank, like ParseDiagnostics.
+func (w *diagnosticWriter) emit(line string) {
+	if w.normalize {
+		line = string(normalizeDiagnostics([]byte(line)))
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		w.sink(parseDiagnostic(line))
+	}
+}
+
+// Check compiles files and returns the diagnostics protoc reported.
+// Compile errors are returned as diagnostics; the error is only non-nil if
+// protoc could not be run. If includePaths is empty the filesystem root is
+// used. Init() must be called first.
+func (p *Protoc) Check(ctx context.Context, includePaths, files []string) ([]Diagnostic, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.diagnostics, nil
+}
+
+// CheckAll is like Check but reports the diagnostics of every file. protoc
+// stops at the first file that fails, so if the batch fails CheckAll checks
+// each file separately and aggregates the diagnostics in the order of files,
+// without duplicates such as errors in a shared import.
+func (p *Protoc) CheckAll(ctx context.Context, includePaths, files []string) ([]Diagnostic, error) {
+	diags, err := p.Check(ctx, includePaths, files)
+	if err != nil || len(files) < 2 || !slices.ContainsFunc(diags, func(d Diagnostic) bool {
+		return d.Severity == SeverityError
+	}) {
+		return diags, err
+	}
+
+	var all []Diagnostic
+	for _, file := range files {
+		diags, err := p.Check(ctx, includePaths, []string{file})
+		if err != nil {
+			return nil, err
+		}
+		for _, diag := range diags {
+			if !slices.Contains(all, diag) {
+				all = append(all, diag)
+			}
+		}
+	}
+	return all, nil
+}
+
+// CheckJSON is like Check but returns the diagnostics encoded as a JSON array.
+//
+// The embedded protoc only supports the gcc and msvs error formats, so the
+// JSON is produced from the parsed diagnostics.
+func (p *Protoc) CheckJSON(ctx context.Context, includePaths, files []string) ([]byte, error) {
+	diags, err := p.Check(ctx, includePaths, files)
+	if err != nil {
+		return nil, err
+	}
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	return json.Marshal(diags)
+}
+
+// fileNotFoundMessage is the message protoc reports for a file that is
+// missing from the include paths.
+const fileNotFoundMessage = "File not found."
+
+// CheckImports compiles files and returns the imports that could not be
+// found in the include paths, sorted and without duplicates. The result is
+// empty if all imports resolve. Other compile errors are ignored, so that
+// the check can be used as a quick pre-flight before a full build.
+//
+// Missing files passed in files are not reported. If includePaths is empty
+// the filesystem root is used. Init() must be called first.
+func (p *Protoc) CheckImports(ctx context.Context, includePaths, files []string) ([]string, error) {
+	diags, err := p.Check(ctx, includePaths, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return missingImports(diags, files), nil
+}
+
+// missingImports returns the files diags report as not found, excluding
+// files, sorted and without duplicates.
+func missingImports(diags []Diagnostic, files []string) []string {
+	inputs := make(map[string]bool, len(files))
+	for _, file := range files {
+		inputs[path.Clean(file)] = true
+	}
+	missing := []string{}
+	for _, diag := range diags {
+		if diag.Line != 0 || diag.Message != fileNotFoundMessage || inputs[diag.File] {
+			continue
+		}
+		if !slices.Contains(missing, diag.File) {
+			missing = append(missing, diag.File)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// ReservedConflict is a field or enum value that uses a reserved number or
+// name.
+type ReservedConflict struct {
+	// File is the file declaring the field or enum value.
+	File string `json:"file"`
+	// Line is the 1-based line number protoc reported, or 0 if unknown.
+	Line int `json:"line,omitempty"`
+	// Column is the 1-based column number protoc reported, or 0 if unknown.
+	Column int `json:"column,omitempty"`
+	// Name is the name of the field or enum value.
+	Name string `json:"name"`
+	// Number is the reserved number in use, or 0 if the name is reserved.
+	Number int32 `json:"number,omitempty"`
+	// EnumValue is set if the conflict is an enum value rather than a field.
+	EnumValue bool `json:"enum_value,omitempty"`
+}
+
+var (
+	// reservedNumberRe matches the protoc error for a reserved number.
+	reservedNumberRe = regexp.MustCompile(`^(Field|Enum value) "([^"]*)" uses reserved number (-?\d+)\.$`)
+	// reservedNameRe matches the protoc error for a reserved name.
+	reservedNameRe = regexp.MustCompile(`^(Field name|Enum value) "([^"]*)" is reserved\.$`)
+)
+
+// ValidateReserved compiles files and returns the fields and enum values
+// that use a reserved number or name, in the order protoc reported them.
+// protoc rejects such files; ValidateReserved extracts the conflicts from
+// the diagnostics and ignores other compile errors, so that conflicts are
+// reported even for files that fail to compile for other reasons.
+//
+// If includePaths is empty the filesystem root is used. Init() must be
+// called first.
+func (p *Protoc) ValidateReserved(ctx context.Context, includePaths, files []string) ([]ReservedConflict, error) {
+	diags, err := p.Check(ctx, includePaths, files)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []ReservedConflict{}
+	for _, diag := range diags {
+		if diag.Severity != SeverityError {
+			continue
+		}
+		c := ReservedConflict{File: diag.File, Line: diag.Line, Column: diag.Column}
+		if m := reservedNumberRe.FindStringSubmatch(diag.Message); m != nil {
+			num, err := strconv.ParseInt(m[3], 10, 32)
+			if err != nil {
+				continue
+			}
+			c.EnumValue, c.Name, c.Number = m[1] == "Enum value", m[2], int32(num)
+		} else if m := reservedNameRe.FindStringSubmatch(diag.Message); m != nil {
+			c.EnumValue, c.Name = m[1] == "Enum value", m[2]
+		} else {
+			continue
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+// unknownOptionRe matches the protoc error for a custom option whose
+// extension definition was not found.
+var unknownOptionRe = regexp.MustCompile(`^Option "\(([^"]*)\)" unknown\.`)
+
+// UnresolvedOptionError is returned by ResolveCustomOptions when custom
+// options are used without their extension definitions.
+type UnresolvedOptionError struct {
+	// Option is the name of the first unresolved option as written in the
+	// source, without parentheses, e.g. "acme.v1.label".
+	Option string
+	// Diagnostics are the errors of every unresolved option.
+	Diagnostics []Diagnostic
+}
+
+// Error implements error.
+func (e *UnresolvedOptionError) Error() string {
+	msg := "unresolved custom option (" + e.Option + ")"
+	if d := e.Diagnostics[0]; d.File != "" {
+		msg += " in " + d.File
+		if d.Line > 0 {
+			msg += ":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column)
+		}
+	}
+	return msg
+}
+
+// ResolveCustomOptions compiles files and checks that every custom option
+// they use resolves to an extension definition, which must be imported from
+// a file found in includePaths. An unresolved option is returned as an
+// *UnresolvedOptionError naming it, before it surfaces as a less obvious
+// failure of a generator. Other compile errors are returned as a
+// *CompileError.
+//
+// If includePaths is empty the filesystem root is used. Init() must be
+// called first.
+func (p *Protoc) ResolveCustomOptions(ctx context.Context, includePaths, files []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files}, nil)
+	if err != nil {
+		return err
+	}
+	unresolved := &UnresolvedOptionError{}
+	for _, diag := range res.diagnostics {
+		if m := unknownOptionRe.FindStringSubmatch(diag.Message); m != nil && diag.Severity == SeverityError {
+			if unresolved.Option == "" {
+				unresolved.Option = m[1]
+			}
+			unresolved.Diagnostics = append(unresolved.Diagnostics, diag)
+		}
+	}
+	if len(unresolved.Diagnostics) != 0 {
+		return unresolved
+	}
+	return res.err()
+}
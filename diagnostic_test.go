@@ -0,0 +1,394 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf8"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	stderr := []byte(`nope.proto: File not found.
+//b.proto:2:1: Import "nope.proto" was not found or had errors.
+a.proto:3:1: warning: Import c.proto is unused.
+Could not make proto path relative: x.proto: No such file or directory
+`)
+	expected := []Diagnostic{
+		{File: "nope.proto", Severity: SeverityError, Message: "File not found."},
+		{File: "/b.proto", Line: 2, Column: 1, Severity: SeverityError, Message: `Import "nope.proto" was not found or had errors.`},
+		{File: "a.proto", Line: 3, Column: 1, Severity: SeverityWarning, Message: "Import c.proto is unused."},
+		{Severity: SeverityError, Message: "Could not make proto path relative: x.proto: No such file or directory"},
+	}
+
+	diags := ParseDiagnostics(stderr)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i := range expected {
+		if diags[i] != expected[i] {
+			t.Errorf("diagnostic %d: expected %+v, got %+v", i, expected[i], diags[i])
+		}
+	}
+	if s := diags[2].String(); s != "a.proto:3:1: warning: Import c.proto is unused." {
+		t.Errorf("unexpected String(): %s", s)
+	}
+}
+
+func TestProtocCheckJSON(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"good.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Good {
+  string name = 1;
+}
+`)},
+		"bad.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Bad {
+  Missing value = 1;
+}
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	out, err := p.CheckJSON(ctx, nil, []string{"good.proto"})
+	if err != nil {
+		t.Fatalf("CheckJSON failed: %v", err)
+	}
+	if string(out) != "[]" {
+		t.Errorf("expected no diagnostics, got: %s", out)
+	}
+
+	out, err = p.CheckJSON(ctx, nil, []string{"bad.proto"})
+	if err != nil {
+		t.Fatalf("CheckJSON failed: %v", err)
+	}
+
+	var diags []map[string]any
+	if err := json.Unmarshal(out, &diags); err != nil {
+		t.Fatalf("invalid JSON %s: %v", out, err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got: %s", out)
+	}
+	diag := diags[0]
+	if diag["file"] != "/bad.proto" {
+		t.Errorf("unexpected file: %v", diag["file"])
+	}
+	if diag["line"] != float64(6) || diag["column"] != float64(3) {
+		t.Errorf("unexpected position: %v:%v", diag["line"], diag["column"])
+	}
+	if diag["severity"] != "error" {
+		t.Errorf("unexpected severity: %v", diag["severity"])
+	}
+	if diag["message"] != `"Missing" is not defined.` {
+		t.Errorf("unexpected message: %v", diag["message"])
+	}
+}
+
+func TestProtocCheckAll(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte("syntax = \"proto3\";\nmessage A { Missing m = 1; }\n")},
+		"b.proto": &fstest.MapFile{Data: []byte("syntax = \"proto3\";\n\nmessage B { Other o = 1; }\n")},
+		"c.proto": &fstest.MapFile{Data: []byte("syntax = \"proto3\";\nmessage C {}\n")},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// protoc alone stops after the first file with errors.
+	diags, err := p.Check(ctx, nil, []string{"a.proto", "b.proto", "c.proto"})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("expected protoc to report a.proto only, got %v", diags)
+	}
+
+	diags, err = p.CheckAll(ctx, nil, []string{"a.proto", "b.proto", "c.proto"})
+	if err != nil {
+		t.Fatalf("CheckAll failed: %v", err)
+	}
+	type position struct {
+		file string
+		line int
+	}
+	var positions []position
+	for _, diag := range diags {
+		positions = append(positions, position{path.Base(diag.File), diag.Line})
+	}
+	expected := []position{{"a.proto", 2}, {"b.proto", 3}}
+	if !slices.Equal(positions, expected) {
+		t.Errorf("expected %v, got %v", expected, diags)
+	}
+}
+
+func TestProtocCheckImports(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "b.proto";
+import "missing/x.proto";
+message A { B b = 1; }
+`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "missing/y.proto";
+message B { Undefined u = 1; }
+`)},
+		"c.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "google/protobuf/empty.proto";
+message C { Undefined u = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	missing, err := p.CheckImports(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("CheckImports failed: %v", err)
+	}
+	expected := []string{"missing/x.proto", "missing/y.proto"}
+	if !slices.Equal(missing, expected) {
+		t.Errorf("expected %v, got %v", expected, missing)
+	}
+
+	// Other errors don't count as unresolved imports.
+	missing, err = p.CheckImports(ctx, nil, []string{"c.proto"})
+	if err != nil {
+		t.Fatalf("CheckImports failed: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected all imports to resolve, got %v", missing)
+	}
+}
+
+func TestProtocValidateReserved(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+message A {
+  reserved 2, 5 to 7;
+  reserved "old";
+  string name = 1;
+  string reused = 6;
+  string old = 3;
+  Undefined u = 4;
+}
+enum E {
+  E_ZERO = 0;
+  reserved "E_GONE";
+  E_GONE = 1;
+}
+`)},
+		"ok.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+message Ok { reserved 2; string name = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	conflicts, err := p.ValidateReserved(ctx, nil, []string{"a.proto"})
+	if err != nil {
+		t.Fatalf("ValidateReserved failed: %v", err)
+	}
+	expected := []ReservedConflict{
+		{Name: "reused", Number: 6},
+		{Name: "old"},
+		{Name: "E_GONE", EnumValue: true},
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("expected %d conflicts, got %+v", len(expected), conflicts)
+	}
+	for i, c := range conflicts {
+		if path.Base(c.File) != "a.proto" || c.Line == 0 {
+			t.Errorf("expected a position in a.proto, got %+v", c)
+		}
+		c.File, c.Line, c.Column = "", 0, 0
+		if c != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], c)
+		}
+	}
+
+	conflicts, err = p.ValidateReserved(ctx, nil, []string{"ok.proto"})
+	if err != nil {
+		t.Fatalf("ValidateReserved failed: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("expected no conflicts, got %+v", conflicts)
+	}
+}
+
+func TestProtocResolveCustomOptions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"defs/acme/options.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package acme;
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.FieldOptions { string label = 50000; }
+`)},
+		"src/a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "acme/options.proto";
+message A { string name = 1 [(acme.label) = "Name"]; }
+`)},
+		"src/b.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+message B { string name = 1 [(acme.label) = "Name"]; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	if err := p.ResolveCustomOptions(ctx, []string{"/src", "/defs"}, []string{"a.proto"}); err != nil {
+		t.Errorf("ResolveCustomOptions failed: %v", err)
+	}
+
+	// The definition is not on the include path.
+	err := p.ResolveCustomOptions(ctx, []string{"/src"}, []string{"b.proto"})
+	var unresolved *UnresolvedOptionError
+	if !errors.As(err, &unresolved) {
+		t.Fatalf("expected UnresolvedOptionError, got %v", err)
+	}
+	if unresolved.Option != "acme.label" || len(unresolved.Diagnostics) != 1 || unresolved.Diagnostics[0].Line != 3 {
+		t.Errorf("unexpected error %+v", unresolved)
+	}
+	if !strings.Contains(err.Error(), "(acme.label)") {
+		t.Errorf("expected option name in %q", err)
+	}
+}
+
+func TestNormalizeDiagnostics(t *testing.T) {
+	stderr := []byte("proto\\a/b\\c.proto:3:5: Expected \"\\\\n\".\n" +
+		"src\\d.proto: File not found.\n" +
+		"e.proto:1:1: warning: bad byte \xff here\n" +
+		"plain message with a\\backslash\n")
+
+	normalized := normalizeDiagnostics(stderr)
+	if !utf8.Valid(normalized) {
+		t.Fatalf("expected valid UTF-8, got %q", normalized)
+	}
+	expected := []Diagnostic{
+		{File: "proto/a/b/c.proto", Line: 3, Column: 5, Severity: SeverityError, Message: `Expected "\\n".`},
+		{File: "src/d.proto", Severity: SeverityError, Message: "File not found."},
+		{File: "e.proto", Line: 1, Column: 1, Severity: SeverityWarning, Message: "bad byte \uFFFD here"},
+		{Severity: SeverityError, Message: `plain message with a\backslash`},
+	}
+	if diags := ParseDiagnostics(normalized); !reflect.DeepEqual(diags, expected) {
+		t.Errorf("expected %v, got %v", expected, diags)
+	}
+	if !bytes.Contains(stderr, []byte("proto\\a")) {
+		t.Error("expected the input to be left unchanged")
+	}
+}
+
+func TestProtocDiagnosticSink(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3";
+message A { Missing m = 1; }
+message B { Unknown u = 1; }
+`)},
+	}
+
+	var received []Diagnostic
+	p := newTestProtoc(t, &Config{
+		FS:             memFS,
+		DiagnosticSink: func(d Diagnostic) { received = append(received, d) },
+	})
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"bad.proto"}})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+	if len(received) < 2 || !reflect.DeepEqual(received, compileErr.Diagnostics) {
+		t.Errorf("expected the sink to receive %v, got %v", compileErr.Diagnostics, received)
+	}
+
+	// Lines split across writes are reported once complete, and a
+	// trailing unterminated line when flushed.
+	received = nil
+	w := &diagnosticWriter{sink: func(d Diagnostic) { received = append(received, d) }}
+	for _, chunk := range []string{"a.proto:1:", "2: first\n\nb.pro", "to: second\nthird"} {
+		w.Write([]byte(chunk))
+	}
+	if len(received) != 2 {
+		t.Fatalf("expected 2 complete diagnostics, got %v", received)
+	}
+	w.flush()
+	expected := []Diagnostic{
+		{File: "a.proto", Line: 1, Column: 2, Severity: SeverityError, Message: "first"},
+		{File: "b.proto", Severity: SeverityError, Message: "second"},
+		{Severity: SeverityError, Message: "third"},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+
+	// Lines are normalized like the captured output if configured.
+	received = nil
+	w = &diagnosticWriter{sink: func(d Diagnostic) { received = append(received, d) }, normalize: true}
+	w.Write([]byte("dir\\a.proto:1:2: bad \xff\n"))
+	expected = []Diagnostic{{File: "dir/a.proto", Line: 1, Column: 2, Severity: SeverityError, Message: "bad \uFFFD"}}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+func TestProtocNormalizeOutputDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte("syntax = \"proto3\";\nimport \"missing\xff.proto\";\n")},
+	}
+
+	for _, normalize := range []bool{false, true} {
+		p := newTestProtoc(t, &Config{FS: memFS, NormalizeOutput: normalize})
+		diags, err := p.Check(ctx, nil, []string{"bad.proto"})
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		_, err = p.Compile(ctx, CompileOptions{Files: []string{"bad.proto"}})
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) {
+			t.Fatalf("expected CompileError, got %v", err)
+		}
+		if !reflect.DeepEqual(diags, compileErr.Diagnostics) {
+			t.Errorf("expected Check and Compile to agree, got %v and %v", diags, compileErr.Diagnostics)
+		}
+
+		var found bool
+		for _, d := range diags {
+			if !strings.Contains(d.String(), "missing") {
+				continue
+			}
+			found = true
+			if valid := utf8.ValidString(d.String()); valid != normalize {
+				t.Errorf("NormalizeOutput %v: expected valid UTF-8 %v, got %q", normalize, normalize, d)
+			}
+			if normalize && !strings.Contains(d.String(), "missing\uFFFD.proto") {
+				t.Errorf("expected the invalid byte to be replaced, got %q", d)
+			}
+		}
+		if !found {
+			t.Errorf("NormalizeOutput %v: expected a diagnostic for the missing import, got %v", normalize, diags)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// sourcesDir is the scratch directory in-memory sources are written to.
+const sourcesDir = "src"
+
+// Diff describes how a generated file differs between two runs.
+type Diff struct {
+	// Added is set if only the second run generated the file.
+	Added bool
+	// Removed is set if only the first run generated the file.
+	Removed bool
+	// Text is a line diff of the file contents. Each line is prefixed with
+	// "-" if it was removed, "+" if it was added or " " if unchanged.
+	Text string
+}
+
+// DiffGenerations compiles sources, keyed by file name, with two sets of
+// generator flags and returns a Diff for each generated file whose path or
+// contents differ. Files generated identically by both runs are omitted.
+//
+// The output directories of --<name>_out flags in argsA and argsB are
+// relative to an in-memory output root, for example "--go_out=." or
+// "--go_out=paths=source_relative:gen". So are the files of
+// --descriptor_set_out and -o, which are diffed like generated files. The
+// well-known types may be imported.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) DiffGenerations(ctx context.Context, sources map[string]string, argsA, argsB []string) (map[string]Diff, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, err := p.generateSources(ctx, sources, argsA)
+	if err != nil {
+		return nil, err
+	}
+	b, err := p.generateSources(ctx, sources, argsB)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make(map[string]Diff)
+	for name, dataA := range a {
+		dataB, ok := b[name]
+		switch {
+		case !ok:
+			diffs[name] = Diff{Removed: true, Text: diffLines(dataA, nil)}
+		case !bytes.Equal(dataA, dataB):
+			diffs[name] = Diff{Text: diffLines(dataA, dataB)}
+		}
+	}
+	for name, dataB := range b {
+		if _, ok := a[name]; !ok {
+			diffs[name] = Diff{Added: true, Text: diffLines(nil, dataB)}
+		}
+	}
+	return diffs, nil
+}
+
+// generateSources writes sources to the scratch filesystem and compiles
+// them with args, returning the generated files as by generateOutputs.
+// p.mu must be held.
+func (p *Protoc) generateSources(ctx context.Context, sources map[string]string, args []string) (map[string][]byte, error) {
+	p.scratch.Clear()
+
+	files := make([]string, 0, len(sources))
+	for name, src := range sources {
+		if !fs.ValidPath(name) {
+			return nil, errors.New("invalid source path: " + name)
+		}
+		if err := p.scratch.WriteFile(path.Join(sourcesDir, name), []byte(src)); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	baseArgs := []string{p.descriptorSetInArg(), "-I" + path.Join(scratchDir, sourcesDir)}
+	return p.generateOutputs(ctx, baseArgs, args, files)
+}
+
+// diffLines returns a line diff of a and b based on their longest common
+// subsequence of lines. Generated files can be tens of thousands of lines,
+// so the common prefix and suffix are skipped and the rest is diffed with
+// Hirschberg's algorithm, which needs memory linear in the input.
+func diffLines(a, b []byte) string {
+	linesA, linesB := splitLines(a), splitLines(b)
+	prefix := 0
+	for prefix < len(linesA) && prefix < len(linesB) && linesA[prefix] == linesB[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(linesA)-prefix && suffix < len(linesB)-prefix &&
+		linesA[len(linesA)-1-suffix] == linesB[len(linesB)-1-suffix] {
+		suffix++
+	}
+
+	var sb strings.Builder
+	writeLines(&sb, " ", linesA[:prefix])
+	writeDiff(&sb, linesA[prefix:len(linesA)-suffix], linesB[prefix:len(linesB)-suffix])
+	writeLines(&sb, " ", linesA[len(linesA)-suffix:])
+	return sb.String()
+}
+
+// writeDiff writes a line diff of a and b to sb. It splits a in half and b
+// where the longest common subsequences of the halves add up to the
+// longest, and diffs the two parts separately.
+func writeDiff(sb *strings.Builder, a, b []string) {
+	switch {
+	case len(a) == 0:
+		writeLines(sb, "+", b)
+		return
+	case len(b) == 0:
+		writeLines(sb, "-", a)
+		return
+	case len(a) == 1:
+		i := slices.Index(b, a[0])
+		if i < 0 {
+			writeLines(sb, "-", a)
+			writeLines(sb, "+", b)
+			return
+		}
+		writeLines(sb, "+", b[:i])
+		writeLines(sb, " ", a)
+		writeLines(sb, "+", b[i+1:])
+		return
+	}
+
+	mid := len(a) / 2
+	head := lcsLengths(a[:mid], b)
+	tail := lcsLengths(reversed(a[mid:]), reversed(b))
+	split, best := 0, -1
+	for j := range head {
+		if n := head[j] + tail[len(b)-j]; n > best {
+			split, best = j, n
+		}
+	}
+	writeDiff(sb, a[:mid], b[:split])
+	writeDiff(sb, a[mid:], b[split:])
+}
+
+// lcsLengths returns, for each j, the length of the longest common
+// subsequence of a and b[:j], keeping only two rows of the table.
+func lcsLengths(a, b []string) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for _, lineA := range a {
+		for j, lineB := range b {
+			if lineA == lineB {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// reversed returns a reversed copy of lines.
+func reversed(lines []string) []string {
+	r := slices.Clone(lines)
+	slices.Reverse(r)
+	return r
+}
+
+// writeLines writes lines to sb, each with prefix.
+func writeLines(sb *strings.Builder, prefix string, lines []string) {
+	for _, line := range lines {
+		sb.WriteString(prefix + line + "\n")
+	}
+}
+
+// splitLines splits data into lines without their terminators.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
@@ -0,0 +1,156 @@
+package protoc
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := []byte("one\ntwo\nthree\n")
+	b := []byte("one\n2\nthree\nfour\n")
+	expected := " one\n-two\n+2\n three\n+four\n"
+	if text := diffLines(a, b); text != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, text)
+	}
+	if text := diffLines(a, nil); text != "-one\n-two\n-three\n" {
+		t.Errorf("unexpected removal diff:\n%s", text)
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// Changes at both ends leave nothing to trim, exercising the linear
+	// memory diff on the whole file.
+	var a, b strings.Builder
+	for i := 0; i < 5000; i++ {
+		line := "line " + strconv.Itoa(i) + "\n"
+		a.WriteString(line)
+		if i%1000 != 0 && i != 4999 {
+			b.WriteString(line)
+		} else {
+			b.WriteString("changed " + strconv.Itoa(i) + "\n")
+		}
+	}
+	text := diffLines([]byte(a.String()), []byte(b.String()))
+	var removed, added, unchanged int
+	for _, line := range splitLines([]byte(text)) {
+		switch line[0] {
+		case '-':
+			removed++
+		case '+':
+			added++
+		default:
+			unchanged++
+		}
+	}
+	if removed != 6 || added != 6 || unchanged != 4994 {
+		t.Errorf("expected 6 removed, 6 added and 4994 unchanged lines, got %d, %d and %d", removed, added, unchanged)
+	}
+}
+
+func TestProtocDiffGenerations(t *testing.T) {
+	ctx := context.Background()
+	sources := map[string]string{
+		"example/v1/person.proto": `
+syntax = "proto3";
+package example.v1;
+
+option go_package = "example.com/gen/examplev1";
+
+message Person {
+  string name = 1;
+}
+`,
+	}
+
+	// A fake protoc-gen-go honoring the paths parameter.
+	fakeGo := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		var req pluginpb.CodeGeneratorRequest
+		if err := proto.Unmarshal(input, &req); err != nil {
+			return nil, err
+		}
+		resp := &pluginpb.CodeGeneratorResponse{}
+		for _, file := range req.GetProtoFile() {
+			name := path.Join(file.GetOptions().GetGoPackage(), path.Base(file.GetName()))
+			if req.GetParameter() == "paths=source_relative" {
+				name = file.GetName()
+			}
+			resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+				Name:    proto.String(strings.TrimSuffix(name, ".proto") + ".pb.go"),
+				Content: proto.String("package examplev1\n"),
+			})
+		}
+		return proto.Marshal(resp)
+	})
+
+	p := newTestProtoc(t, &Config{PluginHandler: fakeGo})
+
+	diffs, err := p.DiffGenerations(ctx, sources,
+		[]string{"--go_out=."},
+		[]string{"--go_out=paths=source_relative:."},
+	)
+	if err != nil {
+		t.Fatalf("DiffGenerations failed: %v", err)
+	}
+
+	expected := map[string]Diff{
+		"example.com/gen/examplev1/person.pb.go": {Removed: true, Text: "-package examplev1\n"},
+		"example/v1/person.pb.go":                {Added: true, Text: "+package examplev1\n"},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, diffs)
+	}
+
+	// Identical configurations produce no diffs.
+	diffs, err = p.DiffGenerations(ctx, sources, []string{"--go_out=."}, []string{"--go_out=."})
+	if err != nil {
+		t.Fatalf("DiffGenerations failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no diffs, got %+v", diffs)
+	}
+}
+
+func TestProtocDiffGenerationsOutputFlags(t *testing.T) {
+	ctx := context.Background()
+	sources := map[string]string{"foo.proto": fooProto}
+
+	// A fake plugin writing its parameter.
+	param := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		var req pluginpb.CodeGeneratorRequest
+		if err := proto.Unmarshal(input, &req); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(&pluginpb.CodeGeneratorResponse{
+			File: []*pluginpb.CodeGeneratorResponse_File{{
+				Name:    proto.String("param.txt"),
+				Content: proto.String(req.GetParameter() + "\n"),
+			}},
+		})
+	})
+
+	p := newTestProtoc(t, &Config{PluginHandler: param})
+
+	diffs, err := p.DiffGenerations(ctx, sources,
+		[]string{"--x_out=a=1:gen", "--descriptor_set_out=sets/a.pb"},
+		[]string{"--x_out=a=2:gen", "-osets/b.pb"},
+	)
+	if err != nil {
+		t.Fatalf("DiffGenerations failed: %v", err)
+	}
+	if len(diffs) != 3 {
+		t.Errorf("expected 3 diffs, got %+v", diffs)
+	}
+	if d := diffs["gen/param.txt"]; d.Text != "-a=1\n+a=2\n" {
+		t.Errorf("expected the parameters to differ, got %+v", d)
+	}
+	if !diffs["sets/a.pb"].Removed || !diffs["sets/b.pb"].Added {
+		t.Errorf("expected the descriptor sets as output files, got %+v", diffs)
+	}
+}
@@ -0,0 +1,83 @@
+package protoc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+)
+
+// OutputsDigest returns a SHA-256 digest of files that is stable across
+// runs, for use in build cache keys. Files are hashed in name order, each
+// name and content prefixed with its length so that no two distinct sets of
+// files hash the same input.
+func OutputsDigest(files map[string][]byte) [32]byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	var lenBuf [8]byte
+	for _, name := range names {
+		binary.BigEndian.PutUint64(lenBuf[:], uint64(len(name)))
+		h.Write(lenBuf[:])
+		h.Write([]byte(name))
+		data := files[name]
+		binary.BigEndian.PutUint64(lenBuf[:], uint64(len(data)))
+		h.Write(lenBuf[:])
+		h.Write(data)
+	}
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// ManifestEntry describes a generated file.
+type ManifestEntry struct {
+	// Generator is the name of the generator that produced the file.
+	Generator string `json:"generator"`
+	// Path is the path of the file in the collected output.
+	Path string `json:"path"`
+	// SHA256 is the hex-encoded SHA-256 digest of the content.
+	SHA256 string `json:"sha256"`
+	// Size is the content length in bytes.
+	Size int `json:"size"`
+}
+
+// GenerateWithManifest compiles files and runs gens like RunGenerators,
+// returning the outputs together with a manifest of every generated file,
+// sorted by generator and path, for build caches, integrity checks and
+// incremental rebuilds.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) GenerateWithManifest(ctx context.Context, includePaths, files []string, gens []GeneratorSpec) (map[string]map[string][]byte, []ManifestEntry, error) {
+	outputs, err := p.RunGenerators(ctx, includePaths, files, gens)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest []ManifestEntry
+	for gen, genOutputs := range outputs {
+		for name, data := range genOutputs {
+			sum := sha256.Sum256(data)
+			manifest = append(manifest, ManifestEntry{
+				Generator: gen,
+				Path:      name,
+				SHA256:    hex.EncodeToString(sum[:]),
+				Size:      len(data),
+			})
+		}
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		if manifest[i].Generator != manifest[j].Generator {
+			return manifest[i].Generator < manifest[j].Generator
+		}
+		return manifest[i].Path < manifest[j].Path
+	})
+	return outputs, manifest, nil
+}
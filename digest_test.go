@@ -0,0 +1,79 @@
+package protoc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOutputsDigest(t *testing.T) {
+	names := []string{"a.pb.go", "b/b.pb.go", "c/c_grpc.pb.go"}
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		files[name] = []byte("package " + name[:1])
+	}
+	digest := OutputsDigest(files)
+
+	// Inserting in reverse order and hashing repeatedly, with Go's random
+	// map iteration order, must not change the digest.
+	reordered := make(map[string][]byte, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		reordered[names[i]] = files[names[i]]
+	}
+	for i := 0; i < 10; i++ {
+		if OutputsDigest(reordered) != digest {
+			t.Fatal("digest changed with map order")
+		}
+	}
+
+	changed := map[string][]byte{
+		"a.pb.go":        []byte("package a"),
+		"b/b.pb.go":      []byte("package b"),
+		"c/c_grpc.pb.go": []byte("package c2"),
+	}
+	if OutputsDigest(changed) == digest {
+		t.Error("digest unchanged after content change")
+	}
+
+	// Moving bytes between a name and its content must change the digest.
+	if OutputsDigest(map[string][]byte{"ab": []byte("c")}) == OutputsDigest(map[string][]byte{"a": []byte("bc")}) {
+		t.Error("digest ambiguous between name and content")
+	}
+}
+
+func TestProtocGenerateWithManifest(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"foo.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package foo; message Foo {}`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	outputs, manifest, err := p.GenerateWithManifest(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{
+		{Name: "python"},
+		{Name: "cpp", OutDir: "cpp"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateWithManifest failed: %v", err)
+	}
+	var paths []string
+	for _, entry := range manifest {
+		paths = append(paths, entry.Generator+":"+entry.Path)
+		data, ok := outputs[entry.Generator][entry.Path]
+		if !ok {
+			t.Errorf("manifest entry %s:%s is not an output", entry.Generator, entry.Path)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if entry.SHA256 != hex.EncodeToString(sum[:]) || entry.Size != len(data) {
+			t.Errorf("manifest entry %v does not match the output", entry)
+		}
+	}
+	expected := []string{"cpp:cpp/foo.pb.cc", "cpp:cpp/foo.pb.h", "python:foo_pb2.py"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected manifest of %v, got %v", expected, paths)
+	}
+}
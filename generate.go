@@ -0,0 +1,321 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// GeneratorSpec describes a code generator to run.
+type GeneratorSpec struct {
+	// Name is the generator name as used in the --<name>_out flag, for
+	// example "cpp" for a built-in generator or "go" for protoc-gen-go.
+	Name string `json:"name"`
+	// OutDir is the directory the generated files are placed under in the
+	// collected output. Default: the output root.
+	OutDir string `json:"out_dir,omitempty"`
+	// Params are passed to the generator with --<name>_opt.
+	Params []string `json:"params,omitempty"`
+	// Route, if set, returns the directory each generated file is placed
+	// under in the collected output, given its path relative to the
+	// generator output root, for example to separate files by extension.
+	// Returning "" places the file under OutDir.
+	Route func(filename string) string `json:"-"`
+}
+
+// outputPath returns the path of the generated file name in the collected
+// output, before Config.OutputPathMapper is applied.
+func (gen *GeneratorSpec) outputPath(name string) string {
+	if gen.Route != nil {
+		if dir := gen.Route(name); dir != "" {
+			return path.Join(dir, name)
+		}
+	}
+	return path.Join(gen.OutDir, name)
+}
+
+// generatorArgs creates the scratch output directory for gen and returns the
+// protoc flags that run it. The scratch filesystem must have been cleared.
+func (p *Protoc) generatorArgs(gen GeneratorSpec) ([]string, error) {
+	if gen.Name == "" {
+		return nil, errors.New("generator name is empty")
+	}
+	if strings.ContainsAny(gen.Name, "/=") {
+		return nil, errors.New("invalid generator name: " + gen.Name)
+	}
+
+	outDir := path.Join(generatorOutDir, gen.Name)
+	if p.scratch.exists(outDir) {
+		return nil, errors.New("duplicate generator: " + gen.Name)
+	}
+	if err := p.scratch.MkdirAll(outDir); err != nil {
+		return nil, err
+	}
+
+	args := []string{"--" + gen.Name + "_out=" + path.Join(scratchDir, outDir)}
+	if len(gen.Params) != 0 {
+		args = append(args, "--"+gen.Name+"_opt="+strings.Join(gen.Params, ","))
+	}
+	return args, nil
+}
+
+// FilterGenerators returns the generators in gens whose names match the
+// glob pattern, such as "go*" or "cpp", using path.Match syntax. An empty
+// pattern matches every generator.
+func FilterGenerators(gens []GeneratorSpec, pattern string) ([]GeneratorSpec, error) {
+	if pattern == "" {
+		return gens, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var matched []GeneratorSpec
+	for _, gen := range gens {
+		if ok, _ := path.Match(pattern, gen.Name); ok {
+			matched = append(matched, gen)
+		}
+	}
+	return matched, nil
+}
+
+// RunGenerators compiles files and runs all gens in a single protoc
+// invocation. The generated files are returned keyed by generator name and
+// then by path relative to the generator output root.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) RunGenerators(ctx context.Context, includePaths, files []string, gens []GeneratorSpec) (map[string]map[string][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files}, gens)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		return nil, err
+	}
+	return p.generatorOutputs(gens), nil
+}
+
+// Bundle is the complete output of a compilation: the descriptors and the
+// generated files.
+type Bundle struct {
+	// DescriptorSet is the encoded FileDescriptorSet of the compiled files
+	// and their imports.
+	DescriptorSet []byte
+	// Files are the generated files keyed by generator name and then by
+	// path, as returned by RunGenerators.
+	Files map[string]map[string][]byte
+}
+
+// Bundle compiles files and runs gens in a single protoc invocation,
+// returning the self-contained descriptor set together with the generated
+// files, for build systems that distribute both.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) Bundle(ctx context.Context, includePaths, files []string, gens []GeneratorSpec) (Bundle, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	}, gens)
+	if err != nil {
+		return Bundle{}, err
+	}
+	if err := res.err(); err != nil {
+		return Bundle{}, err
+	}
+	return Bundle{DescriptorSet: res.descSet, Files: p.generatorOutputs(gens)}, nil
+}
+
+// GenerateFile compiles files, runs gen and returns the contents of the
+// single generated file at outputPath, relative to the generator output
+// root as in the result of RunGenerators. Only that file is read from the
+// output, so generators producing many files need not be collected. If gen
+// did not produce the file the error wraps fs.ErrNotExist.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) GenerateFile(ctx context.Context, includePaths, files []string, gen GeneratorSpec, outputPath string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files}, []GeneratorSpec{gen})
+	if err != nil {
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		return nil, err
+	}
+	data, ok := p.generatorOutput(gen, path.Clean(outputPath))
+	if !ok {
+		return nil, &fs.PathError{Op: "generate", Path: outputPath, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+// RunToDirOptions configures RunToDir.
+type RunToDirOptions struct {
+	// SkipUnchanged leaves files whose content is unchanged untouched,
+	// preserving their modification times so that file watchers and
+	// incremental builds don't see them as changed.
+	// Default: every generated file is rewritten.
+	SkipUnchanged bool
+}
+
+// RunToDir compiles files, runs all gens as RunGenerators does and writes
+// the generated files under dir on the host filesystem, creating
+// directories as needed. Files already in dir that were not generated are
+// left in place.
+//
+// If protoc fails the returned error is a *CompileError and dir is not
+// modified. If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) RunToDir(ctx context.Context, dir string, includePaths, files []string, gens []GeneratorSpec, opts RunToDirOptions) error {
+	outputs, err := p.RunGenerators(ctx, includePaths, files, gens)
+	if err != nil {
+		return err
+	}
+	for _, genOutputs := range outputs {
+		for name, data := range genOutputs {
+			hostPath := filepath.Join(dir, filepath.FromSlash(name))
+			if opts.SkipUnchanged {
+				if existing, err := os.ReadFile(hostPath); err == nil && bytes.Equal(existing, data) {
+					continue
+				}
+			}
+			if err := os.MkdirAll(filepath.Dir(hostPath), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(hostPath, data, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GenerateFromFileDescriptors runs protoc on fds, descriptors constructed
+// programmatically rather than parsed from .proto files, and returns the
+// generated files keyed by path relative to the output root. Every file in
+// fds is generated; its dependencies must be in fds too, or be well-known
+// types or precompiled imports.
+//
+// The output directories of --<name>_out flags and the files of
+// --descriptor_set_out and -o in args are relative to an in-memory output
+// root, as for DiffGenerations. --dependency_out is rejected, as the
+// descriptors have no source files to depend on.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) GenerateFromFileDescriptors(ctx context.Context, fds []*descriptorpb.FileDescriptorProto, args []string) (map[string][]byte, error) {
+	for _, arg := range args {
+		if arg == "--dependency_out" || strings.HasPrefix(arg, "--dependency_out=") {
+			return nil, errors.New("--dependency_out cannot be used with GenerateFromFileDescriptors")
+		}
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: fds})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(fds))
+	for i, fd := range fds {
+		files[i] = fd.GetName()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scratch.Clear()
+	if err := p.scratch.WriteFile(inputDescriptorSetFile, data); err != nil {
+		return nil, err
+	}
+	baseArgs := []string{p.descriptorSetInArg(path.Join(scratchDir, inputDescriptorSetFile))}
+	return p.generateOutputs(ctx, baseArgs, args, files)
+}
+
+// generateOutputs runs protoc with baseArgs, args and files and returns the
+// generated files keyed by path relative to the output root. The outputs
+// of the flags in args are moved under the scratch output root by
+// rewriteOutArg. The scratch filesystem must have been prepared.
+// p.mu must be held.
+func (p *Protoc) generateOutputs(ctx context.Context, baseArgs, args, files []string) (map[string][]byte, error) {
+	protocArgs := append([]string{"protoc"}, baseArgs...)
+	for _, arg := range args {
+		arg, err := p.rewriteOutArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		protocArgs = append(protocArgs, arg)
+	}
+	protocArgs = append(protocArgs, files...)
+
+	exitCode, _, stderr, err := p.runCapture(ctx, protocArgs)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, &CompileError{ExitCode: exitCode, Diagnostics: ParseDiagnostics(stderr)}
+	}
+	outputs := make(map[string][]byte)
+	for name, data := range p.scratch.files(generatorOutDir) {
+		if name := p.mapOutputPath(name); name != "" {
+			outputs[name] = p.decorateOutput(name, data)
+		}
+	}
+	return outputs, nil
+}
+
+// rewriteOutArg moves the outputs of a flag under the scratch output root,
+// as withWorkingDir does for Config.WorkingDir: the directories of
+// --<name>_out and the files of --descriptor_set_out, -o and
+// --dependency_out, creating the directories they are written to. Other
+// flags are returned unchanged.
+func (p *Protoc) rewriteOutArg(arg string) (string, error) {
+	flag, value, ok := strings.Cut(arg, "=")
+	switch {
+	case !ok:
+		if file, ok := strings.CutPrefix(arg, "-o"); ok && file != "" {
+			return p.scratchOutputArg("-o", file, false)
+		}
+	case flag == "--descriptor_set_out" || flag == "--dependency_out":
+		return p.scratchOutputArg(flag+"=", value, false)
+	case strings.HasPrefix(flag, "--") && strings.HasSuffix(flag, "_out"):
+		// protoc splits off the generator parameters at the first colon.
+		if params, dir, ok := strings.Cut(value, ":"); ok {
+			return p.scratchOutputArg(flag+"="+params+":", dir, true)
+		}
+		return p.scratchOutputArg(flag+"=", value, true)
+	}
+	return arg, nil
+}
+
+// scratchOutputArg returns prefix followed by name moved under the scratch
+// output root, creating name if it is a directory or else its parent.
+func (p *Protoc) scratchOutputArg(prefix, name string, isDir bool) (string, error) {
+	outPath := path.Join(generatorOutDir, path.Clean("/"+name))
+	dir := outPath
+	if !isDir {
+		dir = path.Dir(outPath)
+	}
+	if err := p.scratch.MkdirAll(dir); err != nil {
+		return "", err
+	}
+	return prefix + path.Join(scratchDir, outPath), nil
+}
@@ -0,0 +1,401 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtocRunGenerators(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"test/person.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Person {
+  string name = 1;
+  int32 age = 2;
+}
+`)},
+	}
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	outputs, err := p.RunGenerators(ctx, nil, []string{"test/person.proto"}, []GeneratorSpec{
+		{Name: "cpp", OutDir: "cpp"},
+		{Name: "python", Params: []string{"pyi_out"}},
+	})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+
+	if len(outputs) != 2 {
+		t.Fatalf("expected outputs for 2 generators, got %d", len(outputs))
+	}
+	for gen, files := range map[string][]string{
+		"cpp":    {"cpp/test/person.pb.h", "cpp/test/person.pb.cc"},
+		"python": {"test/person_pb2.py", "test/person_pb2.pyi"},
+	} {
+		if len(outputs[gen]) != len(files) {
+			t.Errorf("%s: expected %d files, got %v", gen, len(files), keys(outputs[gen]))
+		}
+		for _, file := range files {
+			if !bytes.Contains(outputs[gen][file], []byte("Person")) {
+				t.Errorf("%s: expected %s to contain Person", gen, file)
+			}
+		}
+	}
+}
+
+func TestProtocRunGeneratorsError(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`)},
+	}
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	_, err := p.RunGenerators(ctx, nil, []string{"bad.proto"}, []GeneratorSpec{{Name: "cpp"}})
+	compileErr, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("expected *CompileError, got %v", err)
+	}
+	if compileErr.ExitCode == 0 || len(compileErr.Diagnostics) == 0 {
+		t.Errorf("unexpected compile error: %+v", compileErr)
+	}
+
+	_, err = p.RunGenerators(ctx, nil, []string{"bad.proto"}, []GeneratorSpec{{Name: "cpp"}, {Name: "cpp"}})
+	if err == nil {
+		t.Error("expected error for duplicate generators")
+	}
+}
+
+// keys returns the keys of m for test failure messages.
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestProtocBundle(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+
+	bundle, err := p.Bundle(ctx, []string{"/testdata/protos"}, []string{"example/v1/greeter.proto"}, []GeneratorSpec{
+		{Name: "cpp"},
+		{Name: "python", OutDir: "py"},
+	})
+	if err != nil {
+		t.Fatalf("Bundle failed: %v", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(bundle.DescriptorSet, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	expected := []string{"example/v1/types.proto", "example/v1/greeter.proto"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected descriptors for %v, got %v", expected, names)
+	}
+
+	if _, ok := bundle.Files["cpp"]["example/v1/greeter.pb.h"]; !ok {
+		t.Errorf("expected greeter.pb.h, got %v", keys(bundle.Files["cpp"]))
+	}
+	if _, ok := bundle.Files["python"]["py/example/v1/greeter_pb2.py"]; !ok {
+		t.Errorf("expected greeter_pb2.py, got %v", keys(bundle.Files["python"]))
+	}
+}
+
+func TestProtocGenerateFile(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+	includePaths := []string{"/testdata/protos"}
+	files := []string{"example/v1/greeter.proto", "example/v1/types.proto"}
+	gen := GeneratorSpec{Name: "cpp", OutDir: "cpp"}
+
+	data, err := p.GenerateFile(ctx, includePaths, files, gen, "cpp/example/v1/types.pb.h")
+	if err != nil {
+		t.Fatalf("GenerateFile failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte("class HelloRequest")) {
+		t.Errorf("expected types.pb.h to declare HelloRequest")
+	}
+
+	_, err = p.GenerateFile(ctx, includePaths, files, gen, "cpp/example/v1/missing.pb.h")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a file not generated, got: %v", err)
+	}
+
+	// The path is the collected one, after Config.OutputPathMapper, and the
+	// file is decorated like the collected outputs.
+	p = newTestProtoc(t, &Config{
+		FS:               testProtos,
+		OutputPathMapper: func(name string) string { return strings.TrimPrefix(name, "cpp/example/") },
+		FileHeader:       "// header\n",
+	})
+	data, err = p.GenerateFile(ctx, includePaths, files, gen, "v1/types.pb.h")
+	if err != nil {
+		t.Fatalf("GenerateFile failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("// header\n")) || !bytes.Contains(data, []byte("class HelloRequest")) {
+		t.Errorf("expected the decorated types.pb.h")
+	}
+}
+
+func TestProtocRunToDir(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{FS: testProtos})
+	includePaths := []string{"/testdata/protos"}
+	files := []string{"example/v1/types.proto"}
+	gens := []GeneratorSpec{{Name: "cpp", OutDir: "cpp"}}
+	opts := RunToDirOptions{SkipUnchanged: true}
+
+	dir := t.TempDir()
+	if err := p.RunToDir(ctx, dir, includePaths, files, gens, opts); err != nil {
+		t.Fatalf("RunToDir failed: %v", err)
+	}
+	header := filepath.Join(dir, "cpp", "example", "v1", "types.pb.h")
+	source := filepath.Join(dir, "cpp", "example", "v1", "types.pb.cc")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{header, source} {
+		if err := os.Chtimes(name, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(source, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(source, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.RunToDir(ctx, dir, includePaths, files, gens, opts); err != nil {
+		t.Fatalf("RunToDir failed: %v", err)
+	}
+	// The unchanged header keeps its modification time.
+	if info, err := os.Stat(header); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("expected %s to be left untouched: %v", header, err)
+	}
+	// The changed source is rewritten.
+	data, err := os.ReadFile(source)
+	if err != nil || !bytes.Contains(data, []byte("HelloRequest")) {
+		t.Errorf("expected %s to be regenerated: %v", source, err)
+	}
+
+	// Without SkipUnchanged every file is rewritten.
+	if err := p.RunToDir(ctx, dir, includePaths, files, gens, RunToDirOptions{}); err != nil {
+		t.Fatalf("RunToDir failed: %v", err)
+	}
+	if info, err := os.Stat(header); err != nil || info.ModTime().Equal(past) {
+		t.Errorf("expected %s to be rewritten: %v", header, err)
+	}
+}
+
+func TestFilterGenerators(t *testing.T) {
+	gens := []GeneratorSpec{{Name: "go"}, {Name: "go-grpc"}, {Name: "cpp"}, {Name: "python"}}
+	for _, tt := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{pattern: "", expected: []string{"go", "go-grpc", "cpp", "python"}},
+		{pattern: "go*", expected: []string{"go", "go-grpc"}},
+		{pattern: "[cp]*", expected: []string{"cpp", "python"}},
+		{pattern: "java", expected: nil},
+	} {
+		filtered, err := FilterGenerators(gens, tt.pattern)
+		if err != nil {
+			t.Fatalf("%q: FilterGenerators failed: %v", tt.pattern, err)
+		}
+		var names []string
+		for _, gen := range filtered {
+			names = append(names, gen.Name)
+		}
+		if !slices.Equal(names, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.pattern, tt.expected, names)
+		}
+	}
+
+	if _, err := FilterGenerators(gens, "["); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestProtocOutputPathMapper(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	// A fake protoc-gen-go emitting a file to keep and a file to drop.
+	fakeGo := fakePluginFiles(map[string]string{
+		"foo.pb.go":  "package foo\n",
+		"foo.pb.txt": "dropped\n",
+	})
+
+	p := newTestProtoc(t, &Config{
+		FS:            memFS,
+		PluginHandler: fakeGo,
+		OutputPathMapper: func(name string) string {
+			if !strings.HasSuffix(name, ".go") {
+				return ""
+			}
+			return "internal/" + strings.TrimPrefix(name, "gen/")
+		},
+	})
+
+	outputs, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go", OutDir: "gen"}})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if len(outputs["go"]) != 1 || string(outputs["go"]["internal/foo.pb.go"]) != "package foo\n" {
+		t.Errorf("expected only internal/foo.pb.go, got %v", keys(outputs["go"]))
+	}
+}
+
+func TestProtocFileHeader(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	// A fake protoc-gen-go emitting code, a text file and a binary descriptor.
+	descriptor, err := proto.Marshal(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("foo.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Foo")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeGo := fakePluginFiles(map[string]string{
+		"foo.pb.go": "package foo\n",
+		"foo.txt":   "text\n",
+		"foo.pb":    string(descriptor),
+	})
+
+	p := newTestProtoc(t, &Config{
+		FS:               memFS,
+		PluginHandler:    fakeGo,
+		FileHeader:       "// Copyright Example\n\n",
+		FileFooter:       "// end\n",
+		HeaderExtensions: []string{".go", ".pb"},
+	})
+
+	outputs, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go"}})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if s := string(outputs["go"]["foo.pb.go"]); s != "// Copyright Example\n\npackage foo\n// end\n" {
+		t.Errorf("expected header and footer on foo.pb.go, got %q", s)
+	}
+	if s := string(outputs["go"]["foo.txt"]); s != "text\n" {
+		t.Errorf("expected foo.txt unchanged, got %q", s)
+	}
+	if !bytes.Equal(outputs["go"]["foo.pb"], descriptor) {
+		t.Errorf("expected binary foo.pb unchanged, got %q", outputs["go"]["foo.pb"])
+	}
+}
+
+func TestProtocGeneratorRoute(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	// A fake protoc-gen-go emitting code, a schema and a file left unrouted.
+	fakeGo := fakePluginFiles(map[string]string{
+		"foo/foo.pb.go": "package foo\n",
+		"foo/foo.json":  "{}\n",
+		"foo/foo.txt":   "text\n",
+	})
+
+	p := newTestProtoc(t, &Config{FS: memFS, PluginHandler: fakeGo})
+
+	gen := GeneratorSpec{
+		Name:   "go",
+		OutDir: "other",
+		Route: func(name string) string {
+			switch path.Ext(name) {
+			case ".go":
+				return "go"
+			case ".json":
+				return "schema"
+			}
+			return ""
+		},
+	}
+	outputs, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{gen})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	expected := []string{"go/foo/foo.pb.go", "other/foo/foo.txt", "schema/foo/foo.json"}
+	if got := slices.Sorted(maps.Keys(outputs["go"])); !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestProtocGenerateFromFileDescriptors(t *testing.T) {
+	ctx := context.Background()
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("built/person.proto"),
+		Package: proto.String("built"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Person"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		}},
+	}
+
+	p := newTestProtoc(t, nil)
+
+	outputs, err := p.GenerateFromFileDescriptors(ctx, []*descriptorpb.FileDescriptorProto{fd}, []string{"--python_out=py"})
+	if err != nil {
+		t.Fatalf("GenerateFromFileDescriptors failed: %v", err)
+	}
+	if !bytes.Contains(outputs["py/built/person_pb2.py"], []byte("Person")) {
+		t.Errorf("expected py/built/person_pb2.py describing Person, got %v", keys(outputs))
+	}
+
+	// Descriptor sets are written to the output root as files.
+	outputs, err = p.GenerateFromFileDescriptors(ctx, []*descriptorpb.FileDescriptorProto{fd}, []string{"--descriptor_set_out=sets/out.pb"})
+	if err != nil {
+		t.Fatalf("GenerateFromFileDescriptors failed: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(outputs["sets/out.pb"], &set); err != nil || len(set.GetFile()) != 1 || set.GetFile()[0].GetName() != fd.GetName() {
+		t.Errorf("expected sets/out.pb describing %s, got %v (%v)", fd.GetName(), keys(outputs), err)
+	}
+
+	// Dependency files need sources and are rejected.
+	_, err = p.GenerateFromFileDescriptors(ctx, []*descriptorpb.FileDescriptorProto{fd}, []string{"--python_out=py", "--dependency_out=deps.d"})
+	if err == nil || !strings.Contains(err.Error(), "--dependency_out") {
+		t.Errorf("expected --dependency_out to be rejected, got %v", err)
+	}
+
+	// Invalid descriptors are reported as compile errors.
+	bad := proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+	bad.MessageType[0].Field[0].TypeName = proto.String(".built.Missing")
+	bad.MessageType[0].Field[0].Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	_, err = p.GenerateFromFileDescriptors(ctx, []*descriptorpb.FileDescriptorProto{bad}, []string{"--python_out=py"})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError, got: %v", err)
+	}
+}
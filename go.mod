@@ -2,6 +2,9 @@ module github.com/aperturerobotics/go-protoc-wasi
 
 go 1.24.0
 
-require github.com/tetratelabs/wazero v1.11.0
+require (
+	github.com/tetratelabs/wazero v1.11.0
+	google.golang.org/protobuf v1.36.12
+)
 
 require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,100 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+)
+
+// ErrPluginNotFound is returned by a PluginHandler that does not provide the
+// requested plugin, so that ChainPluginHandler tries the next handler.
+var ErrPluginNotFound = errors.New("plugin not found")
+
+// isPluginNotFound reports whether err means program does not exist, as
+// opposed to a plugin that failed. A missing file other than program itself,
+// e.g. an input the plugin could not open, is a failure.
+func isPluginNotFound(program string, err error) bool {
+	if errors.Is(err, ErrPluginNotFound) || errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) && execErr.Name == program {
+		return errors.Is(execErr.Err, fs.ErrNotExist)
+	}
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Path == program && errors.Is(pathErr.Err, fs.ErrNotExist)
+}
+
+// ChainPluginHandler returns a PluginHandler that tries handlers in order
+// until one provides the plugin. A handler that returns ErrPluginNotFound,
+// or an error for a missing program executable such as DefaultPluginHandler
+// does, passes the invocation on to the next; any other result, including
+// a plugin failing on some other missing file, is returned. If
+// no handler provides the plugin, the error of the last one is returned.
+//
+// For example, in-process plugins can take precedence over executables:
+//
+//	ChainPluginHandler(inProcess, &DefaultPluginHandler{})
+func ChainPluginHandler(handlers ...PluginHandler) PluginHandler {
+	return PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		err := error(ErrPluginNotFound)
+		for _, handler := range handlers {
+			var output []byte
+			output, err = handler.Communicate(ctx, program, searchPath, input)
+			if err == nil || !isPluginNotFound(program, err) {
+				return output, err
+			}
+		}
+		return nil, err
+	})
+}
+
+// generatorsHandler returns a PluginHandler serving the plugins found on
+// the search path from generators, keyed by generator name, as configured
+// by Config.Generators. Other plugins are not found.
+func generatorsHandler(generators map[string]PluginHandler) PluginHandler {
+	return PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		name, ok := strings.CutPrefix(program, "protoc-gen-")
+		if handler := generators[name]; searchPath && ok && handler != nil {
+			return handler.Communicate(ctx, program, searchPath, input)
+		}
+		return nil, ErrPluginNotFound
+	})
+}
+
+// MiddlewarePluginHandler wraps Handler with hooks called before and after
+// every plugin invocation, e.g. for logging, metrics or to modify requests
+// and responses.
+type MiddlewarePluginHandler struct {
+	// Handler handles the invocations.
+	// Default: DefaultPluginHandler.
+	Handler PluginHandler
+	// Before, if set, is called with the serialized CodeGeneratorRequest
+	// and returns the request to pass to Handler. Returning an error fails
+	// the invocation without calling Handler.
+	Before func(ctx context.Context, program string, input []byte) ([]byte, error)
+	// After, if set, is called with the result of Handler and returns the
+	// result of the invocation.
+	After func(ctx context.Context, program string, output []byte, err error) ([]byte, error)
+}
+
+// Communicate calls Before, Handler and After in turn.
+func (h *MiddlewarePluginHandler) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	handler := h.Handler
+	if handler == nil {
+		handler = &DefaultPluginHandler{}
+	}
+	if h.Before != nil {
+		var err error
+		if input, err = h.Before(ctx, program, input); err != nil {
+			return nil, err
+		}
+	}
+	output, err := handler.Communicate(ctx, program, searchPath, input)
+	if h.After != nil {
+		output, err = h.After(ctx, program, output, err)
+	}
+	return output, err
+}
@@ -0,0 +1,116 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestChainPluginHandler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin requires a POSIX shell")
+	}
+	ctx := context.Background()
+
+	// An executable plugin echoing its input.
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "protoc-gen-echo")
+	if err := os.WriteFile(plugin, []byte("#!/bin/sh\nprintf 'exec:'\ncat\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	inProcess := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		switch program {
+		case "protoc-gen-inproc":
+			return append([]byte("inproc:"), input...), nil
+		case "protoc-gen-broken":
+			return nil, errors.New("broken")
+		case "protoc-gen-reads-input":
+			return nil, &fs.PathError{Op: "open", Path: "input.txt", Err: fs.ErrNotExist}
+		}
+		return nil, ErrPluginNotFound
+	})
+	var calls []string
+	h := &MiddlewarePluginHandler{
+		Handler: ChainPluginHandler(inProcess, &DefaultPluginHandler{}),
+		Before: func(ctx context.Context, program string, input []byte) ([]byte, error) {
+			calls = append(calls, "before "+program)
+			return append(input, '!'), nil
+		},
+		After: func(ctx context.Context, program string, output []byte, err error) ([]byte, error) {
+			calls = append(calls, "after "+program)
+			return output, err
+		},
+	}
+
+	for program, expected := range map[string]string{
+		"protoc-gen-inproc": "inproc:request!",
+		plugin:              "exec:request!",
+	} {
+		output, err := h.Communicate(ctx, program, false, []byte("request"))
+		if err != nil {
+			t.Fatalf("Communicate(%s) failed: %v", program, err)
+		}
+		if string(output) != expected {
+			t.Errorf("Communicate(%s): expected %q, got %q", program, expected, output)
+		}
+	}
+	if len(calls) != 4 {
+		t.Errorf("expected hooks around both invocations, got %v", calls)
+	}
+
+	// Failures other than missing plugins end the chain.
+	if _, err := h.Communicate(ctx, "protoc-gen-broken", false, nil); err == nil || err.Error() != "broken" {
+		t.Errorf("expected error of the in-process plugin, got %v", err)
+	}
+	// So do plugins failing on a missing file other than themselves.
+	if _, err := h.Communicate(ctx, "protoc-gen-reads-input", false, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected error of the in-process plugin, got %v", err)
+	} else if errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected the chain to end at the in-process plugin, got %v", err)
+	}
+	// A missing executable at an explicit path passes on to the next handler.
+	fallback := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		return []byte("fallback"), nil
+	})
+	missing := filepath.Join(dir, "protoc-gen-missing")
+	output, err := ChainPluginHandler(&DefaultPluginHandler{}, fallback).Communicate(ctx, missing, false, nil)
+	if err != nil || string(output) != "fallback" {
+		t.Errorf("expected the next handler to provide %s, got %q, %v", missing, output, err)
+	}
+	// Plugins no handler provides report the last error.
+	_, err = h.Communicate(ctx, "protoc-gen-does-not-exist", true, nil)
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected exec.ErrNotFound, got %v", err)
+	}
+}
+
+func TestProtocGenerators(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	p := newTestProtoc(t, &Config{
+		FS:            memFS,
+		PluginHandler: fakePlugin("foo.txt", "fallback\n"),
+		Generators: map[string]PluginHandler{
+			"go": fakePlugin("foo.pb.go", "package foo\n"),
+		},
+	})
+
+	outputs, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go"}, {Name: "other"}})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if got := string(outputs["go"]["foo.pb.go"]); got != "package foo\n" {
+		t.Errorf("expected output of the configured generator, got %q", got)
+	}
+	// Other plugins go to the plugin handler.
+	if got := string(outputs["other"]["foo.txt"]); got != "fallback\n" {
+		t.Errorf("expected output of the plugin handler, got %q", got)
+	}
+}
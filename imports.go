@@ -0,0 +1,204 @@
+package protoc
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/sys"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	// descriptorSetsDir is the guest path of the read-only mount holding the
+	// descriptor sets the compile helpers pass with --descriptor_set_in.
+	descriptorSetsDir = "/.protoc-wasi-in"
+	// precompiledImportsFile is the name of the precompiled imports
+	// descriptor set in descriptorSetsDir.
+	precompiledImportsFile = "imports.pb"
+	// knownTypesFile is the descriptor set in descriptorSetsDir holding the
+	// known types of CompileWithKnownTypes or the import-only files of
+	// CompileTargets, present only while compiling.
+	knownTypesFile = "known.pb"
+	// preludeDir is the guest path of the read-only mount holding
+	// Config.PreludeFiles, the last include path of the compile helpers.
+	preludeDir = "/.protoc-wasi-prelude"
+)
+
+// WithPrecompiledImports makes the files in descSet, an encoded
+// FileDescriptorSet, available as imports to subsequent runs of the compile
+// helpers such as Compile and RunGenerators. Passing nil removes them.
+//
+// This avoids parsing a large, stable set of dependencies on every run:
+// compile them once with CompileOptions.IncludeImports and leave them out of
+// the include paths of later runs. Files found in the include paths take
+// precedence over the precompiled ones.
+func (p *Protoc) WithPrecompiledImports(descSet []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if descSet == nil {
+		p.descSets.remove(precompiledImportsFile)
+		return nil
+	}
+	if err := proto.Unmarshal(descSet, &descriptorpb.FileDescriptorSet{}); err != nil {
+		return err
+	}
+	return p.descSets.WriteFile(precompiledImportsFile, descSet)
+}
+
+// CompileWithKnownTypes compiles files like Compile, seeded with known, an
+// encoded FileDescriptorSet of already compiled types, such as one fetched
+// from server reflection. The files can import the files in known without
+// their sources. Files found in the include paths take precedence over the
+// known ones, which take precedence over precompiled imports.
+//
+// If protoc fails the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) CompileWithKnownTypes(ctx context.Context, known []byte, includePaths, files []string) ([]byte, error) {
+	if err := proto.Unmarshal(known, &descriptorpb.FileDescriptorSet{}); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.descSets.WriteFile(knownTypesFile, known); err != nil {
+		return nil, err
+	}
+	defer p.descSets.remove(knownTypesFile)
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: files}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		return nil, err
+	}
+	return res.descSet, nil
+}
+
+// CompileTargets compiles targets against importOnly, files that are only
+// available as imports: importOnly is compiled first, with its own imports,
+// and provided to protoc as descriptors while targets are compiled from
+// source, with the sources of the import-only files hidden. Only targets
+// are in the returned set, even if they import each other, so that the
+// output lists exactly the files asked for. Both lists are resolved
+// through includePaths.
+//
+// The sources can only be hidden with Config.FS or Config.Resolver; with
+// Config.FSConfig importOnly is compiled along with targets instead.
+//
+// If protoc fails, for either list, the returned error is a *CompileError.
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) CompileTargets(ctx context.Context, includePaths, targets, importOnly []string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(importOnly) != 0 && p.hiding != nil {
+		res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: importOnly, IncludeImports: true}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := res.err(); err != nil {
+			return nil, err
+		}
+		hidden, err := hiddenSources(res.descSet, includePaths, targets)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.descSets.WriteFile(knownTypesFile, res.descSet); err != nil {
+			return nil, err
+		}
+		defer p.descSets.remove(knownTypesFile)
+		p.hiding.hidden = hidden
+		defer func() { p.hiding.hidden = nil }()
+	}
+
+	res, err := p.compile(ctx, CompileOptions{IncludePaths: includePaths, Files: targets}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		return nil, err
+	}
+	return res.descSet, nil
+}
+
+// hiddenSources returns the root-relative paths of the sources of the
+// files in descSet, in each of includePaths, except for targets, so that
+// protoc resolves them from the descriptors instead.
+func hiddenSources(descSet []byte, includePaths, targets []string) (map[string]bool, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(descSet, set); err != nil {
+		return nil, err
+	}
+	if len(includePaths) == 0 {
+		includePaths = []string{"/"}
+	}
+	hidden := make(map[string]bool)
+	for _, file := range set.GetFile() {
+		if slices.Contains(targets, file.GetName()) {
+			continue
+		}
+		for _, dir := range includePaths {
+			hidden[strings.TrimPrefix(path.Join("/", dir, file.GetName()), "/")] = true
+		}
+	}
+	return hidden, nil
+}
+
+// hidingFS makes the files in hidden, root-relative paths, appear not to
+// exist, as set by CompileTargets.
+type hidingFS struct {
+	experimentalsys.FS
+	hidden map[string]bool
+}
+
+// OpenFile implements sys.FS.
+func (h *hidingFS) OpenFile(name string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	if h.hidden[path.Clean(name)] {
+		return nil, experimentalsys.ENOENT
+	}
+	return h.FS.OpenFile(name, flag, perm)
+}
+
+// Lstat implements sys.FS.
+func (h *hidingFS) Lstat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	if h.hidden[path.Clean(name)] {
+		return sys.Stat_t{}, experimentalsys.ENOENT
+	}
+	return h.FS.Lstat(name)
+}
+
+// Stat implements sys.FS.
+func (h *hidingFS) Stat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	if h.hidden[path.Clean(name)] {
+		return sys.Stat_t{}, experimentalsys.ENOENT
+	}
+	return h.FS.Stat(name)
+}
+
+// descriptorSetInArg returns the --descriptor_set_in flag providing the
+// guest paths in sets, followed by the known types of
+// CompileWithKnownTypes, any precompiled imports, stubs for missing weak
+// imports and the well-known types. Earlier sets take precedence.
+func (p *Protoc) descriptorSetInArg(sets ...string) string {
+	if p.descSets.exists(knownTypesFile) {
+		sets = append(sets, path.Join(descriptorSetsDir, knownTypesFile))
+	}
+	if p.descSets.exists(precompiledImportsFile) {
+		sets = append(sets, path.Join(descriptorSetsDir, precompiledImportsFile))
+	}
+	if p.descSets.exists(weakStubsFile) {
+		sets = append(sets, path.Join(descriptorSetsDir, weakStubsFile))
+	}
+	sets = append(sets, path.Join(descriptorSetsDir, wellKnownTypesFile))
+	return "--descriptor_set_in=" + strings.Join(sets, ":")
+}
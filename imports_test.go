@@ -0,0 +1,217 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// newImportsTestFS creates a filesystem with n dependency files under deps/
+// and a main.proto under src/ importing all of them.
+func newImportsTestFS(t testing.TB, n int) (*MemFS, []string) {
+	t.Helper()
+	files := make(map[string][]byte)
+	deps := make([]string, n)
+	var main strings.Builder
+	main.WriteString("syntax = \"proto3\";\npackage app;\n\n")
+	for i := range deps {
+		deps[i] = fmt.Sprintf("dep%d.proto", i)
+		var dep strings.Builder
+		fmt.Fprintf(&dep, "syntax = \"proto3\";\npackage dep%d;\n\n", i)
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&dep, "message M%d {\n  string name = 1;\n  int64 id = 2;\n  repeated string tags = 3;\n}\n\n", j)
+		}
+		files["deps/"+deps[i]] = []byte(dep.String())
+		fmt.Fprintf(&main, "import \"%s\";\n", deps[i])
+	}
+	main.WriteString("\nmessage Main {\n")
+	for i := range deps {
+		fmt.Fprintf(&main, "  dep%d.M0 dep%d = %d;\n", i, i, i+1)
+	}
+	main.WriteString("}\n")
+	files["src/main.proto"] = []byte(main.String())
+	memFS, err := NewWritableMapFS(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memFS, deps
+}
+
+func TestProtocWithPrecompiledImports(t *testing.T) {
+	ctx := context.Background()
+	memFS, deps := newImportsTestFS(t, 3)
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	imports, err := p.Compile(ctx, CompileOptions{IncludePaths: []string{"/deps"}, Files: deps})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	mainOpts := CompileOptions{IncludePaths: []string{"/src"}, Files: []string{"main.proto"}}
+	var compileErr *CompileError
+	if _, err := p.Compile(ctx, mainOpts); !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError without imports, got: %v", err)
+	}
+
+	if err := p.WithPrecompiledImports(imports); err != nil {
+		t.Fatalf("WithPrecompiledImports failed: %v", err)
+	}
+	if _, err := p.Compile(ctx, mainOpts); err != nil {
+		t.Fatalf("Compile with precompiled imports failed: %v", err)
+	}
+
+	if err := p.WithPrecompiledImports(nil); err != nil {
+		t.Fatalf("WithPrecompiledImports failed: %v", err)
+	}
+	if _, err := p.Compile(ctx, mainOpts); !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError after removing imports, got: %v", err)
+	}
+
+	if err := p.WithPrecompiledImports([]byte("not a descriptor set")); err == nil {
+		t.Error("expected error for invalid descriptor set")
+	}
+}
+
+func TestProtocCompileWithKnownTypes(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"src/order.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+import "remote/user.proto";
+message Order { remote.User buyer = 1; }
+`)},
+	}
+	// The type is only known from its descriptor.
+	known, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:        proto.String("remote/user.proto"),
+			Package:     proto.String("remote"),
+			Syntax:      proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("User")}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := CompileOptions{IncludePaths: []string{"/src"}, Files: []string{"order.proto"}}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.CompileWithKnownTypes(ctx, known, opts.IncludePaths, opts.Files)
+	if err != nil {
+		t.Fatalf("CompileWithKnownTypes failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.GetFile()) != 1 || set.GetFile()[0].GetMessageType()[0].GetField()[0].GetTypeName() != ".remote.User" {
+		t.Errorf("unexpected descriptor set %v", set)
+	}
+
+	// The known types are not kept for later runs.
+	var compileErr *CompileError
+	if _, err := p.Compile(ctx, opts); !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError without known types, got: %v", err)
+	}
+}
+
+func BenchmarkPrecompiledImports(b *testing.B) {
+	ctx := context.Background()
+	memFS, deps := newImportsTestFS(b, 50)
+	p := newTestProtoc(b, &Config{FS: memFS})
+
+	imports, err := p.Compile(ctx, CompileOptions{IncludePaths: []string{"/deps"}, Files: deps})
+	if err != nil {
+		b.Fatalf("Compile failed: %v", err)
+	}
+
+	b.Run("Sources", func(b *testing.B) {
+		opts := CompileOptions{IncludePaths: []string{"/src", "/deps"}, Files: []string{"main.proto"}}
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Compile(ctx, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Precompiled", func(b *testing.B) {
+		if err := p.WithPrecompiledImports(imports); err != nil {
+			b.Fatal(err)
+		}
+		defer p.WithPrecompiledImports(nil)
+
+		opts := CompileOptions{IncludePaths: []string{"/src"}, Files: []string{"main.proto"}}
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Compile(ctx, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProtocCompileTargets(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"api/a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "api/b.proto"; import "lib/c.proto"; message A { B b = 1; C c = 2; }`)},
+		"api/b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message B {}`)},
+		"lib/c.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "lib/d.proto"; message C { D d = 1; }`)},
+		"lib/d.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message D {}`)},
+		"lib/e.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message E { Missing m = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	data, err := p.CompileTargets(ctx, nil, []string{"api/a.proto", "api/b.proto"}, []string{"lib/c.proto"})
+	if err != nil {
+		t.Fatalf("CompileTargets failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	if !slices.Equal(names, []string{"api/b.proto", "api/a.proto"}) {
+		t.Errorf("expected only the targets, got %v", names)
+	}
+
+	// Import-only files must compile too.
+	var compileErr *CompileError
+	if _, err := p.CompileTargets(ctx, nil, []string{"api/b.proto"}, []string{"lib/e.proto"}); !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError for a broken import-only file, got %v", err)
+	}
+	if p.descSets.exists(knownTypesFile) {
+		t.Error("expected the import-only descriptors to be removed")
+	}
+
+	// The targets are compiled against the import-only descriptors, not
+	// their sources, which are only resolved by the first compile.
+	resolved := make(map[string]int)
+	p = newTestProtoc(t, &Config{Resolver: func(importPath string) ([]byte, bool) {
+		resolved[importPath]++
+		f, ok := memFS[importPath]
+		if !ok {
+			return nil, false
+		}
+		return f.Data, true
+	}})
+	if _, err := p.CompileTargets(ctx, nil, []string{"api/a.proto", "api/b.proto"}, []string{"lib/c.proto"}); err != nil {
+		t.Fatalf("CompileTargets failed: %v", err)
+	}
+	if resolved["lib/c.proto"] != 1 || resolved["lib/d.proto"] != 1 || resolved["api/a.proto"] != 1 {
+		t.Errorf("expected the import-only files to be resolved once, got %v", resolved)
+	}
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"lib/c.proto"}}); err != nil {
+		t.Errorf("expected the import-only sources to be visible again, got %v", err)
+	}
+}
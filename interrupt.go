@@ -0,0 +1,24 @@
+package protoc
+
+// InterruptedError is returned by Run when the context is cancelled or its
+// deadline passes during a run. Aborting a run requires a runtime created
+// with wazero.RuntimeConfig.WithCloseOnContextDone; otherwise protoc runs
+// to completion, and plugins see the cancelled context.
+type InterruptedError struct {
+	// Err is the context's error, context.Canceled or
+	// context.DeadlineExceeded.
+	Err error
+	// PartialStderr is what protoc wrote to stderr before the run was
+	// aborted, e.g. to find the file it was stuck on.
+	PartialStderr []byte
+}
+
+// Error implements error.
+func (e *InterruptedError) Error() string {
+	return "protoc run interrupted: " + e.Err.Error()
+}
+
+// Unwrap returns Err.
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
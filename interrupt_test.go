@@ -0,0 +1,58 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestProtocRunInterrupted(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "b.proto"; message A {}`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message B {}`)},
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(testCompilationCache).
+		WithCloseOnContextDone(true))
+	defer r.Close(ctx)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The plugin runs after protoc warned about the unused import.
+	stuck := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	p, err := NewProtoc(ctx, r, &Config{FS: memFS, PluginHandler: stuck})
+	if err != nil {
+		t.Fatalf("NewProtoc failed: %v", err)
+	}
+	defer p.Close(ctx)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	_, err = p.Run(runCtx, []string{"protoc", "-I/", "--foo_out=/out", "a.proto"})
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("expected InterruptedError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if !bytes.Contains(interrupted.PartialStderr, []byte("Import b.proto is unused.")) {
+		t.Errorf("expected partial stderr with the warning, got %q", interrupted.PartialStderr)
+	}
+
+	// The next run uses a fresh instance.
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"b.proto"}}); err != nil {
+		t.Fatalf("Compile after interruption failed: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package protoc
+
+import "context"
+
+// RunLimits are limits applied to a single run by RunWithLimits.
+type RunLimits struct {
+	// MaxOutputBytes limits the output of the run like
+	// Config.MaxTotalOutputBytes and also limits how much the run may grow
+	// a *MemFS passed as Config.FS. Default: the instance's limit.
+	MaxOutputBytes int
+	// MaxInstructions limits the work of the run like
+	// Config.MaxInstructions, which requires an instrumented module.
+	// Default: the instance's limit.
+	MaxInstructions uint64
+}
+
+// tighter returns the smaller of the limits a and b, where 0 is unlimited.
+func tighter[T int | int64 | uint64](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// RunWithLimits runs protoc with the given arguments like Run, applying
+// limits to this run only, so that servers can give risky requests a
+// tighter budget without reconfiguring the instance. Limits can only
+// tighten those of the instance. A run over a limit fails early with
+// ErrOutputTooLarge or ErrBudgetExceeded.
+//
+// Init() must be called first.
+func (p *Protoc) RunWithLimits(ctx context.Context, args []string, limits RunLimits) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	maxOutputBytes, scratchLimit, maxInstructions := p.maxOutputBytes, p.scratch.limit, p.maxInstructions
+	defer func() {
+		p.maxOutputBytes, p.scratch.limit, p.maxInstructions = maxOutputBytes, scratchLimit, maxInstructions
+	}()
+	p.maxOutputBytes = tighter(p.maxOutputBytes, limits.MaxOutputBytes)
+	p.scratch.limit = tighter(p.scratch.limit, int64(limits.MaxOutputBytes))
+	p.maxInstructions = tighter(p.maxInstructions, limits.MaxInstructions)
+	if p.rootFS != nil && limits.MaxOutputBytes > 0 {
+		defer p.rootFS.limitGrowth(int64(limits.MaxOutputBytes))()
+	}
+
+	return p.run(ctx, args)
+}
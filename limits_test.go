@@ -0,0 +1,52 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProtocRunWithLimits(t *testing.T) {
+	ctx := context.Background()
+	memFS, err := NewWritableMapFS(map[string][]byte{
+		"foo.proto": []byte(`syntax = "proto3"; message Foo { string name = 1; int64 id = 2; }`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memFS.MkdirAll("out"); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"protoc", "--cpp_out=/out", "-I/", "foo.proto"}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// The generated C++ code is far larger than the limit.
+	_, err = p.RunWithLimits(ctx, args, RunLimits{MaxOutputBytes: 1024})
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Fatalf("expected ErrOutputTooLarge, got %v", err)
+	}
+
+	// The limit only applies to that run.
+	exitCode, err := p.Run(ctx, args)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("Run failed: %d, %v", exitCode, err)
+	}
+	if _, err := memFS.ReadFile("out/foo.pb.cc"); err != nil {
+		t.Errorf("expected generated file: %v", err)
+	}
+	exitCode, err = p.RunWithLimits(ctx, args, RunLimits{MaxOutputBytes: 1 << 20})
+	if err != nil || exitCode != 0 {
+		t.Errorf("RunWithLimits failed: %d, %v", exitCode, err)
+	}
+
+	// Instruction limits require an instrumented module and are checked
+	// before running.
+	memFS.remove("out/foo.pb.cc")
+	if _, err := p.RunWithLimits(ctx, args, RunLimits{MaxInstructions: 1000}); !errors.Is(err, ErrNoInstructionCounting) {
+		t.Errorf("expected ErrNoInstructionCounting, got %v", err)
+	}
+	if _, err := memFS.ReadFile("out/foo.pb.cc"); err == nil {
+		t.Error("expected no output from the rejected run")
+	}
+}
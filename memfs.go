@@ -0,0 +1,842 @@
+package protoc
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// MemFS is a writable in-memory filesystem.
+//
+// It implements fs.FS for reading from Go. Passed as Config.FS, it is
+// mounted writable so protoc can write generated files into it. Directories
+// are created implicitly by WriteFile.
+type MemFS struct {
+	mu      sync.RWMutex
+	root    *memNode
+	nextIno uint64
+
+	// size is the total size of all regular files.
+	size int64
+	// limit is the maximum size guest writes may grow the filesystem to.
+	// Zero means unlimited.
+	limit int64
+	// exceeded is set when a guest write was rejected because of limit.
+	exceeded bool
+	// fixedModTime, if set, is reported as the modification time of every
+	// file and directory.
+	fixedModTime time.Time
+}
+
+// memNode is a file or directory in a MemFS.
+type memNode struct {
+	ino      uint64
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memNode
+}
+
+// NewWritableMapFS creates a MemFS holding files, keyed by slash-separated
+// path. Intermediate directories are created automatically. It returns an
+// error if a path is invalid or both a file and the parent of another file.
+func NewWritableMapFS(files map[string][]byte) (*MemFS, error) {
+	m := newMemFS()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := m.WriteFile(name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// newMemFS creates an empty MemFS.
+func newMemFS() *MemFS {
+	m := &MemFS{}
+	m.root = m.newNode(fs.ModeDir | 0o755)
+	return m
+}
+
+// newNode allocates a node with a fresh inode number.
+// The caller must hold m.mu or own m exclusively.
+func (m *MemFS) newNode(mode fs.FileMode) *memNode {
+	m.nextIno++
+	n := &memNode{ino: m.nextIno, mode: mode, modTime: time.Now()}
+	if mode.IsDir() {
+		n.children = make(map[string]*memNode)
+	}
+	return n
+}
+
+// splitMemPath cleans name and splits it into path elements.
+// The root is represented by an empty slice.
+func splitMemPath(name string) []string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil
+	}
+	return strings.Split(name[1:], "/")
+}
+
+// lookup finds the node at name. The caller must hold m.mu.
+func (m *MemFS) lookup(name string) (*memNode, experimentalsys.Errno) {
+	n := m.root
+	for _, elem := range splitMemPath(name) {
+		if !n.mode.IsDir() {
+			return nil, experimentalsys.ENOTDIR
+		}
+		child, ok := n.children[elem]
+		if !ok {
+			return nil, experimentalsys.ENOENT
+		}
+		n = child
+	}
+	return n, 0
+}
+
+// lookupParent finds the directory containing name and returns it along with
+// the base name. The caller must hold m.mu.
+func (m *MemFS) lookupParent(name string) (*memNode, string, experimentalsys.Errno) {
+	elems := splitMemPath(name)
+	if len(elems) == 0 {
+		return nil, "", experimentalsys.EINVAL
+	}
+	parent, errno := m.lookup(strings.Join(elems[:len(elems)-1], "/"))
+	if errno != 0 {
+		return nil, "", errno
+	}
+	if !parent.mode.IsDir() {
+		return nil, "", experimentalsys.ENOTDIR
+	}
+	return parent, elems[len(elems)-1], 0
+}
+
+// exists reports whether a file or directory exists at name.
+func (m *MemFS) exists(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, errno := m.lookup(name)
+	return errno == 0
+}
+
+// MkdirAll creates the directory at name along with any missing parents.
+func (m *MemFS) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.mkdirAllLocked(splitMemPath(name))
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// mkdirAllLocked creates the directory at elems along with any missing
+// parents and returns it. The caller must hold m.mu.
+func (m *MemFS) mkdirAllLocked(elems []string) (*memNode, error) {
+	dir := m.root
+	for _, elem := range elems {
+		child, ok := dir.children[elem]
+		if !ok {
+			child = m.newNode(fs.ModeDir | 0o755)
+			dir.children[elem] = child
+		}
+		if !child.mode.IsDir() {
+			return nil, experimentalsys.ENOTDIR
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+// WriteFile creates or replaces the file at name, creating any missing
+// parent directories.
+func (m *MemFS) WriteFile(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elems := splitMemPath(name)
+	if len(elems) == 0 {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := m.mkdirAllLocked(elems[:len(elems)-1])
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	base := elems[len(elems)-1]
+	if existing, ok := dir.children[base]; ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: experimentalsys.EISDIR}
+	}
+	if existing, ok := dir.children[base]; ok {
+		m.size -= int64(len(existing.data))
+	}
+	n := m.newNode(0o644)
+	n.data = append([]byte(nil), data...)
+	dir.children[base] = n
+	m.size += int64(len(n.data))
+	return nil
+}
+
+// remove removes the regular file at name, if present.
+func (m *MemFS) remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, base, errno := m.lookupParent(name)
+	if errno != 0 {
+		return
+	}
+	if n, ok := parent.children[base]; ok && n.mode.IsRegular() {
+		m.size -= int64(len(n.data))
+		delete(parent.children, base)
+	}
+}
+
+// take removes the regular file at name and returns its contents without
+// copying them.
+func (m *MemFS) take(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, base, errno := m.lookupParent(name)
+	if errno != 0 {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	n, ok := parent.children[base]
+	if !ok || !n.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	m.size -= int64(len(n.data))
+	delete(parent.children, base)
+	return n.data, nil
+}
+
+// Clear removes every file and directory, so that the filesystem can be
+// reused across runs, for example as the output of batch jobs. Generated
+// files must be collected before clearing, and files protoc reads must be
+// written again afterwards. Clear must not be called during a run.
+func (m *MemFS) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.root.children = make(map[string]*memNode)
+	m.size = 0
+	m.exceeded = false
+}
+
+// resetExceeded clears the flag recording that the limit was exceeded.
+func (m *MemFS) resetExceeded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exceeded = false
+}
+
+// limitExceeded reports whether a guest write was rejected because of the
+// limit since the last call to resetExceeded.
+func (m *MemFS) limitExceeded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.exceeded
+}
+
+// limitGrowth limits how much guest writes may grow the filesystem from
+// its current size to n bytes, in addition to its limit, until the
+// returned function restores the previous limit.
+func (m *MemFS) limitGrowth(n int64) (restore func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit := m.limit
+	m.limit = tighter(limit, m.size+n)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.limit = limit
+	}
+}
+
+// resize changes the size of the file n to size, enforcing the limit.
+// The caller must hold m.mu.
+func (m *MemFS) resize(n *memNode, size int64) experimentalsys.Errno {
+	delta := size - int64(len(n.data))
+	if m.limit > 0 && delta > 0 && m.size+delta > m.limit {
+		m.exceeded = true
+		return experimentalsys.EIO
+	}
+	if size <= int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, delta)...)
+	}
+	m.size += delta
+	n.modTime = time.Now()
+	return 0
+}
+
+// files returns the contents of every regular file under dir keyed by
+// slash-separated path relative to dir.
+func (m *MemFS) files(dir string) map[string][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string][]byte)
+	root, errno := m.lookup(dir)
+	if errno != 0 || !root.mode.IsDir() {
+		return out
+	}
+	var walk func(prefix string, n *memNode)
+	walk = func(prefix string, n *memNode) {
+		for name, child := range n.children {
+			p := path.Join(prefix, name)
+			if child.mode.IsDir() {
+				walk(p, child)
+				continue
+			}
+			out[p] = append([]byte(nil), child.data...)
+		}
+	}
+	walk("", root)
+	return out
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, errno := m.lookup(name)
+	if errno != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f := &memFile{info: m.fileInfo(n, path.Base(name))}
+	if n.mode.IsDir() {
+		for _, child := range n.sortedChildren() {
+			f.entries = append(f.entries, fs.FileInfoToDirEntry(m.fileInfo(n.children[child], child)))
+		}
+	} else {
+		f.r = strings.NewReader(string(n.data))
+	}
+	return f, nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, errno := m.lookup(name)
+	if errno != 0 {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: experimentalsys.EISDIR}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// sysFS returns the view of m mounted into the guest.
+func (m *MemFS) sysFS() experimentalsys.FS {
+	return &memSysFS{m: m}
+}
+
+// sortedChildren returns the names of the children of a directory node.
+func (n *memNode) sortedChildren() []string {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setFixedModTime sets the modification time reported for every node.
+func (m *MemFS) setFixedModTime(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fixedModTime = t
+}
+
+// modTime returns the modification time reported for n.
+func (m *MemFS) modTime(n *memNode) time.Time {
+	if !m.fixedModTime.IsZero() {
+		return m.fixedModTime
+	}
+	return n.modTime
+}
+
+// fileInfo returns a snapshot of the node metadata.
+func (m *MemFS) fileInfo(n *memNode, name string) *memFileInfo {
+	return &memFileInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: m.modTime(n)}
+}
+
+// stat returns the node metadata in the form wazero expects.
+func (m *MemFS) stat(n *memNode) sys.Stat_t {
+	t := m.modTime(n).UnixNano()
+	return sys.Stat_t{
+		Ino:   n.ino,
+		Mode:  n.mode,
+		Nlink: 1,
+		Size:  int64(len(n.data)),
+		Atim:  t,
+		Mtim:  t,
+		Ctim:  t,
+	}
+}
+
+// memFileInfo implements fs.FileInfo for MemFS nodes.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() any           { return nil }
+
+// memFile implements fs.File and fs.ReadDirFile for MemFS.
+type memFile struct {
+	info    *memFileInfo
+	r       *strings.Reader
+	entries []fs.DirEntry
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: experimentalsys.EISDIR}
+	}
+	return f.r.Read(b)
+}
+
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.r != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: experimentalsys.ENOTDIR}
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+// memSysFS adapts MemFS to the wazero experimental sys.FS interface.
+type memSysFS struct {
+	experimentalsys.UnimplementedFS
+	m *MemFS
+}
+
+// OpenFile implements sys.FS.
+func (s *memSysFS) OpenFile(name string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	n, errno := s.m.lookup(name)
+	switch {
+	case errno == experimentalsys.ENOENT && flag&experimentalsys.O_CREAT != 0:
+		parent, base, errno := s.m.lookupParent(name)
+		if errno != 0 {
+			return nil, errno
+		}
+		n = s.m.newNode(perm.Perm())
+		parent.children[base] = n
+	case errno != 0:
+		return nil, errno
+	case flag&experimentalsys.O_CREAT != 0 && flag&experimentalsys.O_EXCL != 0:
+		return nil, experimentalsys.EEXIST
+	}
+
+	writable := flag&(experimentalsys.O_RDWR|experimentalsys.O_WRONLY) != 0
+	if n.mode.IsDir() && writable {
+		return nil, experimentalsys.EISDIR
+	}
+	if !n.mode.IsDir() && flag&experimentalsys.O_DIRECTORY != 0 {
+		return nil, experimentalsys.ENOTDIR
+	}
+	if !n.mode.IsDir() && writable && flag&experimentalsys.O_TRUNC != 0 {
+		s.m.resize(n, 0)
+	}
+	return &memSysFile{m: s.m, n: n, flag: flag}, 0
+}
+
+// Lstat implements sys.FS.
+func (s *memSysFS) Lstat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	return s.Stat(name)
+}
+
+// Stat implements sys.FS.
+func (s *memSysFS) Stat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	n, errno := s.m.lookup(name)
+	if errno != 0 {
+		return sys.Stat_t{}, errno
+	}
+	return s.m.stat(n), 0
+}
+
+// Mkdir implements sys.FS.
+func (s *memSysFS) Mkdir(name string, perm fs.FileMode) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	parent, base, errno := s.m.lookupParent(name)
+	if errno != 0 {
+		return errno
+	}
+	if _, ok := parent.children[base]; ok {
+		return experimentalsys.EEXIST
+	}
+	parent.children[base] = s.m.newNode(fs.ModeDir | perm.Perm())
+	return 0
+}
+
+// Chmod implements sys.FS.
+func (s *memSysFS) Chmod(name string, perm fs.FileMode) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	n, errno := s.m.lookup(name)
+	if errno != 0 {
+		return errno
+	}
+	n.mode = n.mode.Type() | perm.Perm()
+	return 0
+}
+
+// Rename implements sys.FS.
+func (s *memSysFS) Rename(from, to string) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	fromParent, fromBase, errno := s.m.lookupParent(from)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := fromParent.children[fromBase]
+	if !ok {
+		return experimentalsys.ENOENT
+	}
+	toParent, toBase, errno := s.m.lookupParent(to)
+	if errno != 0 {
+		return errno
+	}
+	if existing, ok := toParent.children[toBase]; ok && existing != n {
+		switch {
+		case existing.mode.IsDir() && !n.mode.IsDir():
+			return experimentalsys.EISDIR
+		case !existing.mode.IsDir() && n.mode.IsDir():
+			return experimentalsys.ENOTDIR
+		case existing.mode.IsDir() && len(existing.children) != 0:
+			return experimentalsys.ENOTEMPTY
+		}
+		s.m.size -= int64(len(existing.data))
+	}
+	delete(fromParent.children, fromBase)
+	toParent.children[toBase] = n
+	return 0
+}
+
+// Rmdir implements sys.FS.
+func (s *memSysFS) Rmdir(name string) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	parent, base, errno := s.m.lookupParent(name)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := parent.children[base]
+	switch {
+	case !ok:
+		return experimentalsys.ENOENT
+	case !n.mode.IsDir():
+		return experimentalsys.ENOTDIR
+	case len(n.children) != 0:
+		return experimentalsys.ENOTEMPTY
+	}
+	delete(parent.children, base)
+	return 0
+}
+
+// Unlink implements sys.FS.
+func (s *memSysFS) Unlink(name string) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	parent, base, errno := s.m.lookupParent(name)
+	if errno != 0 {
+		return errno
+	}
+	n, ok := parent.children[base]
+	switch {
+	case !ok:
+		return experimentalsys.ENOENT
+	case n.mode.IsDir():
+		return experimentalsys.EISDIR
+	}
+	delete(parent.children, base)
+	s.m.size -= int64(len(n.data))
+	return 0
+}
+
+// Utimens implements sys.FS.
+func (s *memSysFS) Utimens(name string, atim, mtim int64) experimentalsys.Errno {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	n, errno := s.m.lookup(name)
+	if errno != 0 {
+		return errno
+	}
+	n.setModTime(mtim)
+	return 0
+}
+
+// setModTime updates the modification time unless mtim is UTIME_OMIT.
+func (n *memNode) setModTime(mtim int64) {
+	if mtim != experimentalsys.UTIME_OMIT {
+		n.modTime = time.Unix(0, mtim)
+	}
+}
+
+// memSysFile is an open MemFS node as seen by the guest.
+type memSysFile struct {
+	experimentalsys.UnimplementedFile
+	m      *MemFS
+	n      *memNode
+	flag   experimentalsys.Oflag
+	offset int64
+	dirPos int
+	closed bool
+}
+
+// Ino implements sys.File.
+func (f *memSysFile) Ino() (sys.Inode, experimentalsys.Errno) {
+	return f.n.ino, 0
+}
+
+// IsDir implements sys.File.
+func (f *memSysFile) IsDir() (bool, experimentalsys.Errno) {
+	return f.n.mode.IsDir(), 0
+}
+
+// IsAppend implements sys.File.
+func (f *memSysFile) IsAppend() bool {
+	return f.flag&experimentalsys.O_APPEND != 0
+}
+
+// SetAppend implements sys.File.
+func (f *memSysFile) SetAppend(enable bool) experimentalsys.Errno {
+	if enable {
+		f.flag |= experimentalsys.O_APPEND
+	} else {
+		f.flag &^= experimentalsys.O_APPEND
+	}
+	return 0
+}
+
+// Stat implements sys.File.
+func (f *memSysFile) Stat() (sys.Stat_t, experimentalsys.Errno) {
+	if f.closed {
+		return sys.Stat_t{}, experimentalsys.EBADF
+	}
+	f.m.mu.RLock()
+	defer f.m.mu.RUnlock()
+	return f.m.stat(f.n), 0
+}
+
+// Read implements sys.File.
+func (f *memSysFile) Read(buf []byte) (int, experimentalsys.Errno) {
+	n, errno := f.Pread(buf, f.offset)
+	f.offset += int64(n)
+	return n, errno
+}
+
+// Pread implements sys.File.
+func (f *memSysFile) Pread(buf []byte, off int64) (int, experimentalsys.Errno) {
+	switch {
+	case f.closed || f.flag&experimentalsys.O_WRONLY != 0:
+		return 0, experimentalsys.EBADF
+	case f.n.mode.IsDir():
+		return 0, experimentalsys.EISDIR
+	case off < 0:
+		return 0, experimentalsys.EINVAL
+	}
+	f.m.mu.RLock()
+	defer f.m.mu.RUnlock()
+	if off >= int64(len(f.n.data)) {
+		return 0, 0
+	}
+	return copy(buf, f.n.data[off:]), 0
+}
+
+// seekOffset is an alias of int64 so that go vet does not mistake Seek for a
+// malformed io.Seeker implementation.
+type seekOffset = int64
+
+// Seek implements sys.File.
+func (f *memSysFile) Seek(offset seekOffset, whence int) (int64, experimentalsys.Errno) {
+	if f.closed {
+		return 0, experimentalsys.EBADF
+	}
+	if f.n.mode.IsDir() {
+		if offset != 0 || whence != io.SeekStart {
+			return 0, experimentalsys.EINVAL
+		}
+		f.dirPos = 0
+		return 0, 0
+	}
+
+	f.m.mu.RLock()
+	size := int64(len(f.n.data))
+	f.m.mu.RUnlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, experimentalsys.EINVAL
+	}
+	if offset < 0 {
+		return 0, experimentalsys.EINVAL
+	}
+	f.offset = offset
+	return offset, 0
+}
+
+// Readdir implements sys.File.
+func (f *memSysFile) Readdir(n int) ([]experimentalsys.Dirent, experimentalsys.Errno) {
+	if f.closed || !f.n.mode.IsDir() {
+		return nil, experimentalsys.EBADF
+	}
+	f.m.mu.RLock()
+	defer f.m.mu.RUnlock()
+
+	names := f.n.sortedChildren()
+	if f.dirPos >= len(names) {
+		return nil, 0
+	}
+	names = names[f.dirPos:]
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	dirents := make([]experimentalsys.Dirent, len(names))
+	for i, name := range names {
+		child := f.n.children[name]
+		dirents[i] = experimentalsys.Dirent{Ino: child.ino, Name: name, Type: child.mode.Type()}
+	}
+	f.dirPos += len(names)
+	return dirents, 0
+}
+
+// Write implements sys.File.
+func (f *memSysFile) Write(buf []byte) (int, experimentalsys.Errno) {
+	if f.IsAppend() {
+		f.m.mu.RLock()
+		f.offset = int64(len(f.n.data))
+		f.m.mu.RUnlock()
+	}
+	n, errno := f.Pwrite(buf, f.offset)
+	f.offset += int64(n)
+	return n, errno
+}
+
+// Pwrite implements sys.File.
+func (f *memSysFile) Pwrite(buf []byte, off int64) (int, experimentalsys.Errno) {
+	switch {
+	case f.closed || f.flag&(experimentalsys.O_RDWR|experimentalsys.O_WRONLY) == 0:
+		return 0, experimentalsys.EBADF
+	case f.n.mode.IsDir():
+		return 0, experimentalsys.EISDIR
+	case off < 0:
+		return 0, experimentalsys.EINVAL
+	}
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if end := off + int64(len(buf)); end > int64(len(f.n.data)) {
+		if errno := f.m.resize(f.n, end); errno != 0 {
+			return 0, errno
+		}
+	}
+	copy(f.n.data[off:], buf)
+	f.n.modTime = time.Now()
+	return len(buf), 0
+}
+
+// Truncate implements sys.File.
+func (f *memSysFile) Truncate(size int64) experimentalsys.Errno {
+	switch {
+	case f.closed:
+		return experimentalsys.EBADF
+	case f.n.mode.IsDir():
+		return experimentalsys.EISDIR
+	case size < 0:
+		return experimentalsys.EINVAL
+	}
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	return f.m.resize(f.n, size)
+}
+
+// Sync implements sys.File.
+func (f *memSysFile) Sync() experimentalsys.Errno {
+	return 0
+}
+
+// Datasync implements sys.File.
+func (f *memSysFile) Datasync() experimentalsys.Errno {
+	return 0
+}
+
+// Utimens implements sys.File.
+func (f *memSysFile) Utimens(atim, mtim int64) experimentalsys.Errno {
+	if f.closed {
+		return experimentalsys.EBADF
+	}
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	f.n.setModTime(mtim)
+	return 0
+}
+
+// Close implements sys.File.
+func (f *memSysFile) Close() experimentalsys.Errno {
+	f.closed = true
+	return 0
+}
@@ -0,0 +1,169 @@
+package protoc
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestNewWritableMapFS(t *testing.T) {
+	ctx := context.Background()
+	memFS, err := NewWritableMapFS(map[string][]byte{
+		"a/b/c.proto": []byte(`
+syntax = "proto3";
+package a.b;
+
+message C {
+  string name = 1;
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Intermediate directories exist without being listed.
+	for _, dir := range []string{"a", "a/b"} {
+		info, err := fs.Stat(memFS, dir)
+		if err != nil || !info.IsDir() {
+			t.Fatalf("expected directory %s: %v", dir, err)
+		}
+	}
+
+	if err := memFS.MkdirAll("out"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// protoc writes its output directly into the filesystem.
+	exitCode, err := p.Run(ctx, []string{
+		"protoc",
+		"--descriptor_set_out=/out/c.pb",
+		"-I/",
+		"a/b/c.proto",
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("protoc exited with code %d", exitCode)
+	}
+
+	data, err := memFS.ReadFile("out/c.pb")
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		t.Fatalf("invalid descriptor set: %v", err)
+	}
+	if name := set.GetFile()[0].GetName(); name != "a/b/c.proto" {
+		t.Errorf("expected a/b/c.proto, got %q", name)
+	}
+
+	// A path cannot be both a file and a directory.
+	if _, err := NewWritableMapFS(map[string][]byte{"a": nil, "a/b.proto": nil}); err == nil {
+		t.Error("expected an error for a file that is also a directory")
+	}
+}
+
+func TestMemFSClear(t *testing.T) {
+	ctx := context.Background()
+	memFS, err := NewWritableMapFS(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// Each job writes its input, generates into out/ and collects the
+	// output before the filesystem is cleared for the next job.
+	var outputs []map[string][]byte
+	for _, name := range []string{"first", "second"} {
+		memFS.Clear()
+		src := []byte(`syntax = "proto3"; message ` + name + ` {}`)
+		if err := memFS.WriteFile(name+".proto", src); err != nil {
+			t.Fatal(err)
+		}
+		if err := memFS.MkdirAll("out"); err != nil {
+			t.Fatal(err)
+		}
+		exitCode, err := p.Run(ctx, []string{"protoc", "--python_out=/out", "-I/", name + ".proto"})
+		if err != nil || exitCode != 0 {
+			t.Fatalf("job %s failed with code %d: %v", name, exitCode, err)
+		}
+		outputs = append(outputs, memFS.files("out"))
+	}
+
+	for i, name := range []string{"first", "second"} {
+		expected := []string{name + "_pb2.py"}
+		if got := slices.Sorted(maps.Keys(outputs[i])); !slices.Equal(got, expected) {
+			t.Errorf("job %s: expected %v, got %v", name, expected, got)
+		}
+	}
+	if _, err := memFS.ReadFile("first.proto"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected first.proto to be cleared, got %v", err)
+	}
+}
+
+func TestProtocFixedModTime(t *testing.T) {
+	ctx := context.Background()
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	memFS, err := NewWritableMapFS(map[string][]byte{
+		"test.proto": []byte(`syntax = "proto3"; package test; message Person { string name = 1; }`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memFS.MkdirAll("out"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS, FixedModTime: modTime})
+
+	exitCode, err := p.Run(ctx, []string{"protoc", "--cpp_out=/out", "-I/", "test.proto"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("protoc exited with code %d", exitCode)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.AddFS(memFS); err != nil {
+		t.Fatalf("AddFS failed: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(modTime) {
+			t.Errorf("%s: expected mtime %v, got %v", hdr.Name, modTime, hdr.ModTime)
+		}
+	}
+	if !slices.Contains(names, "out/test.pb.h") {
+		t.Errorf("expected generated header in archive, got %v", names)
+	}
+}
@@ -0,0 +1,97 @@
+package protoc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// ErrNoCustomSections is returned by ModuleMetadata if the compiled module
+// has no custom sections.
+var ErrNoCustomSections = errors.New("module has no custom sections")
+
+// wasmHeader is the magic number and version of a WebAssembly binary.
+var wasmHeader = []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+// ModuleMetadata returns the custom sections of compiled keyed by name, such
+// as version or build metadata embedded in the protoc build. If a name
+// repeats, the last section wins.
+//
+// wazero drops custom sections if the runtime was created with debug info
+// disabled, unless WithCustomSections(true) is also set; ErrNoCustomSections
+// is returned in that case. Use ParseModuleMetadata to read the sections from
+// the binary instead.
+func ModuleMetadata(compiled wazero.CompiledModule) (map[string][]byte, error) {
+	sections := compiled.CustomSections()
+	if len(sections) == 0 {
+		return nil, ErrNoCustomSections
+	}
+	metadata := make(map[string][]byte, len(sections))
+	for _, section := range sections {
+		metadata[section.Name()] = section.Data()
+	}
+	return metadata, nil
+}
+
+// ParseModuleMetadata returns the custom sections of the WebAssembly binary
+// wasm, such as ProtocWASM, keyed by name. If a name repeats, the last
+// section wins.
+func ParseModuleMetadata(wasm []byte) (map[string][]byte, error) {
+	if !bytes.HasPrefix(wasm, wasmHeader) {
+		return nil, errors.New("invalid wasm header")
+	}
+
+	metadata := make(map[string][]byte)
+	rest := wasm[len(wasmHeader):]
+	for len(rest) > 0 {
+		id := rest[0]
+		size, n := binary.Uvarint(rest[1:])
+		if n <= 0 || size > uint64(len(rest)-1-n) {
+			return nil, errors.New("invalid wasm section")
+		}
+		section := rest[1+n : 1+n+int(size)]
+		rest = rest[1+n+int(size):]
+		if id != 0 {
+			continue
+		}
+
+		nameLen, n := binary.Uvarint(section)
+		if n <= 0 || nameLen > uint64(len(section)-n) {
+			return nil, errors.New("invalid wasm custom section name")
+		}
+		name := string(section[n : n+int(nameLen)])
+		metadata[name] = section[n+int(nameLen):]
+	}
+	return metadata, nil
+}
+
+// ModuleInfo describes the build characteristics of a WASM module.
+type ModuleInfo struct {
+	// Size is the size of the binary in bytes.
+	Size int
+	// DebugInfo is set if the module has a "name" section or DWARF
+	// ".debug_*" sections, which wazero uses for readable stack traces.
+	DebugInfo bool
+	// CustomSections are the names of the custom sections, sorted.
+	CustomSections []string
+}
+
+// EmbeddedModuleInfo returns the build characteristics of ProtocWASM, for
+// example to decide whether enabling wazero's debug features is useful.
+func EmbeddedModuleInfo() ModuleInfo {
+	info := ModuleInfo{Size: len(ProtocWASM)}
+	// The embedded binary is always valid.
+	metadata, _ := ParseModuleMetadata(ProtocWASM)
+	for name := range metadata {
+		info.CustomSections = append(info.CustomSections, name)
+		if name == "name" || strings.HasPrefix(name, ".debug_") {
+			info.DebugInfo = true
+		}
+	}
+	sort.Strings(info.CustomSections)
+	return info
+}
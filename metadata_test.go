@@ -0,0 +1,72 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestModuleMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	parsed, err := ParseModuleMetadata(ProtocWASM)
+	if err != nil {
+		t.Fatalf("ParseModuleMetadata failed: %v", err)
+	}
+	// wasm-ld records the enabled WebAssembly features of the build.
+	features, ok := parsed["target_features"]
+	if !ok || len(features) == 0 {
+		t.Fatalf("expected target_features section, got sections: %v", keys(parsed))
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCustomSections(true))
+	defer r.Close(ctx)
+	compiled, err := CompileProtoc(ctx, r)
+	if err != nil {
+		t.Fatalf("CompileProtoc failed: %v", err)
+	}
+	metadata, err := ModuleMetadata(compiled)
+	if err != nil {
+		t.Fatalf("ModuleMetadata failed: %v", err)
+	}
+	if !bytes.Equal(metadata["target_features"], features) {
+		t.Errorf("ModuleMetadata and ParseModuleMetadata disagree on target_features")
+	}
+
+	// Without debug info or WithCustomSections the sections are not retained.
+	r2 := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithDebugInfoEnabled(false))
+	defer r2.Close(ctx)
+	compiled, err = CompileProtoc(ctx, r2)
+	if err != nil {
+		t.Fatalf("CompileProtoc failed: %v", err)
+	}
+	if _, err := ModuleMetadata(compiled); !errors.Is(err, ErrNoCustomSections) {
+		t.Errorf("expected ErrNoCustomSections, got: %v", err)
+	}
+
+	if _, err := ParseModuleMetadata([]byte("not wasm")); err == nil {
+		t.Error("expected error for invalid wasm")
+	}
+}
+
+func TestEmbeddedModuleInfo(t *testing.T) {
+	info := EmbeddedModuleInfo()
+	if info.Size != len(ProtocWASM) || info.Size == 0 {
+		t.Errorf("expected size %d, got %d", len(ProtocWASM), info.Size)
+	}
+	hasDebug := slices.ContainsFunc(info.CustomSections, func(name string) bool {
+		return name == "name" || strings.HasPrefix(name, ".debug_")
+	})
+	if info.DebugInfo != hasDebug {
+		t.Errorf("expected DebugInfo %v for sections %v", hasDebug, info.CustomSections)
+	}
+	if !slices.Contains(info.CustomSections, "target_features") {
+		t.Errorf("expected target_features section, got %v", info.CustomSections)
+	}
+	t.Logf("embedded module: %d bytes, debug info %v", info.Size, info.DebugInfo)
+}
@@ -0,0 +1,330 @@
+package protoc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseImports scans the text of a .proto file and returns the paths of its
+// import statements, including public and weak imports, in declaration
+// order. It does not run protoc and does not resolve or validate the
+// imported files, which makes it suitable for quickly building a dependency
+// index. Comments are skipped and string escapes are decoded.
+func ParseImports(content []byte) ([]string, error) {
+	var imports []string
+	err := scanTopLevel(content, func(s *protoScanner, keyword string) (bool, error) {
+		if keyword != "import" {
+			return false, nil
+		}
+		imp, err := s.importPath()
+		if err != nil {
+			return false, err
+		}
+		imports = append(imports, imp)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// ParsePackage scans the text of a .proto file and returns the name of its
+// package declaration, e.g. "example.v1", without running protoc. It
+// returns "" if the file does not declare a package.
+func ParsePackage(content []byte) (string, error) {
+	var pkg string
+	err := scanTopLevel(content, func(s *protoScanner, keyword string) (bool, error) {
+		if keyword != "package" {
+			return false, nil
+		}
+		var err error
+		pkg, err = s.packageName()
+		return err == nil, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return pkg, nil
+}
+
+// scanTopLevel calls fn with the first identifier of every top-level
+// statement of content, such as "import" or "message". If fn handles the
+// statement it must consume it including the terminating semicolon and
+// return true. Scanning stops at the first error.
+func scanTopLevel(content []byte, fn func(s *protoScanner, keyword string) (bool, error)) error {
+	s := &protoScanner{src: string(content), line: 1}
+	depth := 0
+	stmtStart := true
+	for {
+		tok, err := s.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == tokenEOF:
+			return nil
+		case tok.text == "{":
+			depth++
+			stmtStart = true
+			continue
+		case tok.text == "}":
+			if depth > 0 {
+				depth--
+			}
+			stmtStart = true
+			continue
+		case tok.text == ";":
+			stmtStart = true
+			continue
+		case depth == 0 && stmtStart && tok.kind == tokenIdent:
+			handled, err := fn(s, tok.text)
+			if err != nil {
+				return err
+			}
+			if handled {
+				stmtStart = true
+				continue
+			}
+		}
+		stmtStart = false
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenSymbol
+)
+
+type protoToken struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// protoScanner splits .proto source into identifiers, strings and symbols.
+type protoScanner struct {
+	src  string
+	pos  int
+	line int
+}
+
+// importPath parses the remainder of an import statement after the import
+// keyword: an optional public or weak modifier, the path and a semicolon.
+func (s *protoScanner) importPath() (string, error) {
+	tok, err := s.next()
+	if err != nil {
+		return "", err
+	}
+	if tok.kind == tokenIdent && (tok.text == "public" || tok.text == "weak") {
+		if tok, err = s.next(); err != nil {
+			return "", err
+		}
+	}
+	if tok.kind != tokenString {
+		return "", s.errorf(tok.line, "expected import path")
+	}
+	// Adjacent string literals are concatenated.
+	var sb strings.Builder
+	for tok.kind == tokenString {
+		sb.WriteString(tok.text)
+		if tok, err = s.next(); err != nil {
+			return "", err
+		}
+	}
+	if tok.text != ";" {
+		return "", s.errorf(tok.line, "expected \";\" after import path")
+	}
+	return sb.String(), nil
+}
+
+// packageName parses the remainder of a package statement after the package
+// keyword: a dotted name and a semicolon.
+func (s *protoScanner) packageName() (string, error) {
+	var sb strings.Builder
+	for {
+		tok, err := s.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case tok.kind == tokenIdent || tok.text == ".":
+			sb.WriteString(tok.text)
+		case tok.text == ";" && sb.Len() != 0:
+			return sb.String(), nil
+		default:
+			return "", s.errorf(tok.line, "expected package name")
+		}
+	}
+}
+
+func (s *protoScanner) next() (protoToken, error) {
+	if err := s.skipSpace(); err != nil {
+		return protoToken{}, err
+	}
+	if s.pos >= len(s.src) {
+		return protoToken{kind: tokenEOF, line: s.line}, nil
+	}
+	start, line := s.pos, s.line
+	c := s.src[s.pos]
+	switch {
+	case c == '"' || c == '\'':
+		text, err := s.stringLit(c)
+		return protoToken{kind: tokenString, text: text, line: line}, err
+	case isIdentByte(c):
+		for s.pos < len(s.src) && (isIdentByte(s.src[s.pos]) || s.src[s.pos] == '.') {
+			s.pos++
+		}
+		return protoToken{kind: tokenIdent, text: s.src[start:s.pos], line: line}, nil
+	default:
+		s.pos++
+		return protoToken{kind: tokenSymbol, text: s.src[start:s.pos], line: line}, nil
+	}
+}
+
+// skipSpace skips whitespace and comments.
+func (s *protoScanner) skipSpace() error {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == '\n':
+			s.line++
+			s.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			s.pos++
+		case strings.HasPrefix(s.src[s.pos:], "//"):
+			end := strings.IndexByte(s.src[s.pos:], '\n')
+			if end < 0 {
+				s.pos = len(s.src)
+				return nil
+			}
+			s.pos += end
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			end := strings.Index(s.src[s.pos+2:], "*/")
+			if end < 0 {
+				return s.errorf(s.line, "unterminated block comment")
+			}
+			comment := s.src[s.pos : s.pos+2+end+2]
+			s.line += strings.Count(comment, "\n")
+			s.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// stringLit reads a string literal delimited by quote and decodes its
+// escape sequences.
+func (s *protoScanner) stringLit(quote byte) (string, error) {
+	line := s.line
+	s.pos++
+	var sb strings.Builder
+	for {
+		if s.pos >= len(s.src) || s.src[s.pos] == '\n' {
+			return "", s.errorf(line, "unterminated string")
+		}
+		c := s.src[s.pos]
+		s.pos++
+		switch c {
+		case quote:
+			return sb.String(), nil
+		case '\\':
+			if err := s.escape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// escape decodes the escape sequence following a backslash.
+func (s *protoScanner) escape(sb *strings.Builder) error {
+	if s.pos >= len(s.src) {
+		return s.errorf(s.line, "unterminated string")
+	}
+	c := s.src[s.pos]
+	s.pos++
+	switch c {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '\'', '"', '?':
+		sb.WriteByte(c)
+	case 'x', 'X':
+		v, ok := s.digits(16, 2)
+		if !ok {
+			return s.errorf(s.line, "invalid hex escape")
+		}
+		sb.WriteByte(byte(v))
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		start := s.pos
+		v, ok := s.digits(16, n)
+		if !ok || s.pos-start != n || !utf8.ValidRune(rune(v)) {
+			return s.errorf(s.line, "invalid unicode escape")
+		}
+		sb.WriteRune(rune(v))
+	default:
+		if c < '0' || c > '7' {
+			return s.errorf(s.line, "invalid escape \\"+string(c))
+		}
+		s.pos--
+		v, _ := s.digits(8, 3)
+		if v > 0xff {
+			return s.errorf(s.line, "invalid octal escape")
+		}
+		sb.WriteByte(byte(v))
+	}
+	return nil
+}
+
+// digits reads up to max digits in the given base.
+func (s *protoScanner) digits(base, max int) (uint64, bool) {
+	start := s.pos
+	for s.pos < len(s.src) && s.pos-start < max && isDigit(s.src[s.pos], base) {
+		s.pos++
+	}
+	if s.pos == start {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s.src[start:s.pos], base, 32)
+	return v, err == nil
+}
+
+func (s *protoScanner) errorf(line int, msg string) error {
+	return errors.New("line " + strconv.Itoa(line) + ": " + msg)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isDigit(c byte, base int) bool {
+	switch base {
+	case 8:
+		return c >= '0' && c <= '7'
+	default:
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	}
+}
@@ -0,0 +1,81 @@
+package protoc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseImports(t *testing.T) {
+	src := `// import "commented/line.proto";
+syntax = "proto3";
+
+/* import "commented/block.proto";
+   import "commented/block2.proto"; */
+package example.v1;
+
+import "google/protobuf/empty.proto";
+import public "public/dep.proto";
+import weak 'weak/dep.proto';
+import "esc\x61ped/\"quoted\".proto";
+import "split/" "path.proto";
+
+option go_package = "import \"not/an/import.proto\";";
+
+message Msg {
+  string import = 1; // import "trailing.proto";
+}
+`
+	imports, err := ParseImports([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"google/protobuf/empty.proto",
+		"public/dep.proto",
+		"weak/dep.proto",
+		`escaped/"quoted".proto`,
+		"split/path.proto",
+	}
+	if !slices.Equal(imports, expected) {
+		t.Errorf("expected %v, got %v", expected, imports)
+	}
+}
+
+func TestParseImportsErrors(t *testing.T) {
+	for _, src := range []string{
+		`import "unterminated.proto;`,
+		`import "missing_semicolon.proto"`,
+		`import public;`,
+		`/* unterminated comment`,
+		`import "bad\escape.proto";`,
+	} {
+		if _, err := ParseImports([]byte(src)); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestParsePackage(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, pkg string
+	}{
+		{"Packaged", "syntax = \"proto3\";\npackage example.v1;\nmessage A {}\n", "example.v1"},
+		{"Unpackaged", "syntax = \"proto3\";\nmessage A {}\n", ""},
+		{"Commented", "syntax = \"proto3\";\n// package old.v1;\n/* package older.v1; */\npackage example . v2 ;\n", "example.v2"},
+		{"CommentedOnly", "syntax = \"proto3\";\n// package old.v1;\nmessage A { string package = 1; }\n", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkg, err := ParsePackage([]byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkg != tc.pkg {
+				t.Errorf("expected package %q, got %q", tc.pkg, pkg)
+			}
+		})
+	}
+
+	if _, err := ParsePackage([]byte(`package;`)); err == nil {
+		t.Error("expected error for an empty package name")
+	}
+}
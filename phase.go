@@ -0,0 +1,61 @@
+package protoc
+
+import (
+	"context"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Phases reported to Config.OnPhase.
+const (
+	// PhaseRun is a complete protoc run, including plugin invocations.
+	PhaseRun = "run"
+	// PhasePlugin is a single plugin invocation, including the time the
+	// PluginHandler takes to start the plugin.
+	PhasePlugin = "plugin"
+)
+
+// endPhase reports the duration of phase since start to Config.OnPhase.
+func (p *Protoc) endPhase(phase string, start time.Time) {
+	if p.onPhase != nil {
+		p.onPhase(phase, time.Since(start))
+	}
+}
+
+// BenchmarkInit brings up a Protoc instance in r and returns the duration of
+// each phase: compiling the module, instantiating it with NewProtocWithModule
+// and initializing it with Init. The numbers depend on the platform and on
+// the compilation cache of r, and help to choose between pooling, caching
+// and warming up instances. The instance and the modules it added are
+// removed from r again; r must not hold another instance.
+func BenchmarkInit(ctx context.Context, r wazero.Runtime) (compileDur, instantiateDur, initDur time.Duration, err error) {
+	err = withTransientModules(ctx, r, func() error {
+		start := time.Now()
+		compiled, err := CompileProtoc(ctx, r)
+		if err != nil {
+			return err
+		}
+		defer compiled.Close(ctx)
+		compileDur = time.Since(start)
+
+		start = time.Now()
+		p, err := NewProtocWithModule(ctx, r, compiled, nil)
+		if err != nil {
+			return err
+		}
+		defer p.Close(ctx)
+		instantiateDur = time.Since(start)
+
+		start = time.Now()
+		if err := p.Init(ctx); err != nil {
+			return err
+		}
+		initDur = time.Since(start)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return compileDur, instantiateDur, initDur, nil
+}
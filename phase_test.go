@@ -0,0 +1,63 @@
+package protoc
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func TestProtocOnPhase(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	const pluginDelay = 10 * time.Millisecond
+	fakeGo := &MiddlewarePluginHandler{
+		Handler: fakePlugin("foo.pb.go", "package foo\n"),
+		Before: func(ctx context.Context, program string, input []byte) ([]byte, error) {
+			time.Sleep(pluginDelay)
+			return input, nil
+		},
+	}
+
+	var phases []string
+	durations := make(map[string]time.Duration)
+	p := newTestProtoc(t, &Config{
+		FS:            memFS,
+		PluginHandler: fakeGo,
+		OnPhase: func(phase string, d time.Duration) {
+			phases = append(phases, phase)
+			durations[phase] += d
+		},
+	})
+
+	if _, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go"}}); err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	expected := []string{PhasePlugin, PhaseRun}
+	if !slices.Equal(phases, expected) {
+		t.Fatalf("expected phases %v, got %v", expected, phases)
+	}
+	if durations[PhasePlugin] < pluginDelay || durations[PhaseRun] < durations[PhasePlugin] {
+		t.Errorf("unexpected durations %v", durations)
+	}
+}
+
+func TestBenchmarkInit(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+
+	// The runtime can be measured repeatedly.
+	for range 2 {
+		compileDur, instantiateDur, initDur, err := BenchmarkInit(ctx, r)
+		if err != nil {
+			t.Fatalf("BenchmarkInit failed: %v", err)
+		}
+		if compileDur <= 0 || instantiateDur <= 0 || initDur <= 0 {
+			t.Errorf("expected positive durations, got %v, %v, %v", compileDur, instantiateDur, initDur)
+		}
+	}
+}
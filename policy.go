@@ -0,0 +1,151 @@
+package protoc
+
+import (
+	"io/fs"
+	"path"
+
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// FSPolicy decides whether protoc may perform a file operation. op is one
+// of "open", "stat", "readdir", "readlink", "mkdir", "chmod", "rename",
+// "rmdir", "unlink", "link", "symlink" or "utimens", and name is the
+// slash-separated path relative to the root of Config.FS, "." for the root.
+// Returning an error denies the operation, which protoc sees as a
+// permission error.
+type FSPolicy func(op, name string) error
+
+// policyFS consults a FSPolicy before each operation on the wrapped FS.
+type policyFS struct {
+	experimentalsys.FS
+	policy FSPolicy
+}
+
+// allow checks op on each of names against the policy.
+func (p *policyFS) allow(op string, names ...string) experimentalsys.Errno {
+	for _, name := range names {
+		if name == "" {
+			name = "."
+		}
+		if err := p.policy(op, path.Clean(name)); err != nil {
+			return experimentalsys.EACCES
+		}
+	}
+	return 0
+}
+
+// OpenFile implements sys.FS.
+func (p *policyFS) OpenFile(name string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	if errno := p.allow("open", name); errno != 0 {
+		return nil, errno
+	}
+	f, errno := p.FS.OpenFile(name, flag, perm)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &policyFile{File: f, fs: p, name: name}, 0
+}
+
+// Lstat implements sys.FS.
+func (p *policyFS) Lstat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	if errno := p.allow("stat", name); errno != 0 {
+		return sys.Stat_t{}, errno
+	}
+	return p.FS.Lstat(name)
+}
+
+// Stat implements sys.FS.
+func (p *policyFS) Stat(name string) (sys.Stat_t, experimentalsys.Errno) {
+	if errno := p.allow("stat", name); errno != 0 {
+		return sys.Stat_t{}, errno
+	}
+	return p.FS.Stat(name)
+}
+
+// Mkdir implements sys.FS.
+func (p *policyFS) Mkdir(name string, perm fs.FileMode) experimentalsys.Errno {
+	if errno := p.allow("mkdir", name); errno != 0 {
+		return errno
+	}
+	return p.FS.Mkdir(name, perm)
+}
+
+// Chmod implements sys.FS.
+func (p *policyFS) Chmod(name string, perm fs.FileMode) experimentalsys.Errno {
+	if errno := p.allow("chmod", name); errno != 0 {
+		return errno
+	}
+	return p.FS.Chmod(name, perm)
+}
+
+// Rename implements sys.FS.
+func (p *policyFS) Rename(from, to string) experimentalsys.Errno {
+	if errno := p.allow("rename", from, to); errno != 0 {
+		return errno
+	}
+	return p.FS.Rename(from, to)
+}
+
+// Rmdir implements sys.FS.
+func (p *policyFS) Rmdir(name string) experimentalsys.Errno {
+	if errno := p.allow("rmdir", name); errno != 0 {
+		return errno
+	}
+	return p.FS.Rmdir(name)
+}
+
+// Unlink implements sys.FS.
+func (p *policyFS) Unlink(name string) experimentalsys.Errno {
+	if errno := p.allow("unlink", name); errno != 0 {
+		return errno
+	}
+	return p.FS.Unlink(name)
+}
+
+// Link implements sys.FS.
+func (p *policyFS) Link(oldName, newName string) experimentalsys.Errno {
+	if errno := p.allow("link", oldName, newName); errno != 0 {
+		return errno
+	}
+	return p.FS.Link(oldName, newName)
+}
+
+// Symlink implements sys.FS.
+func (p *policyFS) Symlink(oldName, linkName string) experimentalsys.Errno {
+	if errno := p.allow("symlink", linkName); errno != 0 {
+		return errno
+	}
+	return p.FS.Symlink(oldName, linkName)
+}
+
+// Readlink implements sys.FS.
+func (p *policyFS) Readlink(name string) (string, experimentalsys.Errno) {
+	if errno := p.allow("readlink", name); errno != 0 {
+		return "", errno
+	}
+	return p.FS.Readlink(name)
+}
+
+// Utimens implements sys.FS.
+func (p *policyFS) Utimens(name string, atim, mtim int64) experimentalsys.Errno {
+	if errno := p.allow("utimens", name); errno != 0 {
+		return errno
+	}
+	return p.FS.Utimens(name, atim, mtim)
+}
+
+// policyFile consults the policy before listing a directory.
+type policyFile struct {
+	experimentalsys.File
+	fs   *policyFS
+	name string
+}
+
+// Readdir implements sys.File.
+func (f *policyFile) Readdir(n int) ([]experimentalsys.Dirent, experimentalsys.Errno) {
+	if errno := f.fs.allow("readdir", f.name); errno != 0 {
+		return nil, errno
+	}
+	return f.File.Readdir(n)
+}
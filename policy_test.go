@@ -0,0 +1,52 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocFSPolicy(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"public/ok.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Ok {}`)},
+		"public/leak.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "secret/key.proto"; message Leak { Key key = 1; }`)},
+		"secret/key.proto":  &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Key {}`)},
+	}
+
+	// Deny everything outside public/, recording the denied operations. This
+	// includes files the guest libc probes, such as etc/localtime.
+	var mu sync.Mutex
+	var denied []string
+	policy := func(op, name string) error {
+		if name == "." || name == "public" || strings.HasPrefix(name, "public/") {
+			return nil
+		}
+		mu.Lock()
+		denied = append(denied, op+" "+name)
+		mu.Unlock()
+		return errors.New("denied")
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS, FSPolicy: policy})
+
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"public/ok.proto"}}); err != nil {
+		t.Fatalf("Compile inside the allowed subtree failed: %v", err)
+	}
+	if slices.ContainsFunc(denied, func(op string) bool { return strings.Contains(op, "secret/") }) {
+		t.Errorf("expected no access to secret/, got %v", denied)
+	}
+
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"public/leak.proto"}})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError importing outside the allowed subtree, got: %v", err)
+	}
+	if !slices.Contains(denied, "open secret/key.proto") {
+		t.Errorf("expected access to secret/key.proto to be denied, got %v", denied)
+	}
+}
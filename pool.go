@@ -0,0 +1,173 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Pool is a fixed set of initialized Protoc instances for running
+// independent compilations concurrently. Every instance has its own
+// runtime, as a runtime holds a single instance.
+type Pool struct {
+	instances chan *Protoc
+	all       []*Protoc
+	runtimes  []wazero.Runtime
+	cache     wazero.CompilationCache
+}
+
+// NewPool creates size initialized instances configured by cfg, which is
+// shared by all of them, so writers in cfg must be safe for concurrent use.
+// The runtimes are created with rcfg; if it is nil a configuration with a
+// compilation cache shared by the pool is used. Call Close when done.
+func NewPool(ctx context.Context, size int, rcfg wazero.RuntimeConfig, cfg *Config) (*Pool, error) {
+	if size < 1 {
+		return nil, errors.New("pool size must be positive")
+	}
+	pool := &Pool{instances: make(chan *Protoc, size)}
+	if rcfg == nil {
+		pool.cache = wazero.NewCompilationCache()
+		rcfg = wazero.NewRuntimeConfig().WithCompilationCache(pool.cache)
+	}
+	for range size {
+		r := wazero.NewRuntimeWithConfig(ctx, rcfg)
+		pool.runtimes = append(pool.runtimes, r)
+		p, err := NewProtoc(ctx, r, cfg)
+		if err == nil {
+			err = p.Init(ctx)
+		}
+		if err != nil {
+			pool.Close(ctx)
+			return nil, err
+		}
+		pool.all = append(pool.all, p)
+		pool.instances <- p
+	}
+	return pool, nil
+}
+
+// Do calls fn with an idle instance, waiting for one to become available.
+// The instance must not be used after fn returns.
+func (pool *Pool) Do(ctx context.Context, fn func(p *Protoc) error) error {
+	var p *Protoc
+	select {
+	case p = <-pool.instances:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { pool.instances <- p }()
+	return fn(p)
+}
+
+// Close closes every instance and runtime of the pool. It must not be
+// called while instances are in use.
+func (pool *Pool) Close(ctx context.Context) error {
+	var errs []error
+	for _, p := range pool.all {
+		errs = append(errs, p.Close(ctx))
+	}
+	for _, r := range pool.runtimes {
+		errs = append(errs, r.Close(ctx))
+	}
+	if pool.cache != nil {
+		errs = append(errs, pool.cache.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// CompileJob is a compilation run by CompileBatch.
+type CompileJob struct {
+	// Options configures the compilation.
+	Options CompileOptions
+	// Required fails the whole batch if the job fails, canceling the jobs
+	// that have not started yet. Default: the error is only reported in
+	// the job's CompileResult.
+	Required bool
+}
+
+// CompileResult is the outcome of a CompileJob.
+type CompileResult struct {
+	// DescriptorSet is the encoded FileDescriptorSet, if the job succeeded.
+	DescriptorSet []byte
+	// Err is the error of the job, a *CompileError if protoc failed.
+	Err error
+}
+
+// CompileBatch runs jobs concurrently across the pool instances and returns
+// their results in the order of jobs. Failed jobs are reported in their
+// results; the returned error is only set if a Required job failed, in
+// which case jobs that had not started fail with context.Canceled while
+// running jobs finish, or if ctx was canceled.
+func (pool *Pool) CompileBatch(ctx context.Context, jobs []CompileJob) ([]CompileResult, error) {
+	// Running jobs use ctx, so that only starting jobs is canceled.
+	batchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]CompileResult, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &results[i]
+			res.Err = pool.Do(batchCtx, func(p *Protoc) error {
+				// Don't start jobs after a required job failed.
+				if err := batchCtx.Err(); err != nil {
+					return err
+				}
+				var err error
+				res.DescriptorSet, err = p.Compile(ctx, job.Options)
+				return err
+			})
+			if res.Err != nil && job.Required {
+				cancel(res.Err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The cause is the error of the failed required job, or the error of
+	// ctx if it was canceled by the caller.
+	return results, context.Cause(batchCtx)
+}
+
+// CompileStream compiles the jobs received from in one after another on p
+// and sends their results, in the order of the jobs, on the returned
+// channel, for pipelines built on channels. The channel is closed once in
+// is closed and drained, after the result of a failed Required job, or
+// when ctx is done; jobs still in in are then left unprocessed. To compile
+// concurrently, run a stream per instance of a Pool.
+//
+// Init() must be called first.
+func (p *Protoc) CompileStream(ctx context.Context, in <-chan CompileJob) <-chan CompileResult {
+	out := make(chan CompileResult)
+	go func() {
+		defer close(out)
+		for {
+			var job CompileJob
+			select {
+			case <-ctx.Done():
+				return
+			case next, ok := <-in:
+				if !ok {
+					return
+				}
+				job = next
+			}
+
+			var res CompileResult
+			res.DescriptorSet, res.Err = p.Compile(ctx, job.Options)
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+			if res.Err != nil && job.Required {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,174 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func newTestPool(t *testing.T, size int, cfg *Config) *Pool {
+	t.Helper()
+	ctx := context.Background()
+	pool, err := NewPool(ctx, size, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache), cfg)
+	if err != nil {
+		t.Fatalf("NewPool failed: %v", err)
+	}
+	t.Cleanup(func() { pool.Close(ctx) })
+	return pool
+}
+
+func TestPoolCompileBatch(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`)},
+	}
+	var jobs []CompileJob
+	for i := range 6 {
+		name := fmt.Sprintf("job%d.proto", i)
+		memFS[name] = &fstest.MapFile{Data: []byte(fmt.Sprintf(`syntax = "proto3"; message Job%d {}`, i))}
+		jobs = append(jobs, CompileJob{Options: CompileOptions{Files: []string{name}}})
+	}
+	jobs[3] = CompileJob{Options: CompileOptions{Files: []string{"bad.proto"}}}
+
+	pool := newTestPool(t, 2, &Config{FS: memFS})
+
+	results, err := pool.CompileBatch(ctx, jobs)
+	if err != nil {
+		t.Fatalf("CompileBatch failed: %v", err)
+	}
+	for i, res := range results {
+		if i == 3 {
+			var compileErr *CompileError
+			if !errors.As(res.Err, &compileErr) {
+				t.Errorf("job 3: expected *CompileError, got: %v", res.Err)
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Fatalf("job %d failed: %v", i, res.Err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(res.DescriptorSet, set); err != nil {
+			t.Fatal(err)
+		}
+		if name := set.GetFile()[0].GetName(); name != jobs[i].Options.Files[0] {
+			t.Errorf("job %d: expected result for %s, got %s", i, jobs[i].Options.Files[0], name)
+		}
+	}
+
+	// A failed required job fails the batch.
+	jobs[3].Required = true
+	_, err = pool.CompileBatch(ctx, jobs)
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("expected *CompileError, got: %v", err)
+	}
+}
+
+func TestPoolCompileBatchFinishesRunningJobs(t *testing.T) {
+	ctx := context.Background()
+	slowStarted, badDone := make(chan struct{}), make(chan struct{})
+	var slowOnce, badOnce sync.Once
+	resolver := func(importPath string) ([]byte, bool) {
+		switch importPath {
+		case "bad.proto":
+			// Fail while the slow job is running.
+			<-slowStarted
+			badOnce.Do(func() { close(badDone) })
+			return []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`), true
+		case "slow.proto":
+			// Keep running until the required job has failed.
+			slowOnce.Do(func() { close(slowStarted) })
+			<-badDone
+			time.Sleep(100 * time.Millisecond)
+			return []byte(`syntax = "proto3"; message Slow {}`), true
+		}
+		return nil, false
+	}
+
+	// The runtimes abort runs whose context is canceled.
+	runtimeConfig := wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache).WithCloseOnContextDone(true)
+	pool, err := NewPool(ctx, 2, runtimeConfig, &Config{Resolver: resolver})
+	if err != nil {
+		t.Fatalf("NewPool failed: %v", err)
+	}
+	defer pool.Close(ctx)
+
+	results, err := pool.CompileBatch(ctx, []CompileJob{
+		{Options: CompileOptions{Files: []string{"slow.proto"}}},
+		{Options: CompileOptions{Files: []string{"bad.proto"}}, Required: true},
+	})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected *CompileError, got: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Errorf("expected the running job to finish, got: %v", results[0].Err)
+	}
+}
+
+func TestProtocCompileStream(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message A {}`)},
+		"b.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message B {}`)},
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	in := make(chan CompileJob)
+	out := p.CompileStream(ctx, in)
+	go func() {
+		defer close(in)
+		for _, name := range []string{"a.proto", "bad.proto", "b.proto"} {
+			in <- CompileJob{Options: CompileOptions{Files: []string{name}}}
+		}
+	}()
+	var results []CompileResult
+	for res := range out {
+		results = append(results, res)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	var compileErr *CompileError
+	if results[0].Err != nil || results[2].Err != nil || !errors.As(results[1].Err, &compileErr) {
+		t.Errorf("unexpected errors %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(results[2].DescriptorSet, set); err != nil {
+		t.Fatal(err)
+	}
+	if name := set.GetFile()[0].GetName(); name != "b.proto" {
+		t.Errorf("expected results in job order, got %s last", name)
+	}
+
+	// A failed required job ends the stream.
+	in = make(chan CompileJob, 2)
+	in <- CompileJob{Options: CompileOptions{Files: []string{"bad.proto"}}, Required: true}
+	in <- CompileJob{Options: CompileOptions{Files: []string{"a.proto"}}}
+	results = nil
+	for res := range p.CompileStream(ctx, in) {
+		results = append(results, res)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("expected only the failed required job, got %v", results)
+	}
+
+	// Canceling ctx closes the output channel while in is still open.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	out = p.CompileStream(cancelCtx, make(chan CompileJob))
+	cancel()
+	if _, ok := <-out; ok {
+		t.Error("expected the output channel to be closed")
+	}
+}
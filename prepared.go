@@ -0,0 +1,90 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// PreparedRun is a set of protoc arguments allocated in guest memory once so
+// that it can be executed repeatedly without per-run allocation.
+//
+// Executions are serialized with other calls on the Protoc instance.
+// Call Close() when done to free the guest memory.
+type PreparedRun struct {
+	p    *Protoc
+	args []string
+
+	// mod is the module instance argv was allocated in. The arguments are
+	// reallocated if the Protoc instance replaced its module.
+	mod     api.Module
+	argPtrs []uint32
+	argvPtr uint32
+	closed  bool
+}
+
+// Prepare allocates args in guest memory and returns a PreparedRun.
+// Init() must be called first.
+func (p *Protoc) Prepare(ctx context.Context, args []string) (*PreparedRun, error) {
+	args = p.argv(args)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.initialized {
+		return nil, errors.New("protoc not initialized, call Init() first")
+	}
+
+	r := &PreparedRun{p: p, args: append([]string(nil), args...)}
+	if err := r.alloc(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// alloc allocates the arguments in the current module instance.
+// p.mu must be held.
+func (r *PreparedRun) alloc(ctx context.Context) error {
+	argPtrs, argvPtr, err := r.p.allocArgs(ctx, r.args)
+	if err != nil {
+		return err
+	}
+	r.mod, r.argPtrs, r.argvPtr = r.p.mod, argPtrs, argvPtr
+	return nil
+}
+
+// Exec runs protoc with the prepared arguments.
+// Returns the protoc exit code (0 on success).
+func (r *PreparedRun) Exec(ctx context.Context) (int, error) {
+	r.p.mu.Lock()
+	defer r.p.mu.Unlock()
+
+	if r.closed {
+		return 1, errors.New("prepared run is closed")
+	}
+	if err := r.p.beginRun(ctx, r.args); err != nil {
+		return 1, err
+	}
+	if r.mod != r.p.mod {
+		if err := r.alloc(ctx); err != nil {
+			return 1, err
+		}
+	}
+	return r.p.callRun(ctx, len(r.args), r.argvPtr)
+}
+
+// Close frees the guest memory held by the prepared arguments.
+func (r *PreparedRun) Close(ctx context.Context) error {
+	r.p.mu.Lock()
+	defer r.p.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.mod == r.p.mod {
+		r.p.freeArgs(ctx, r.argPtrs, r.argvPtr)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPreparedRun(t *testing.T) {
+	ctx := context.Background()
+	var stdout bytes.Buffer
+	p := newTestProtoc(t, &Config{Stdout: &stdout})
+
+	r, err := p.Prepare(ctx, []string{"protoc", "--version"})
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		exitCode, err := r.Exec(ctx)
+		if err != nil {
+			t.Fatalf("Exec %d failed: %v", i, err)
+		}
+		if exitCode != 0 {
+			t.Fatalf("Exec %d: unexpected exit code: %d", i, exitCode)
+		}
+	}
+	if n := strings.Count(stdout.String(), "libprotoc"); n != 3 {
+		t.Errorf("expected 3 version lines, got %d: %s", n, stdout.String())
+	}
+
+	// The prepared arguments survive the module being replaced after a run
+	// that leaves plugin state behind.
+	if _, err := p.Run(ctx, []string{"protoc", "--cpp_opt=lite", "--version"}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if exitCode, err := r.Exec(ctx); err != nil || exitCode != 0 {
+		t.Fatalf("Exec after reset failed: %d %v", exitCode, err)
+	}
+
+	if err := r.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := r.Exec(ctx); err == nil {
+		t.Error("expected error executing a closed prepared run")
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	ctx := context.Background()
+	p := newTestProtoc(b, &Config{})
+	args := []string{"protoc", "--version"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Run(ctx, args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreparedRun(b *testing.B) {
+	ctx := context.Background()
+	p := newTestProtoc(b, &Config{})
+	r, err := p.Prepare(ctx, []string{"protoc", "--version"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close(ctx)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Exec(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProtocArgvWriteError(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProtoc(t, &Config{})
+
+	// A buffer outside guest memory fails instead of leaving argv unwritten.
+	argvBuf, argvCap := p.argvBuf, p.argvCap
+	p.argvBuf, p.argvCap = 0xFFFFFFF0, 64
+	_, err := p.argvBuffer(ctx, make([]uint32, 8))
+	p.argvBuf, p.argvCap = argvBuf, argvCap
+	if err == nil {
+		t.Fatal("expected an error writing argv")
+	}
+
+	if _, err := p.Run(ctx, []string{"protoc", "--version"}); err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+}
+
+// BenchmarkArgv compares allocating the argv array of a run with many files
+// per run against growing the buffer retained on the instance.
+func BenchmarkArgv(b *testing.B) {
+	ctx := context.Background()
+	p := newTestProtoc(b, &Config{})
+	ptrs := make([]uint32, 10000)
+
+	b.Run("malloc", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			argvPtr, err := p.allocArgv(ctx, ptrs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			p.freePtr(ctx, argvPtr)
+		}
+	})
+	b.Run("realloc", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := p.argvBuffer(ctx, ptrs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -9,22 +9,33 @@ import (
 	"io"
 	"io/fs"
 	"os/exec"
+	"path"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/experimental/sysfs"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
 // Protoc wraps a protoc WASI reactor module providing a high-level API
 // for Protocol Buffer compilation.
 type Protoc struct {
-	runtime wazero.Runtime
-	mod     api.Module
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	modCfg   wazero.ModuleConfig
+	mod      api.Module
 
 	// Memory management
-	malloc api.Function
-	free   api.Function
+	malloc  api.Function
+	free    api.Function
+	realloc api.Function
+	// argv array retained across runs, grown with realloc
+	argvBuf uint32
+	argvCap int
 
 	// Protoc reactor functions
 	protocInit    api.Function
@@ -34,11 +45,75 @@ type Protoc struct {
 	// Plugin handler for spawning native plugin processes
 	pluginHandler PluginHandler
 
+	// Input stream, which can be replaced for a single run
+	stdin *stdinReader
+	// Output streams, which can capture output in addition to forwarding it
+	stdout *captureWriter
+	stderr *captureWriter
+	// Last lines of stderr of the current run, if enabled
+	stderrTail *lineRing
+	// Parses stderr into diagnostics for Config.DiagnosticSink, if set
+	diagWriter *diagnosticWriter
+
+	// Writable in-memory filesystem mounted at scratchDir
+	scratch *MemFS
+	// Read-only in-memory filesystem mounted at descriptorSetsDir
+	descSets *MemFS
+	// Read-only in-memory filesystem mounted at preludeDir, if
+	// Config.PreludeFiles is set
+	prelude *MemFS
+
+	// Output limit and the plugin output returned during the current run
+	maxOutputBytes    int
+	pluginOutputBytes int
+
+	// Rewrites the paths of collected generator outputs
+	outputPathMapper func(string) string
+	// Added to collected text outputs
+	fileHeader, fileFooter string
+	headerExtensions       []string
+	// Replaces argv[0] if set
+	programName string
+	// Relative output paths are resolved against it, if set
+	workingDir string
+	// Names unnamed stack trace frames, if Config.EnableDebugInfo is set
+	debugNames *debugNames
+	// Receives the duration of each phase of a run, if set
+	onPhase func(phase string, d time.Duration)
+	// Guest function calls allowed per run, or 0 for unlimited
+	maxInstructions uint64
+	// Whether the module charges guest function calls to a budget
+	instructionCounting bool
+	// Compile with stubs for missing weak imports
+	allowMissingWeakImports bool
+	// Longest import chain allowed by the helpers, or 0 for unlimited
+	maxImportDepth int
+	// Config.FS or the resolver, read by the host to check import depth
+	sourceFS fs.FS
+	// Normalize the captured output of the helpers
+	normalizeOutput bool
+	// Name compiled files relative to the longest matching include path
+	canonicalizeFilePaths bool
+	// Files provided by Config.Resolver, if set
+	resolver *resolverFS
+	// Hides source files from protoc, if Config.FS or Config.Resolver is set
+	hiding *hidingFS
+	// Config.FS, if it is a *MemFS
+	rootFS *MemFS
+	// Records plugin invocations during RunReport
+	pluginInvocations *[]PluginInvocation
+	// Arguments of the most recent run, as passed to protoc
+	lastArgs []string
+	// Notified of the host's guest allocations, if set
+	allocObserver AllocObserver
+
 	// Mutex for thread-safe Run calls (WASI is single-threaded)
 	mu sync.Mutex
 
 	// State
 	initialized bool
+	// stale is set when the last run left plugin state behind in the CLI.
+	stale bool
 }
 
 // PluginHandler handles spawning and communicating with protoc plugins.
@@ -52,11 +127,40 @@ type PluginHandler interface {
 	Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error)
 }
 
+// PluginHandlerFunc is a PluginHandler backed by a function, for plugins
+// implemented in-process.
+type PluginHandlerFunc func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error)
+
+// Communicate calls f.
+func (f PluginHandlerFunc) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	return f(ctx, program, searchPath, input)
+}
+
 // DefaultPluginHandler spawns plugin processes using os/exec.
-type DefaultPluginHandler struct{}
+type DefaultPluginHandler struct {
+	// MaxConcurrentPlugins limits the number of plugin processes running at
+	// once. Calls beyond the limit wait for a running plugin to exit.
+	// Default: unlimited.
+	MaxConcurrentPlugins int
+
+	semOnce sync.Once
+	sem     chan struct{}
+}
 
 // Communicate spawns a plugin and communicates via stdin/stdout.
 func (h *DefaultPluginHandler) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	if h.MaxConcurrentPlugins > 0 {
+		h.semOnce.Do(func() {
+			h.sem = make(chan struct{}, h.MaxConcurrentPlugins)
+		})
+		select {
+		case h.sem <- struct{}{}:
+			defer func() { <-h.sem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	var cmd *exec.Cmd
 	if searchPath {
 		cmd = exec.CommandContext(ctx, program)
@@ -79,6 +183,46 @@ func (h *DefaultPluginHandler) Communicate(ctx context.Context, program string,
 	return stdout.Bytes(), nil
 }
 
+// scratchDir is the guest path of the writable in-memory filesystem used by
+// the higher-level helpers for generated output.
+const scratchDir = "/.protoc-wasi"
+
+// captureWriter forwards writes to an underlying writer and, while a capture
+// buffer is set, also records them. It is only written to while p.mu is held.
+type captureWriter struct {
+	w   io.Writer
+	buf *bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.buf != nil {
+		c.buf.Write(b)
+	}
+	if c.w == nil {
+		return len(b), nil
+	}
+	return c.w.Write(b)
+}
+
+// stdinReader reads from an underlying reader or, while an input is set,
+// from the input instead. It is only read from while p.mu is held.
+type stdinReader struct {
+	r     io.Reader
+	input io.Reader
+}
+
+// Read implements io.Reader.
+func (s *stdinReader) Read(b []byte) (int, error) {
+	if s.input != nil {
+		return s.input.Read(b)
+	}
+	if s.r == nil {
+		return 0, io.EOF
+	}
+	return s.r.Read(b)
+}
+
 // Config holds configuration for creating a new Protoc instance.
 type Config struct {
 	// Stdin is the standard input for protoc. Default: empty.
@@ -88,16 +232,158 @@ type Config struct {
 	// Stderr is the standard error for protoc. Default: discard.
 	Stderr io.Writer
 	// FS is the filesystem for reading .proto files and writing output.
+	// It is mounted at the guest root, read-only unless it is a *MemFS such
+	// as one created by NewWritableMapFS. An embed.FS works as is, with
+	// paths prefixed by the embedded directory; use fs.Sub to mount a
+	// subtree.
 	// Default: no filesystem access.
 	FS fs.FS
+	// Resolver, if set, provides the files of the guest root instead of FS,
+	// for backing imports with arbitrary logic such as HTTP or a database.
+	// It is called with slash-separated paths relative to the root, e.g.
+	// "example/v1/greeter.proto" for an include path of "/", and reports
+	// whether the file exists. Results are cached for the duration of a
+	// run. Directories cannot be listed. Default: FS is used.
+	Resolver func(importPath string) ([]byte, bool)
+	// StripPathPrefix, if set, is a directory of FS that becomes the guest
+	// root, e.g. "proto" for a repository tree keeping its protos under
+	// proto/, so that imports written relative to that directory resolve.
+	// FS is then mounted read-only, even if it is a *MemFS. Leading and
+	// trailing slashes are ignored, so "/" is the root of FS, which is
+	// mounted as is.
+	// Default: FS is mounted as is.
+	StripPathPrefix string
+	// FSPolicy, if set, is consulted on every operation protoc performs on
+	// FS and can deny it, for auditing or restricting untrusted
+	// compilations. Default: all operations are allowed.
+	FSPolicy FSPolicy
 	// FSConfig allows configuring the wazero filesystem.
-	// If set, FS is ignored.
+	// If set, FS is ignored. It must be created with wazero.NewFSConfig.
 	FSConfig wazero.FSConfig
 	// PluginHandler handles spawning plugin processes.
 	// Default: DefaultPluginHandler (uses os/exec).
 	PluginHandler PluginHandler
+	// MaxTotalOutputBytes limits the generated output of a single run.
+	// The limit applies separately to the total size of the responses
+	// returned by plugins and to the total size of the files written to the
+	// in-memory output filesystem used by helpers such as RunGenerators.
+	// A run exceeding it fails with ErrOutputTooLarge. Default: unlimited.
+	MaxTotalOutputBytes int
+	// FixedModTime, if set, is reported as the modification time of every
+	// file in the in-memory filesystems, including a *MemFS passed as FS,
+	// so that archives built from them are byte-stable.
+	// Default: the time of the last change.
+	FixedModTime time.Time
+	// StderrTailLines, if positive, keeps the last lines of stderr of each
+	// run in memory, available from LastStderr. Default: disabled.
+	StderrTailLines int
+	// EnableDebugInfo improves the stack traces of errors from guest traps.
+	// Frames of functions missing from the module's name section, which the
+	// embedded build strips, are named after the function's export. The
+	// runtime must keep debug info, which is wazero's default.
+	// Default: traces show function indices only.
+	EnableDebugInfo bool
+	// ProgramName replaces argv[0] of every run, so that protoc messages
+	// mentioning it reflect the name of a wrapping tool.
+	// Default: argv[0] as passed to Run, "protoc" for the helpers.
+	ProgramName string
+	// OutputPathMapper rewrites the paths of generated files collected by
+	// helpers such as RunGenerators. Returning "" drops the file.
+	// Default: paths are kept as generated.
+	OutputPathMapper func(path string) string
+	// FileHeader and FileFooter are added to the start and end of the
+	// generated files collected by helpers such as RunGenerators, e.g. for
+	// license headers. Binary files, which are not UTF-8 or contain control
+	// characters, are skipped.
+	// Default: files are kept as generated.
+	FileHeader, FileFooter string
+	// HeaderExtensions limits FileHeader and FileFooter to files with these
+	// extensions, such as ".go". Default: all text files.
+	HeaderExtensions []string
+	// OnPhase, if set, is called with the duration of each phase of a run,
+	// PhasePlugin for every plugin invocation and PhaseRun for the whole
+	// run. The embedded module does not expose parsing, linking or built-in
+	// generation separately, so those are only part of PhaseRun.
+	// Default: phases are not timed.
+	OnPhase func(phase string, d time.Duration)
+	// MaxInstructions limits the work of a single run, which fails with
+	// ErrBudgetExceeded once the limit is reached. wazero does not count
+	// individual instructions, so every guest function call counts as one.
+	// Unlike a context deadline the limit does not depend on the host's
+	// speed or load. The module must be compiled with
+	// WithInstructionCounting, which NewProtoc does when this is set;
+	// otherwise Init fails with ErrNoInstructionCounting.
+	// Default: unlimited.
+	MaxInstructions uint64
+	// AllocObserver, if set, is notified of every guest allocation and
+	// free made by the host, for debugging leaks.
+	// Default: allocations are not observed.
+	AllocObserver AllocObserver
+	// AllowMissingWeakImports lets the compilation helpers succeed when
+	// files imported with "import weak" are not found, as they are optional
+	// at runtime. The weak imports are still recorded in weak_dependency,
+	// but the missing files are left out of the descriptor set. Other
+	// imports of a missing file still fail.
+	// Default: missing weak imports fail the compilation, as in protoc.
+	AllowMissingWeakImports bool
+	// MaxImportDepth, if set, limits the length of import chains of the
+	// files compiled by the helpers, which fail with an *ImportDepthError
+	// naming the chain, e.g. 1 allows a.proto to import b.proto but not
+	// b.proto to import c.proto as well. The imports are scanned before
+	// running protoc, which requires FS or Resolver.
+	// Default: unlimited.
+	MaxImportDepth int
+	// PreludeFiles are .proto files, keyed by import path, that every file
+	// compiled by the helpers can import without them being on the include
+	// paths or compiled as targets, like a project-wide include directory.
+	// Files on the include paths take precedence.
+	PreludeFiles map[string][]byte
+	// CanonicalizeFilePaths makes the compilation helpers pass each file
+	// relative to the longest include path containing it. protoc names a
+	// file, in descriptors and source info, after the first include path
+	// containing it and rejects files given relative to the root when an
+	// include path is absolute, so that the names otherwise depend on how
+	// the files were specified. A file shadowed under the shorter name by
+	// an earlier include path is passed as given, so that protoc still
+	// compiles the same file. Files must be in FS or Resolver.
+	// Default: files are passed as given.
+	CanonicalizeFilePaths bool
+	// WorkingDir is the guest directory relative output paths resolve to,
+	// e.g. "/out" for --go_out=. or --descriptor_set_out=set.pb to land in
+	// the out directory of a *MemFS passed as FS, where they can be
+	// collected. It applies to --<name>_out, --descriptor_set_out, -o and
+	// --dependency_out given as --flag=value. Input files and include
+	// paths are not affected, as protoc names files after them.
+	// Default: relative output paths resolve against the root.
+	WorkingDir string
+	// NormalizeOutput makes the output the helpers capture consistent
+	// across platforms: invalid UTF-8 is replaced with U+FFFD and
+	// backslashes in the file names of diagnostics become slashes. The
+	// output forwarded to Stdout and Stderr is not changed.
+	// Default: the output is used as protoc wrote it.
+	NormalizeOutput bool
+	// Generators are in-process plugins keyed by generator name, such as
+	// "go" for --go_out, which serve protoc-gen-<name> before
+	// PluginHandler is asked. Plugins given with an explicit path, as in
+	// --plugin=protoc-gen-go=/path, still go to PluginHandler.
+	// Default: all plugins are handled by PluginHandler.
+	Generators map[string]PluginHandler
+	// DiagnosticSink, if set, is called with each line of stderr, parsed
+	// as a Diagnostic, as soon as protoc writes it, e.g. to report errors
+	// in an editor while a large compilation is still running. A trailing
+	// line without a newline is reported when the run ends. Lines are
+	// normalized first if NormalizeOutput is set.
+	//
+	// The sink is called synchronously while the Protoc is locked for the
+	// run, so it must not call methods of the Protoc and should return
+	// quickly.
+	// Default: diagnostics are only available once the run has finished.
+	DiagnosticSink func(Diagnostic)
 }
 
+// ErrOutputTooLarge is returned when a run exceeds Config.MaxTotalOutputBytes.
+var ErrOutputTooLarge = errors.New("generated output exceeds MaxTotalOutputBytes")
+
 // CompileProtoc compiles the embedded protoc WASM module.
 // The compiled module can be reused across multiple Protoc instances.
 func CompileProtoc(ctx context.Context, r wazero.Runtime) (wazero.CompiledModule, error) {
@@ -108,7 +394,11 @@ func CompileProtoc(ctx context.Context, r wazero.Runtime) (wazero.CompiledModule
 // Call Close() when done to release resources.
 func NewProtoc(ctx context.Context, r wazero.Runtime, cfg *Config) (*Protoc, error) {
 	// Compile the module
-	compiled, err := CompileProtoc(ctx, r)
+	compileCtx := ctx
+	if cfg != nil && cfg.MaxInstructions > 0 {
+		compileCtx = WithInstructionCounting(ctx)
+	}
+	compiled, err := CompileProtoc(compileCtx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -121,18 +411,85 @@ func NewProtocWithModule(ctx context.Context, r wazero.Runtime, compiled wazero.
 	if cfg == nil {
 		cfg = &Config{}
 	}
+	if cfg.FS != nil && cfg.Resolver != nil {
+		return nil, errors.New("FS and Resolver are mutually exclusive")
+	}
+	if cfg.StripPathPrefix != "" && cfg.FS == nil {
+		return nil, errors.New("StripPathPrefix requires FS")
+	}
+	stripPrefix := strings.Trim(cfg.StripPathPrefix, "/")
+	if stripPrefix != "" && !fs.ValidPath(stripPrefix) {
+		return nil, fmt.Errorf("invalid StripPathPrefix %q", cfg.StripPathPrefix)
+	}
+	if cfg.MaxImportDepth > 0 && (cfg.FSConfig != nil || (cfg.FS == nil && cfg.Resolver == nil)) {
+		return nil, errors.New("MaxImportDepth requires FS or Resolver")
+	}
 
 	// Set up plugin handler
 	pluginHandler := cfg.PluginHandler
 	if pluginHandler == nil {
 		pluginHandler = &DefaultPluginHandler{}
 	}
+	if len(cfg.Generators) != 0 {
+		pluginHandler = ChainPluginHandler(generatorsHandler(cfg.Generators), pluginHandler)
+	}
+
+	// Keep the tail of stderr if requested
+	stderr := cfg.Stderr
+	var stderrTail *lineRing
+	if cfg.StderrTailLines > 0 {
+		stderrTail = newLineRing(cfg.StderrTailLines)
+		stderr = stderrTail
+		if cfg.Stderr != nil {
+			stderr = io.MultiWriter(cfg.Stderr, stderrTail)
+		}
+	}
+	var diagWriter *diagnosticWriter
+	if cfg.DiagnosticSink != nil {
+		diagWriter = &diagnosticWriter{sink: cfg.DiagnosticSink, normalize: cfg.NormalizeOutput}
+		if stderr != nil {
+			stderr = io.MultiWriter(stderr, diagWriter)
+		} else {
+			stderr = diagWriter
+		}
+	}
 
 	// Create the Protoc instance first so we can reference it in host functions
 	p := &Protoc{
 		runtime:       r,
 		pluginHandler: pluginHandler,
+		stdin:         &stdinReader{r: cfg.Stdin},
+		stdout:        &captureWriter{w: cfg.Stdout},
+		stderr:        &captureWriter{w: stderr},
+		stderrTail:    stderrTail,
+		diagWriter:    diagWriter,
+		scratch:       newMemFS(),
+		descSets:      newMemFS(),
+
+		maxOutputBytes:   cfg.MaxTotalOutputBytes,
+		outputPathMapper: cfg.OutputPathMapper,
+		fileHeader:       cfg.FileHeader,
+		fileFooter:       cfg.FileFooter,
+		headerExtensions: cfg.HeaderExtensions,
+		programName:      cfg.ProgramName,
+		onPhase:          cfg.OnPhase,
+		maxInstructions:  cfg.MaxInstructions,
+		allocObserver:    cfg.AllocObserver,
+
+		allowMissingWeakImports: cfg.AllowMissingWeakImports,
+		maxImportDepth:          cfg.MaxImportDepth,
+		canonicalizeFilePaths:   cfg.CanonicalizeFilePaths,
+		normalizeOutput:         cfg.NormalizeOutput,
+	}
+	if cfg.EnableDebugInfo {
+		p.debugNames = &debugNames{}
 	}
+	if cfg.WorkingDir != "" {
+		p.workingDir = path.Join("/", cfg.WorkingDir)
+	}
+	p.scratch.limit = int64(cfg.MaxTotalOutputBytes)
+	p.scratch.fixedModTime = cfg.FixedModTime
+	p.descSets.fixedModTime = cfg.FixedModTime
 
 	// Register host functions for plugin communication
 	_, err := r.NewHostModuleBuilder(ImportModuleProtoc).
@@ -156,74 +513,156 @@ func NewProtocWithModule(ctx context.Context, r wazero.Runtime, compiled wazero.
 		return nil, fmt.Errorf("failed to register host functions: %w", err)
 	}
 
-	// Instantiate WASI
-	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
-		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	// Instantiate WASI, unless the caller already did. wazero removes the
+	// existing module if it is instantiated twice.
+	if r.Module(wasi_snapshot_preview1.ModuleName) == nil {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+			return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+		}
 	}
 
 	// Build module config
 	modCfg := wazero.NewModuleConfig().WithName(ProtocWASMFilename)
 
-	if cfg.Stdin != nil {
-		modCfg = modCfg.WithStdin(cfg.Stdin)
+	modCfg = modCfg.WithStdin(p.stdin).WithStdout(p.stdout).WithStderr(p.stderr)
+
+	fsCfg := cfg.FSConfig
+	if fsCfg == nil {
+		fsCfg = wazero.NewFSConfig()
+		fsys := cfg.FS
+		if stripPrefix != "" {
+			sub, err := fs.Sub(fsys, stripPrefix)
+			if err != nil {
+				return nil, fmt.Errorf("StripPathPrefix: %w", err)
+			}
+			fsys = sub
+		}
+		if cfg.Resolver != nil {
+			p.resolver = &resolverFS{resolve: cfg.Resolver}
+			fsys = p.resolver
+		}
+		p.sourceFS = fsys
+		if fsys != nil {
+			var rootFS experimentalsys.FS = &sysfs.AdaptFS{FS: fsys}
+			if memFS, ok := fsys.(*MemFS); ok {
+				p.rootFS = memFS
+				if !cfg.FixedModTime.IsZero() {
+					memFS.setFixedModTime(cfg.FixedModTime)
+				}
+				rootFS = memFS.sysFS()
+			}
+			if cfg.FSPolicy != nil {
+				rootFS = &policyFS{FS: rootFS, policy: cfg.FSPolicy}
+			}
+			p.hiding = &hidingFS{FS: rootFS}
+			rootFS = p.hiding
+			fsCfg = fsCfg.(sysfs.FSConfig).WithSysFSMount(rootFS, "/")
+		}
 	}
-	if cfg.Stdout != nil {
-		modCfg = modCfg.WithStdout(cfg.Stdout)
+	sysFSCfg, ok := fsCfg.(sysfs.FSConfig)
+	if !ok {
+		return nil, errors.New("FSConfig does not support mounting the scratch filesystem")
+	}
+	if err := p.descSets.WriteFile(wellKnownTypesFile, wellKnownTypes()); err != nil {
+		return nil, err
 	}
-	if cfg.Stderr != nil {
-		modCfg = modCfg.WithStderr(cfg.Stderr)
+	fsCfg = sysFSCfg.WithSysFSMount(p.scratch.sysFS(), scratchDir)
+	fsCfg = fsCfg.(sysfs.FSConfig).WithSysFSMount(&sysfs.ReadFS{FS: p.descSets.sysFS()}, descriptorSetsDir)
+	if len(cfg.PreludeFiles) != 0 {
+		p.prelude = newMemFS()
+		p.prelude.fixedModTime = cfg.FixedModTime
+		for name, data := range cfg.PreludeFiles {
+			if err := p.prelude.WriteFile(name, data); err != nil {
+				return nil, fmt.Errorf("prelude file %s: %w", name, err)
+			}
+		}
+		fsCfg = fsCfg.(sysfs.FSConfig).WithSysFSMount(&sysfs.ReadFS{FS: p.prelude.sysFS()}, preludeDir)
 	}
+	modCfg = modCfg.WithFSConfig(fsCfg)
 
-	if cfg.FSConfig != nil {
-		modCfg = modCfg.WithFSConfig(cfg.FSConfig)
-	} else if cfg.FS != nil {
-		modCfg = modCfg.WithFSConfig(wazero.NewFSConfig().WithFSMount(cfg.FS, "/"))
+	p.compiled = compiled
+	p.modCfg = modCfg
+	if err := p.instantiate(ctx); err != nil {
+		return nil, err
 	}
 
+	return p, nil
+}
+
+// instantiate instantiates the protoc module and resolves its exports.
+func (p *Protoc) instantiate(ctx context.Context) error {
 	// Instantiate the module (reactor mode - no _start)
-	mod, err := r.InstantiateModule(ctx, compiled, modCfg)
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, p.modCfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate module: %w", err)
+		return fmt.Errorf("failed to instantiate module: %w", err)
 	}
 
 	// Call _initialize if present
 	if initFn := mod.ExportedFunction("_initialize"); initFn != nil {
 		if _, err := initFn.Call(ctx); err != nil {
 			mod.Close(ctx)
-			return nil, fmt.Errorf("_initialize failed: %w", err)
+			return fmt.Errorf("_initialize failed: %w", p.symbolicate(err))
 		}
 	}
 
 	p.mod = mod
 	p.malloc = mod.ExportedFunction(ExportMalloc)
 	p.free = mod.ExportedFunction(ExportFree)
+	p.realloc = mod.ExportedFunction(ExportRealloc)
+	p.argvBuf, p.argvCap = 0, 0
 	p.protocInit = mod.ExportedFunction(ExportProtocInit)
 	p.protocRun = mod.ExportedFunction(ExportProtocRun)
 	p.protocDestroy = mod.ExportedFunction(ExportProtocDestroy)
 
 	// Validate required exports
-	if p.malloc == nil {
-		mod.Close(ctx)
-		return nil, errors.New("missing export: " + ExportMalloc)
+	for name, fn := range map[string]api.Function{
+		ExportMalloc:        p.malloc,
+		ExportFree:          p.free,
+		ExportRealloc:       p.realloc,
+		ExportProtocInit:    p.protocInit,
+		ExportProtocRun:     p.protocRun,
+		ExportProtocDestroy: p.protocDestroy,
+	} {
+		if fn == nil {
+			mod.Close(ctx)
+			p.mod = nil
+			return errors.New("missing export: " + name)
+		}
 	}
-	if p.free == nil {
+
+	counting, err := countsInstructions(ctx, p.malloc, p.free)
+	if err != nil {
 		mod.Close(ctx)
-		return nil, errors.New("missing export: " + ExportFree)
+		p.mod = nil
+		return fmt.Errorf("failed to probe instruction counting: %w", p.symbolicate(err))
 	}
-	if p.protocInit == nil {
-		mod.Close(ctx)
-		return nil, errors.New("missing export: " + ExportProtocInit)
+	p.instructionCounting = counting
+	return nil
+}
+
+// reinstantiate replaces the module instance with a fresh one, initializing
+// it if the previous instance was initialized.
+func (p *Protoc) reinstantiate(ctx context.Context) error {
+	if err := p.mod.Close(ctx); err != nil {
+		return err
 	}
-	if p.protocRun == nil {
-		mod.Close(ctx)
-		return nil, errors.New("missing export: " + ExportProtocRun)
+	if err := p.instantiate(ctx); err != nil {
+		p.initialized = false
+		return err
 	}
-	if p.protocDestroy == nil {
-		mod.Close(ctx)
-		return nil, errors.New("missing export: " + ExportProtocDestroy)
+	if !p.initialized {
+		return nil
 	}
-
-	return p, nil
+	results, err := p.protocInit.Call(ctx)
+	if err != nil {
+		p.initialized = false
+		return fmt.Errorf("protoc_init failed: %w", p.symbolicate(err))
+	}
+	if int32(results[0]) != 0 {
+		p.initialized = false
+		return errors.New("protoc_init returned error")
+	}
+	return nil
 }
 
 // hostPluginCommunicate handles plugin subprocess communication from WASM.
@@ -256,7 +695,23 @@ func (p *Protoc) hostPluginCommunicate(ctx context.Context, mod api.Module, stac
 	}
 
 	// Call the plugin handler
+	p.stale = true
+	start := time.Now()
 	output, err := p.pluginHandler.Communicate(ctx, program, searchPath, inputData)
+	p.endPhase(PhasePlugin, start)
+	if p.pluginInvocations != nil {
+		invocation := PluginInvocation{Program: program, Duration: time.Since(start)}
+		if err != nil {
+			invocation.Error = err.Error()
+		}
+		*p.pluginInvocations = append(*p.pluginInvocations, invocation)
+	}
+	if err == nil {
+		p.pluginOutputBytes += len(output)
+		if p.maxOutputBytes > 0 && p.pluginOutputBytes > p.maxOutputBytes {
+			err = ErrOutputTooLarge
+		}
+	}
 	if err != nil {
 		// Write error message
 		errMsg := err.Error()
@@ -293,10 +748,13 @@ func (p *Protoc) Init(ctx context.Context) error {
 	if p.initialized {
 		return nil
 	}
+	if p.maxInstructions > 0 && !p.instructionCounting {
+		return ErrNoInstructionCounting
+	}
 
 	results, err := p.protocInit.Call(ctx)
 	if err != nil {
-		return fmt.Errorf("protoc_init failed: %w", err)
+		return fmt.Errorf("protoc_init failed: %w", p.symbolicate(err))
 	}
 	if int32(results[0]) != 0 {
 		return errors.New("protoc_init returned error")
@@ -309,57 +767,164 @@ func (p *Protoc) Init(ctx context.Context) error {
 // Run runs protoc with the given arguments.
 // Init() must be called first.
 // Returns the protoc exit code (0 on success).
+// Returns ErrOutputTooLarge if Config.MaxTotalOutputBytes was exceeded.
 func (p *Protoc) Run(ctx context.Context, args []string) (int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if !p.initialized {
-		return 1, errors.New("protoc not initialized, call Init() first")
+	return p.run(ctx, args)
+}
+
+// runCapture runs protoc and returns the captured stdout and stderr.
+// Output is still forwarded to the configured writers. p.mu must be held.
+func (p *Protoc) runCapture(ctx context.Context, args []string) (int, []byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	p.stdout.buf, p.stderr.buf = &stdout, &stderr
+	defer func() {
+		p.stdout.buf, p.stderr.buf = nil, nil
+	}()
+
+	exitCode, err := p.run(ctx, args)
+	if p.normalizeOutput {
+		return exitCode, bytes.ToValidUTF8(stdout.Bytes(), []byte("\uFFFD")), normalizeDiagnostics(stderr.Bytes()), err
 	}
+	return exitCode, stdout.Bytes(), stderr.Bytes(), err
+}
 
-	if len(args) == 0 {
-		args = []string{"protoc"}
+// run runs protoc with the given arguments. p.mu must be held.
+func (p *Protoc) run(ctx context.Context, args []string) (int, error) {
+	args = p.argv(args)
+	p.lastArgs = args
+	if err := p.beginRun(ctx, args); err != nil {
+		return 1, err
 	}
 
-	// Allocate argv
-	argc := len(args)
-	argPtrs := make([]uint32, argc)
+	argPtrs, err := p.allocStrings(ctx, args)
+	if err != nil {
+		return 1, err
+	}
+	defer p.freeArgs(ctx, argPtrs, 0)
+	argvPtr, err := p.argvBuffer(ctx, argPtrs)
+	if err != nil {
+		return 1, err
+	}
 
-	for i, arg := range args {
-		ptr, err := p.allocString(ctx, arg)
-		if err != nil {
-			// Free already allocated
-			for j := 0; j < i; j++ {
-				p.freePtr(ctx, argPtrs[j])
-			}
-			return 1, err
-		}
-		argPtrs[i] = ptr
+	return p.callRun(ctx, len(args), argvPtr)
+}
+
+// LastStderr returns the last Config.StderrTailLines lines protoc wrote to
+// stderr during the most recent run. It returns nil if StderrTailLines is
+// not set.
+func (p *Protoc) LastStderr() []byte {
+	if p.stderrTail == nil {
+		return nil
 	}
+	return p.stderrTail.bytes()
+}
 
-	// Allocate argv array
-	argvPtr, err := p.allocArgv(ctx, argPtrs)
-	if err != nil {
-		for _, ptr := range argPtrs {
-			p.freePtr(ctx, ptr)
+// argv returns args with argv[0] replaced by Config.ProgramName, if set,
+// and relative output paths resolved against Config.WorkingDir.
+// Empty args default to just the program name.
+func (p *Protoc) argv(args []string) []string {
+	if len(args) == 0 {
+		if p.programName == "" {
+			return []string{"protoc"}
 		}
-		return 1, err
+		return []string{p.programName}
 	}
+	if p.programName != "" && args[0] != p.programName {
+		args = append([]string{p.programName}, args[1:]...)
+	}
+	return p.withWorkingDir(args)
+}
 
-	// Call protoc_run
-	results, err := p.protocRun.Call(ctx, uint64(argc), uint64(argvPtr))
+// beginRun checks that protoc is ready to run args, replacing the module
+// instance if needed. p.mu must be held.
+func (p *Protoc) beginRun(ctx context.Context, args []string) error {
+	if !p.initialized {
+		return errors.New("protoc not initialized, call Init() first")
+	}
 
-	// Free memory
-	p.freePtr(ctx, argvPtr)
-	for _, ptr := range argPtrs {
-		p.freePtr(ctx, ptr)
+	// The protoc CLI does not clear plugins, plugin and generator options or
+	// the error format between runs, so use a fresh instance after a run that
+	// set any of them.
+	if p.stale {
+		if err := p.reinstantiate(ctx); err != nil {
+			return err
+		}
+		p.stale = false
 	}
+	p.stale = setsPersistentState(args)
+	return nil
+}
 
+// callRun calls protoc_run with an argv allocated in guest memory.
+// p.mu must be held.
+func (p *Protoc) callRun(ctx context.Context, argc int, argvPtr uint32) (int, error) {
+	if p.maxInstructions > 0 && !p.instructionCounting {
+		// Without counting the run would not be limited at all.
+		return 1, ErrNoInstructionCounting
+	}
+	p.pluginOutputBytes = 0
+	p.scratch.resetExceeded()
+	if p.rootFS != nil {
+		p.rootFS.resetExceeded()
+	}
+	if p.resolver != nil {
+		p.resolver.reset()
+	}
+	if p.stderrTail != nil {
+		p.stderrTail.reset()
+	}
+	if p.diagWriter != nil {
+		p.diagWriter.reset()
+		defer p.diagWriter.flush()
+	}
+	// Keep the stderr of the run for an InterruptedError.
+	if p.stderr.buf == nil {
+		var stderr bytes.Buffer
+		p.stderr.buf = &stderr
+		defer func() { p.stderr.buf = nil }()
+	}
+	if p.maxInstructions > 0 {
+		ctx, _ = withBudget(ctx, p.maxInstructions)
+	}
+	start := time.Now()
+	results, err := p.protocRun.Call(ctx, uint64(argc), uint64(argvPtr))
+	p.endPhase(PhaseRun, start)
+	if errors.Is(err, ErrBudgetExceeded) {
+		// The run was aborted midway, leaving the instance inconsistent.
+		p.stale = true
+		return 1, ErrBudgetExceeded
+	}
+	if err != nil && ctx.Err() != nil {
+		// The runtime closed the module when the context was done.
+		p.stale = true
+		return 1, &InterruptedError{Err: ctx.Err(), PartialStderr: bytes.Clone(p.stderr.buf.Bytes())}
+	}
 	if err != nil {
-		return 1, fmt.Errorf("protoc_run failed: %w", err)
+		return 1, fmt.Errorf("protoc_run failed: %w", p.symbolicate(err))
 	}
 
-	return int(int32(results[0])), nil
+	exitCode := int(int32(results[0]))
+	if p.scratch.limitExceeded() || (p.rootFS != nil && p.rootFS.limitExceeded()) ||
+		(p.maxOutputBytes > 0 && p.pluginOutputBytes > p.maxOutputBytes) {
+		return exitCode, ErrOutputTooLarge
+	}
+	return exitCode, nil
+}
+
+// setsPersistentState reports whether args set CLI state that protoc does
+// not clear between runs. Plugin output flags are detected when the plugin
+// is invoked.
+func setsPersistentState(args []string) bool {
+	for _, arg := range args[1:] {
+		name, _, _ := strings.Cut(arg, "=")
+		if name == "--plugin" || name == "--error_format" || strings.HasSuffix(name, "_opt") {
+			return true
+		}
+	}
+	return false
 }
 
 // Close destroys the protoc reactor and releases resources.
@@ -373,6 +938,8 @@ func (p *Protoc) Close(ctx context.Context) error {
 	}
 
 	if p.mod != nil {
+		p.freePtr(ctx, p.argvBuf)
+		p.argvBuf, p.argvCap = 0, 0
 		return p.mod.Close(ctx)
 	}
 	return nil
@@ -380,6 +947,46 @@ func (p *Protoc) Close(ctx context.Context) error {
 
 // Memory helpers
 
+// allocArgs allocates args as null-terminated strings and an argv array
+// pointing to them.
+func (p *Protoc) allocArgs(ctx context.Context, args []string) ([]uint32, uint32, error) {
+	argPtrs, err := p.allocStrings(ctx, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Allocate argv array
+	argvPtr, err := p.allocArgv(ctx, argPtrs)
+	if err != nil {
+		p.freeArgs(ctx, argPtrs, 0)
+		return nil, 0, err
+	}
+	return argPtrs, argvPtr, nil
+}
+
+// allocStrings allocates args as null-terminated strings.
+func (p *Protoc) allocStrings(ctx context.Context, args []string) ([]uint32, error) {
+	argPtrs := make([]uint32, len(args))
+	for i, arg := range args {
+		ptr, err := p.allocString(ctx, arg)
+		if err != nil {
+			// Free already allocated
+			p.freeArgs(ctx, argPtrs[:i], 0)
+			return nil, err
+		}
+		argPtrs[i] = ptr
+	}
+	return argPtrs, nil
+}
+
+// freeArgs frees memory allocated by allocArgs.
+func (p *Protoc) freeArgs(ctx context.Context, argPtrs []uint32, argvPtr uint32) {
+	p.freePtr(ctx, argvPtr)
+	for _, ptr := range argPtrs {
+		p.freePtr(ctx, ptr)
+	}
+}
+
 func (p *Protoc) allocString(ctx context.Context, s string) (uint32, error) {
 	bytes := append([]byte(s), 0) // null-terminated
 	return p.allocBytes(ctx, bytes)
@@ -394,8 +1001,11 @@ func (p *Protoc) allocBytes(ctx context.Context, data []byte) (uint32, error) {
 	if ptr == 0 {
 		return 0, errors.New("malloc returned null")
 	}
+	if p.allocObserver != nil {
+		p.allocObserver.OnMalloc(uint32(len(data)), ptr)
+	}
 	if !p.mod.Memory().Write(ptr, data) {
-		p.free.Call(ctx, uint64(ptr))
+		p.freePtr(ctx, ptr)
 		return 0, errors.New("failed to write to memory")
 	}
 	return ptr, nil
@@ -411,18 +1021,61 @@ func (p *Protoc) allocArgv(ctx context.Context, ptrs []uint32) (uint32, error) {
 	if argvPtr == 0 {
 		return 0, errors.New("malloc returned null for argv")
 	}
-
-	for i, ptr := range ptrs {
-		ptrBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(ptrBytes, ptr)
-		p.mod.Memory().Write(argvPtr+uint32(i*4), ptrBytes)
+	if p.allocObserver != nil {
+		p.allocObserver.OnMalloc(uint32(size), argvPtr)
 	}
 
+	if err := p.writeArgv(argvPtr, ptrs); err != nil {
+		p.freePtr(ctx, argvPtr)
+		return 0, err
+	}
 	return argvPtr, nil
 }
 
+// argvBuffer writes an argv array pointing to ptrs to the buffer retained
+// on the instance, growing it with realloc when needed, so that repeated
+// runs with long file lists don't allocate a new array every time.
+func (p *Protoc) argvBuffer(ctx context.Context, ptrs []uint32) (uint32, error) {
+	if size := len(ptrs) * 4; size > p.argvCap {
+		size = max(size, 2*p.argvCap)
+		results, err := p.realloc.Call(ctx, uint64(p.argvBuf), uint64(size))
+		if err != nil {
+			return 0, err
+		}
+		argvPtr := uint32(results[0])
+		if argvPtr == 0 {
+			return 0, errors.New("realloc returned null for argv")
+		}
+		if p.allocObserver != nil {
+			p.allocObserver.OnRealloc(p.argvBuf, uint32(size), argvPtr)
+		}
+		p.argvBuf, p.argvCap = argvPtr, size
+	}
+
+	if err := p.writeArgv(p.argvBuf, ptrs); err != nil {
+		return 0, err
+	}
+	return p.argvBuf, nil
+}
+
+// writeArgv writes ptrs to the argv array at argvPtr, in place through a
+// view of guest memory.
+func (p *Protoc) writeArgv(argvPtr uint32, ptrs []uint32) error {
+	buf, ok := p.mod.Memory().Read(argvPtr, uint32(len(ptrs)*4))
+	if !ok {
+		return errors.New("failed to write argv to memory")
+	}
+	for i, ptr := range ptrs {
+		binary.LittleEndian.PutUint32(buf[i*4:], ptr)
+	}
+	return nil
+}
+
 func (p *Protoc) freePtr(ctx context.Context, ptr uint32) {
 	if ptr != 0 {
+		if p.allocObserver != nil {
+			p.allocObserver.OnFree(ptr)
+		}
 		p.free.Call(ctx, uint64(ptr))
 	}
 }
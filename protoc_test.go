@@ -3,13 +3,24 @@ package protoc
 import (
 	"bytes"
 	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
 
 	"github.com/tetratelabs/wazero"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
 )
 
 func TestProtocVersion(t *testing.T) {
@@ -315,3 +326,358 @@ message Person {
 		})
 	}
 }
+
+// testCompilationCache shares compiled modules between tests.
+var testCompilationCache = wazero.NewCompilationCache()
+
+//go:embed testdata/protos
+var testProtos embed.FS
+
+func TestProtocEmbedFS(t *testing.T) {
+	ctx := context.Background()
+	sub, err := fs.Sub(testProtos, "testdata/protos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name         string
+		fsys         fs.FS
+		includePaths []string
+		file         string
+	}{
+		{name: "Prefixed", fsys: testProtos, includePaths: []string{"/testdata/protos"}, file: "/testdata/protos/example/v1/greeter.proto"},
+		{name: "Sub", fsys: sub, file: "example/v1/greeter.proto"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProtoc(t, &Config{FS: tt.fsys})
+
+			data, err := p.Compile(ctx, CompileOptions{
+				IncludePaths:   tt.includePaths,
+				Files:          []string{tt.file},
+				IncludeImports: true,
+			})
+			if err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			var set descriptorpb.FileDescriptorSet
+			if err := proto.Unmarshal(data, &set); err != nil {
+				t.Fatalf("invalid descriptor set: %v", err)
+			}
+			var names []string
+			for _, file := range set.GetFile() {
+				names = append(names, file.GetName())
+			}
+			expected := []string{"example/v1/types.proto", "example/v1/greeter.proto"}
+			if !slices.Equal(names, expected) {
+				t.Errorf("expected files %v, got %v", expected, names)
+			}
+		})
+	}
+}
+
+// newTestProtoc creates an initialized Protoc that is closed when the test
+// finishes.
+func newTestProtoc(t testing.TB, cfg *Config) *Protoc {
+	t.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	t.Cleanup(func() { r.Close(ctx) })
+
+	p, err := NewProtoc(ctx, r, cfg)
+	if err != nil {
+		t.Fatalf("NewProtoc failed: %v", err)
+	}
+	t.Cleanup(func() { p.Close(ctx) })
+
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	return p
+}
+
+// fooProto is the source of foo.proto, the file compiled by tests that only
+// need something to compile.
+const fooProto = `syntax = "proto3"; message Foo {}`
+
+// fooProtoFS returns a filesystem holding foo.proto.
+func fooProtoFS() fstest.MapFS {
+	return fstest.MapFS{"foo.proto": &fstest.MapFile{Data: []byte(fooProto)}}
+}
+
+// writableFooProtoFS returns a *MemFS holding foo.proto and the directories
+// dirs, for tests reading the files protoc writes.
+func writableFooProtoFS(t testing.TB, dirs ...string) *MemFS {
+	t.Helper()
+	memFS, err := NewWritableMapFS(map[string][]byte{"foo.proto": []byte(fooProto)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if err := memFS.MkdirAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return memFS
+}
+
+// fakePlugin returns an in-process plugin generating the file name with
+// content.
+func fakePlugin(name, content string) PluginHandler {
+	return fakePluginFiles(map[string]string{name: content})
+}
+
+// fakePluginFiles returns an in-process plugin generating files, keyed by
+// name.
+func fakePluginFiles(files map[string]string) PluginHandler {
+	return PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		resp := &pluginpb.CodeGeneratorResponse{}
+		for _, name := range slices.Sorted(maps.Keys(files)) {
+			resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+				Name:    proto.String(name),
+				Content: proto.String(files[name]),
+			})
+		}
+		return proto.Marshal(resp)
+	})
+}
+
+func TestProtocMaxTotalOutputBytes(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"test.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+
+message Person {
+  string name = 1;
+}
+`)},
+	}
+
+	// A fake generator emitting a file larger than the limit.
+	fake := fakePlugin("huge.txt", strings.Repeat("x", 8192))
+
+	p := newTestProtoc(t, &Config{
+		FS:                  memFS,
+		PluginHandler:       fake,
+		MaxTotalOutputBytes: 4096,
+	})
+
+	_, err := p.RunGenerators(ctx, nil, []string{"test.proto"}, []GeneratorSpec{{Name: "fake"}})
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge from plugin output, got: %v", err)
+	}
+
+	// Files written by built-in generators count towards the limit too.
+	_, err = p.RunGenerators(ctx, nil, []string{"test.proto"}, []GeneratorSpec{{Name: "cpp"}})
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge from written files, got: %v", err)
+	}
+
+	// Output within the limit is unaffected.
+	outputs, err := p.RunGenerators(ctx, nil, []string{"test.proto"}, []GeneratorSpec{{Name: "python"}})
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if len(outputs["python"]) != 1 {
+		t.Errorf("unexpected python outputs: %v", keys(outputs["python"]))
+	}
+}
+
+func TestProtocPluginParameter(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "test.proto"), []byte(`
+syntax = "proto3";
+package test;
+
+message Person {
+  string name = 1;
+}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "out"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// An in-process generator echoing the received parameter.
+	var program string
+	echo := PluginHandlerFunc(func(ctx context.Context, prog string, searchPath bool, input []byte) ([]byte, error) {
+		program = prog
+		var req pluginpb.CodeGeneratorRequest
+		if err := proto.Unmarshal(input, &req); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(&pluginpb.CodeGeneratorResponse{
+			File: []*pluginpb.CodeGeneratorResponse_File{{
+				Name:    proto.String("parameter.txt"),
+				Content: proto.String(req.GetParameter()),
+			}},
+		})
+	})
+
+	p := newTestProtoc(t, &Config{
+		FSConfig:      wazero.NewFSConfig().WithDirMount(root, "/"),
+		PluginHandler: echo,
+	})
+
+	exitCode, err := p.Run(ctx, []string{
+		"protoc",
+		"--go_out=module=example.com/foo:/out",
+		"-I/",
+		"test.proto",
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("protoc exited with code %d", exitCode)
+	}
+	if program != "protoc-gen-go" {
+		t.Errorf("expected plugin protoc-gen-go, got %q", program)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "out", "parameter.txt"))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if string(data) != "module=example.com/foo" {
+		t.Errorf("expected parameter %q, got %q", "module=example.com/foo", data)
+	}
+}
+
+func TestDefaultPluginHandlerMaxConcurrentPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin requires a POSIX shell")
+	}
+	ctx := context.Background()
+
+	// A slow fake plugin recording how many instances are running.
+	dir := t.TempDir()
+	running := filepath.Join(dir, "running")
+	if err := os.Mkdir(running, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	counts := filepath.Join(dir, "counts")
+	plugin := filepath.Join(dir, "protoc-gen-slow")
+	script := "#!/bin/sh\n" +
+		"cat >/dev/null\n" +
+		"touch '" + running + "/'$$\n" +
+		"ls '" + running + "' | wc -l >> '" + counts + "'\n" +
+		"sleep 0.2\n" +
+		"rm '" + running + "/'$$\n"
+	if err := os.WriteFile(plugin, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxConcurrent = 2
+	h := &DefaultPluginHandler{MaxConcurrentPlugins: maxConcurrent}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.Communicate(ctx, plugin, false, []byte("request")); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Communicate failed: %v", err)
+	}
+
+	data, err := os.ReadFile(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) != cap(errs) {
+		t.Fatalf("expected %d plugin runs, got %d", cap(errs), len(lines))
+	}
+	for _, line := range lines {
+		if n, err := strconv.Atoi(line); err != nil || n > maxConcurrent {
+			t.Errorf("expected at most %d concurrent plugins, got %s", maxConcurrent, line)
+		}
+	}
+}
+
+func TestProtocProgramName(t *testing.T) {
+	ctx := context.Background()
+	var stdout bytes.Buffer
+	p := newTestProtoc(t, &Config{Stdout: &stdout, ProgramName: "mytool"})
+
+	// Without arguments protoc prints its usage, naming argv[0].
+	if _, err := p.Run(ctx, []string{"protoc"}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Usage: mytool [OPTION]") {
+		t.Errorf("expected usage naming mytool, got: %s", stdout.String())
+	}
+
+	// The remaining arguments are unaffected.
+	stdout.Reset()
+	exitCode, err := p.Run(ctx, []string{"protoc", "--version"})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if exitCode != 0 || !strings.HasPrefix(stdout.String(), "libprotoc") {
+		t.Errorf("expected version output, got exit code %d: %s", exitCode, stdout.String())
+	}
+}
+
+func TestProtocStripPathPrefix(t *testing.T) {
+	ctx := context.Background()
+	tree := fstest.MapFS{
+		"README.md":       &fstest.MapFile{Data: []byte("# repo\n")},
+		"proto/foo.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package foo; message Foo {}`)},
+		"proto/bar.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; package foo; import "foo.proto"; message Bar { Foo foo = 1; }`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: tree, StripPathPrefix: "/proto/"})
+
+	data, err := p.Compile(ctx, CompileOptions{Files: []string{"bar.proto"}, IncludeImports: true})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range set.GetFile() {
+		names = append(names, file.GetName())
+	}
+	if !slices.Equal(names, []string{"foo.proto", "bar.proto"}) {
+		t.Errorf("expected foo.proto and bar.proto, got %v", names)
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache))
+	defer r.Close(ctx)
+	if _, err := NewProtoc(ctx, r, &Config{FS: tree, StripPathPrefix: "../proto"}); err == nil {
+		t.Error("expected error for invalid prefix")
+	}
+	if _, err := NewProtoc(ctx, r, &Config{StripPathPrefix: "proto"}); err == nil {
+		t.Error("expected error without FS")
+	}
+
+	// Invalid prefixes are rejected before r is modified, and "/" is the
+	// root of FS.
+	p, err = NewProtoc(ctx, r, &Config{FS: tree, StripPathPrefix: "/"})
+	if err != nil {
+		t.Fatalf("NewProtoc failed: %v", err)
+	}
+	defer p.Close(ctx)
+	if err := p.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"proto/foo.proto"}}); err != nil {
+		t.Errorf("Compile failed: %v", err)
+	}
+}
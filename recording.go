@@ -0,0 +1,113 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// PluginInteraction is a recorded plugin invocation.
+type PluginInteraction struct {
+	// Program is the plugin program name, e.g. "protoc-gen-go".
+	Program string `json:"program"`
+	// Input is the serialized CodeGeneratorRequest.
+	Input []byte `json:"input"`
+	// Output is the serialized CodeGeneratorResponse, if the plugin
+	// succeeded.
+	Output []byte `json:"output,omitempty"`
+	// Error is the error message, if the plugin failed.
+	Error string `json:"error,omitempty"`
+}
+
+// RecordingPluginHandler forwards plugin invocations to Handler and records
+// them, so that they can be saved with WriteFile and served by a
+// ReplayPluginHandler in tests without the plugin binaries.
+type RecordingPluginHandler struct {
+	// Handler handles the recorded invocations.
+	// Default: DefaultPluginHandler.
+	Handler PluginHandler
+
+	mu           sync.Mutex
+	interactions []PluginInteraction
+}
+
+// Communicate forwards the invocation to Handler and records it.
+func (h *RecordingPluginHandler) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	handler := h.Handler
+	if handler == nil {
+		handler = &DefaultPluginHandler{}
+	}
+	output, err := handler.Communicate(ctx, program, searchPath, input)
+
+	interaction := PluginInteraction{
+		Program: program,
+		Input:   bytes.Clone(input),
+		Output:  bytes.Clone(output),
+	}
+	if err != nil {
+		interaction.Output = nil
+		interaction.Error = err.Error()
+	}
+	h.mu.Lock()
+	h.interactions = append(h.interactions, interaction)
+	h.mu.Unlock()
+	return output, err
+}
+
+// Interactions returns the interactions recorded so far, in order.
+func (h *RecordingPluginHandler) Interactions() []PluginInteraction {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]PluginInteraction(nil), h.interactions...)
+}
+
+// WriteFile saves the interactions recorded so far to the named file as
+// JSON, for loading with ReadPluginInteractions.
+func (h *RecordingPluginHandler) WriteFile(name string) error {
+	data, err := json.MarshalIndent(h.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// ReadPluginInteractions loads interactions saved by
+// RecordingPluginHandler.WriteFile.
+func ReadPluginInteractions(name string) ([]PluginInteraction, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []PluginInteraction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, err
+	}
+	return interactions, nil
+}
+
+// ReplayPluginHandler serves recorded plugin interactions without running
+// any plugin. An invocation is answered by the first interaction with the
+// same program and input, so replays are deterministic as long as the
+// compiled files and generator parameters are unchanged.
+type ReplayPluginHandler struct {
+	// Interactions are the recorded interactions to serve.
+	Interactions []PluginInteraction
+}
+
+// Communicate returns the recorded result of the invocation, or an error if
+// none was recorded.
+func (h *ReplayPluginHandler) Communicate(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+	for _, interaction := range h.Interactions {
+		if interaction.Program != program || !bytes.Equal(interaction.Input, input) {
+			continue
+		}
+		if interaction.Error != "" {
+			return nil, errors.New(interaction.Error)
+		}
+		return interaction.Output, nil
+	}
+	return nil, errors.New("no recorded interaction for plugin " + program)
+}
@@ -0,0 +1,59 @@
+package protoc
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplayPluginInteractions(t *testing.T) {
+	ctx := context.Background()
+	memFS := fooProtoFS()
+
+	calls := 0
+	fakeGo := &MiddlewarePluginHandler{
+		Handler: fakePlugin("foo.pb.go", "package foo\n"),
+		Before: func(ctx context.Context, program string, input []byte) ([]byte, error) {
+			calls++
+			return input, nil
+		},
+	}
+	gens := []GeneratorSpec{{Name: "go"}}
+
+	recorder := &RecordingPluginHandler{Handler: fakeGo}
+	p := newTestProtoc(t, &Config{FS: memFS, PluginHandler: recorder})
+	recorded, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, gens)
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if n := len(recorder.Interactions()); n != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", n)
+	}
+	name := filepath.Join(t.TempDir(), "plugins.json")
+	if err := recorder.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	interactions, err := ReadPluginInteractions(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = newTestProtoc(t, &Config{FS: memFS, PluginHandler: &ReplayPluginHandler{Interactions: interactions}})
+	replayed, err := p.RunGenerators(ctx, nil, []string{"foo.proto"}, gens)
+	if err != nil {
+		t.Fatalf("RunGenerators failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the plugin to run once, ran %d times", calls)
+	}
+	if string(replayed["go"]["foo.pb.go"]) != string(recorded["go"]["foo.pb.go"]) {
+		t.Errorf("replayed output %q differs from recorded %q", replayed["go"]["foo.pb.go"], recorded["go"]["foo.pb.go"])
+	}
+
+	// Unrecorded invocations fail.
+	_, err = p.RunGenerators(ctx, nil, []string{"foo.proto"}, []GeneratorSpec{{Name: "go", Params: []string{"paths=source_relative"}}})
+	if err == nil || !strings.Contains(err.Error(), "no recorded interaction") {
+		t.Errorf("expected missing recording error, got: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report summarizes a protoc run for build tooling.
+type Report struct {
+	// ExitCode is the protoc exit code.
+	ExitCode int `json:"exit_code"`
+	// Diagnostics are the errors and warnings protoc reported.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// OutputFiles are the files written to Config.FS during the run, sorted
+	// by path. It is only reported if Config.FS is a *MemFS.
+	OutputFiles []string `json:"output_files,omitempty"`
+	// Plugins are the plugin invocations in the order they finished.
+	Plugins []PluginInvocation `json:"plugins,omitempty"`
+	// PluginTimings is the total duration of the invocations of each
+	// plugin, keyed by program name, to find slow generators.
+	PluginTimings map[string]time.Duration `json:"plugin_timings,omitempty"`
+	// Duration is the duration of the run.
+	Duration time.Duration `json:"duration"`
+	// FinalArgs are the arguments protoc was run with, after
+	// Config.ProgramName was applied, to reproduce the invocation.
+	FinalArgs []string `json:"final_args"`
+}
+
+// PluginInvocation describes a plugin invoked during a run.
+type PluginInvocation struct {
+	// Program is the plugin program name, e.g. "protoc-gen-go".
+	Program string `json:"program"`
+	// Duration is the duration of the invocation.
+	Duration time.Duration `json:"duration"`
+	// Error is the error message, if the plugin failed.
+	Error string `json:"error,omitempty"`
+}
+
+// RunReport runs protoc with the given arguments like Run and returns a
+// report of the run: the exit code, the parsed diagnostics, the files
+// written and the plugins invoked. Output is still forwarded to the
+// configured writers.
+//
+// The error is only non-nil if protoc could not be run. Init() must be
+// called first.
+func (p *Protoc) RunReport(ctx context.Context, args []string) (Report, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var plugins []PluginInvocation
+	p.pluginInvocations = &plugins
+	defer func() { p.pluginInvocations = nil }()
+
+	start := time.Now()
+	exitCode, _, stderr, err := p.runCapture(ctx, args)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{
+		ExitCode:    exitCode,
+		Diagnostics: ParseDiagnostics(stderr),
+		Plugins:     plugins,
+		Duration:    time.Since(start),
+		FinalArgs:   slices.Clone(p.lastArgs),
+	}
+	if len(plugins) != 0 {
+		report.PluginTimings = make(map[string]time.Duration)
+		for _, plugin := range plugins {
+			report.PluginTimings[plugin.Program] += plugin.Duration
+		}
+	}
+	if p.rootFS != nil {
+		report.OutputFiles = p.rootFS.modifiedSince(start)
+	}
+	return report, nil
+}
+
+// RunProducedOutput runs protoc with the given arguments like Run and
+// reports whether any file was written under outputDir, a guest path such
+// as "/out", together with the exit code. A successful run that produced
+// nothing usually means a misconfigured generator. Config.FS must be a
+// *MemFS.
+//
+// The error is only non-nil if protoc could not be run. Init() must be
+// called first.
+func (p *Protoc) RunProducedOutput(ctx context.Context, args []string, outputDir string) (bool, int, error) {
+	if p.rootFS == nil {
+		return false, 1, errors.New("RunProducedOutput requires Config.FS to be a *MemFS")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := time.Now()
+	exitCode, err := p.run(ctx, args)
+	if err != nil {
+		return false, exitCode, err
+	}
+	dir := strings.TrimPrefix(path.Clean("/"+outputDir), "/")
+	for _, name := range p.rootFS.modifiedSince(start) {
+		if dir == "" || strings.HasPrefix(name, dir+"/") {
+			return true, exitCode, nil
+		}
+	}
+	return false, exitCode, nil
+}
+
+// modifiedSince returns the paths of the regular files modified at or
+// after t, sorted.
+func (m *MemFS) modifiedSince(t time.Time) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var names []string
+	var walk func(prefix string, n *memNode)
+	walk = func(prefix string, n *memNode) {
+		for name, child := range n.children {
+			p := path.Join(prefix, name)
+			if child.mode.IsDir() {
+				walk(p, child)
+			} else if !child.modTime.Before(t) {
+				names = append(names, p)
+			}
+		}
+	}
+	walk("", m.root)
+	sort.Strings(names)
+	return names
+}
+
+// CIResult is the outcome of CompileForCI.
+type CIResult struct {
+	// ExitCode is the protoc exit code.
+	ExitCode int `json:"exit_code"`
+	// Errors are the errors protoc reported.
+	Errors []Diagnostic `json:"errors,omitempty"`
+	// Warnings are the warnings protoc reported.
+	Warnings []Diagnostic `json:"warnings,omitempty"`
+	// DescriptorSet is the encoded FileDescriptorSet of the compiled files
+	// and their imports, if the compilation succeeded.
+	DescriptorSet []byte `json:"descriptor_set,omitempty"`
+	// Files are the generated files keyed by generator name and then by
+	// path, as returned by RunGenerators, if the compilation succeeded.
+	Files map[string]map[string][]byte `json:"files,omitempty"`
+	// Duration is the duration of the compilation.
+	Duration time.Duration `json:"duration"`
+	// FinalArgs are the arguments of the last protoc run, including the
+	// flags added by the helper such as the default include path and the
+	// well-known types. Paths under /.protoc-wasi are internal mounts.
+	FinalArgs []string `json:"final_args"`
+}
+
+// CompileForCI compiles files and runs gens like Bundle, returning the
+// artifacts together with the diagnostics in a single result, for CI jobs
+// that both publish outputs and report problems. Compile failures are
+// reported in the result; the error is only non-nil if protoc could not be
+// run.
+//
+// If includePaths is empty the filesystem root is used.
+// Init() must be called first.
+func (p *Protoc) CompileForCI(ctx context.Context, includePaths, files []string, gens []GeneratorSpec) (CIResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := time.Now()
+	res, err := p.compile(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	}, gens)
+	if err != nil {
+		return CIResult{}, err
+	}
+	result := CIResult{
+		ExitCode:      res.exitCode,
+		DescriptorSet: res.descSet,
+		Duration:      time.Since(start),
+		FinalArgs:     slices.Clone(p.lastArgs),
+	}
+	if res.exitCode == 0 {
+		result.Files = p.generatorOutputs(gens)
+	}
+	for _, diag := range res.diagnostics {
+		if diag.Severity == SeverityWarning {
+			result.Warnings = append(result.Warnings, diag)
+		} else {
+			result.Errors = append(result.Errors, diag)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package protoc
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestProtocRunReport(t *testing.T) {
+	ctx := context.Background()
+	memFS := writableFooProtoFS(t, "out")
+	p := newTestProtoc(t, &Config{FS: memFS, PluginHandler: fakePlugin("foo.pb.go", "package foo\n")})
+
+	report, err := p.RunReport(ctx, []string{"protoc", "--go_out=/out", "--descriptor_set_out=/out/foo.pb", "-I/", "foo.proto"})
+	if err != nil {
+		t.Fatalf("RunReport failed: %v", err)
+	}
+	if report.ExitCode != 0 || len(report.Diagnostics) != 0 {
+		t.Fatalf("unexpected failure: %+v", report)
+	}
+	if len(report.Plugins) != 1 || report.Plugins[0].Program != "protoc-gen-go" || report.Plugins[0].Error != "" {
+		t.Errorf("expected one protoc-gen-go invocation, got %+v", report.Plugins)
+	}
+	expected := []string{"out/foo.pb", "out/foo.pb.go"}
+	if !slices.Equal(report.OutputFiles, expected) {
+		t.Errorf("expected output files %v, got %v", expected, report.OutputFiles)
+	}
+	if report.Duration <= 0 {
+		t.Errorf("expected a positive duration, got %v", report.Duration)
+	}
+	if !slices.Equal(report.FinalArgs, []string{"protoc", "--go_out=/out", "--descriptor_set_out=/out/foo.pb", "-I/", "foo.proto"}) {
+		t.Errorf("unexpected final args %v", report.FinalArgs)
+	}
+
+	report, err = p.RunReport(ctx, []string{"protoc", "-I/", "missing.proto"})
+	if err != nil {
+		t.Fatalf("RunReport failed: %v", err)
+	}
+	if report.ExitCode == 0 || len(report.Diagnostics) == 0 || len(report.OutputFiles) != 0 || len(report.Plugins) != 0 {
+		t.Errorf("unexpected report for a failed run: %+v", report)
+	}
+}
+
+func TestProtocRunReportPluginTimings(t *testing.T) {
+	ctx := context.Background()
+	memFS := writableFooProtoFS(t, "out")
+
+	// sleepingPlugin returns a plugin taking d to generate name.
+	sleepingPlugin := func(d time.Duration, name string) PluginHandler {
+		return &MiddlewarePluginHandler{
+			Handler: fakePlugin(name, "\n"),
+			Before: func(ctx context.Context, program string, input []byte) ([]byte, error) {
+				time.Sleep(d)
+				return input, nil
+			},
+		}
+	}
+	const slowDelay = 50 * time.Millisecond
+	p := newTestProtoc(t, &Config{FS: memFS, Generators: map[string]PluginHandler{
+		"fast": sleepingPlugin(0, "foo.fast"),
+		"slow": sleepingPlugin(slowDelay, "foo.slow"),
+	}})
+
+	report, err := p.RunReport(ctx, []string{"protoc", "--fast_out=/out", "--slow_out=/out", "-I/", "foo.proto"})
+	if err != nil {
+		t.Fatalf("RunReport failed: %v", err)
+	}
+	if report.ExitCode != 0 {
+		t.Fatalf("unexpected failure: %+v", report)
+	}
+	fast, ok := report.PluginTimings["protoc-gen-fast"]
+	if !ok {
+		t.Fatalf("expected timing of protoc-gen-fast, got %v", report.PluginTimings)
+	}
+	if slow := report.PluginTimings["protoc-gen-slow"]; slow < slowDelay || slow <= fast {
+		t.Errorf("expected protoc-gen-slow to take longer, got %v", report.PluginTimings)
+	}
+}
+
+func TestProtocCompileForCI(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"a.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; import "b.proto"; message A {}`)},
+		"b.proto":   &fstest.MapFile{Data: []byte(`syntax = "proto3"; message B {}`)},
+		"bad.proto": &fstest.MapFile{Data: []byte(`syntax = "proto3"; message Bad { Missing m = 1; }`)},
+	}
+	gens := []GeneratorSpec{{Name: "cpp"}}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	// The unused import is reported as a warning.
+	result, err := p.CompileForCI(ctx, nil, []string{"a.proto"}, gens)
+	if err != nil {
+		t.Fatalf("CompileForCI failed: %v", err)
+	}
+	if result.ExitCode != 0 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected failure: %+v", result)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Message != "Import b.proto is unused." {
+		t.Errorf("expected unused import warning, got %+v", result.Warnings)
+	}
+	if len(result.DescriptorSet) == 0 || len(result.Files["cpp"]) == 0 || result.Duration <= 0 {
+		t.Errorf("expected artifacts and timing, got %+v", result)
+	}
+	// The final args include the flags added by the helper.
+	if !slices.Contains(result.FinalArgs, "-I/") || !slices.Contains(result.FinalArgs, "--descriptor_set_in=/.protoc-wasi-in/wkt.pb") {
+		t.Errorf("expected default include path and well-known types in %v", result.FinalArgs)
+	}
+
+	// Compile failures are reported in the result.
+	result, err = p.CompileForCI(ctx, nil, []string{"bad.proto"}, gens)
+	if err != nil {
+		t.Fatalf("CompileForCI failed: %v", err)
+	}
+	if result.ExitCode == 0 || len(result.Errors) == 0 || result.DescriptorSet != nil || result.Files != nil {
+		t.Errorf("unexpected result for a failed compilation: %+v", result)
+	}
+}
+
+func TestProtocRunProducedOutput(t *testing.T) {
+	ctx := context.Background()
+	memFS := writableFooProtoFS(t, "out", "other")
+
+	// The plugin succeeds without generating any file.
+	empty := PluginHandlerFunc(func(ctx context.Context, program string, searchPath bool, input []byte) ([]byte, error) {
+		return proto.Marshal(&pluginpb.CodeGeneratorResponse{SupportedFeatures: proto.Uint64(0)})
+	})
+
+	p := newTestProtoc(t, &Config{FS: memFS, PluginHandler: empty})
+
+	produced, exitCode, err := p.RunProducedOutput(ctx, []string{"protoc", "--go_out=/out", "--descriptor_set_out=/other/foo.pb", "-I/", "foo.proto"}, "/out")
+	if err != nil {
+		t.Fatalf("RunProducedOutput failed: %v", err)
+	}
+	if produced || exitCode != 0 {
+		t.Errorf("expected no output and success, got %v, %d", produced, exitCode)
+	}
+
+	produced, exitCode, err = p.RunProducedOutput(ctx, []string{"protoc", "--cpp_out=/out", "-I/", "foo.proto"}, "/out")
+	if err != nil {
+		t.Fatalf("RunProducedOutput failed: %v", err)
+	}
+	if !produced || exitCode != 0 {
+		t.Errorf("expected output and success, got %v, %d", produced, exitCode)
+	}
+}
@@ -0,0 +1,101 @@
+package protoc
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// resolverFS adapts Config.Resolver to an fs.FS. Every path other than the
+// root directory is resolved as a file. Results, including misses, are
+// cached until reset, which is called at the start of every run.
+type resolverFS struct {
+	resolve func(importPath string) ([]byte, bool)
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// reset clears the cached files.
+func (r *resolverFS) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = nil
+}
+
+// lookup resolves name, consulting the cache first.
+func (r *resolverFS) lookup(name string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.cache[name]; ok {
+		return data, data != nil
+	}
+	data, ok := r.resolve(name)
+	if ok && data == nil {
+		data = []byte{}
+	}
+	if !ok {
+		data = nil
+	}
+	if r.cache == nil {
+		r.cache = make(map[string][]byte)
+	}
+	r.cache[name] = data
+	return data, ok
+}
+
+// Open implements fs.FS.
+func (r *resolverFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &resolverDir{}, nil
+	}
+	data, ok := r.lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &resolverFile{Reader: bytes.NewReader(data), name: path.Base(name), size: int64(len(data))}, nil
+}
+
+// resolverFile is a file returned by a resolver.
+type resolverFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *resolverFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *resolverFile) Close() error               { return nil }
+func (f *resolverFile) Name() string               { return f.name }
+func (f *resolverFile) Size() int64                { return f.size }
+func (f *resolverFile) Mode() fs.FileMode          { return 0o444 }
+func (f *resolverFile) ModTime() time.Time         { return time.Time{} }
+func (f *resolverFile) IsDir() bool                { return false }
+func (f *resolverFile) Sys() any                   { return nil }
+
+// resolverDir is the root directory of a resolverFS. Resolvers cannot be
+// enumerated, so it is empty.
+type resolverDir struct{}
+
+func (d *resolverDir) Stat() (fs.FileInfo, error) { return d, nil }
+func (d *resolverDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: fs.ErrInvalid}
+}
+func (d *resolverDir) Close() error { return nil }
+func (d *resolverDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 {
+		return nil, io.EOF
+	}
+	return nil, nil
+}
+func (d *resolverDir) Name() string       { return "." }
+func (d *resolverDir) Size() int64        { return 0 }
+func (d *resolverDir) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d *resolverDir) ModTime() time.Time { return time.Time{} }
+func (d *resolverDir) IsDir() bool        { return true }
+func (d *resolverDir) Sys() any           { return nil }
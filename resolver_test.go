@@ -0,0 +1,72 @@
+package protoc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocResolver(t *testing.T) {
+	ctx := context.Background()
+
+	// The resolver synthesizes a message for every gen/<name>.proto.
+	var resolved []string
+	resolver := func(importPath string) ([]byte, bool) {
+		resolved = append(resolved, importPath)
+		switch {
+		case importPath == "main.proto":
+			return []byte(`
+syntax = "proto3";
+import "gen/a.proto";
+import "gen/b.proto";
+message Main { A a = 1; B b = 2; }
+`), true
+		case strings.HasPrefix(importPath, "gen/") && strings.HasSuffix(importPath, ".proto"):
+			name := strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(importPath, "gen/"), ".proto"))
+			return []byte(fmt.Sprintf(`syntax = "proto3"; message %s {}`, name)), true
+		}
+		return nil, false
+	}
+
+	p := newTestProtoc(t, &Config{Resolver: resolver})
+
+	graph, err := p.DependencyGraph(ctx, nil, []string{"main.proto"})
+	if err != nil {
+		t.Fatalf("DependencyGraph failed: %v", err)
+	}
+	if !slices.Equal(graph["main.proto"], []string{"gen/a.proto", "gen/b.proto"}) {
+		t.Errorf("unexpected dependency graph %v", graph)
+	}
+	// Every file is resolved once per run.
+	for _, name := range []string{"main.proto", "gen/a.proto", "gen/b.proto"} {
+		if n := countOf(resolved, name); n != 1 {
+			t.Errorf("expected %s to be resolved once, got %d times", name, n)
+		}
+	}
+
+	// The cache does not outlive the run.
+	if _, err := p.Compile(ctx, CompileOptions{Files: []string{"gen/a.proto"}}); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if n := countOf(resolved, "gen/a.proto"); n != 2 {
+		t.Errorf("expected gen/a.proto to be resolved again, got %d times", n)
+	}
+
+	_, err = NewProtocWithModule(ctx, nil, nil, &Config{Resolver: resolver, FS: fstest.MapFS{}})
+	if err == nil {
+		t.Error("expected error for FS and Resolver")
+	}
+}
+
+func countOf(items []string, item string) int {
+	n := 0
+	for _, it := range items {
+		if it == item {
+			n++
+		}
+	}
+	return n
+}
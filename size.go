@@ -0,0 +1,147 @@
+package protoc
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// SizeEstimate is a rough estimate of the encoded size of a message in bytes.
+type SizeEstimate struct {
+	// Min is the size with every singular field set to its smallest
+	// encoding, one member of each oneof set and no repeated elements.
+	// Fields with implicit presence are not encoded when they hold their
+	// default value, so an actual message can be smaller.
+	Min int
+	// Typical assumes short varints and strings, the largest member of
+	// each oneof and a few elements in repeated fields.
+	Typical int
+}
+
+const (
+	// typicalVarintSize is the assumed payload of a varint field.
+	typicalVarintSize = 3
+	// typicalLengthSize is the assumed payload of a string or bytes field.
+	typicalLengthSize = 16
+	// typicalRepeated is the assumed number of elements of a repeated field.
+	typicalRepeated = 4
+)
+
+// EstimateSizes compiles files and returns a heuristic SizeEstimate of the
+// wire format of every message they declare, including nested messages,
+// keyed by fully-qualified name, for capacity planning. Message fields add
+// the estimate of their type; recursive references count as empty. File
+// names are cleaned and must be relative to an include path.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) EstimateSizes(ctx context.Context, includePaths, files []string) (map[string]SizeEstimate, error) {
+	set, err := p.compileDescriptorSet(ctx, CompileOptions{
+		IncludePaths:   includePaths,
+		Files:          files,
+		IncludeImports: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make(map[string]*descriptorpb.DescriptorProto)
+	for _, file := range set.GetFile() {
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			msgs[name] = msg
+		})
+	}
+
+	sizes := make(map[string]SizeEstimate)
+	visiting := make(map[string]bool)
+	var estimate func(name string) SizeEstimate
+	estimate = func(name string) SizeEstimate {
+		if size, ok := sizes[name]; ok {
+			return size
+		}
+		msg := msgs[name]
+		if visiting[name] || msg == nil {
+			return SizeEstimate{}
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		var size SizeEstimate
+		oneofs := make([]*SizeEstimate, len(msg.GetOneofDecl()))
+		for _, field := range msg.GetField() {
+			fieldSize := estimateField(field, estimate)
+			if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				size.Typical += typicalRepeated * fieldSize.Typical
+				continue
+			}
+			if field.OneofIndex == nil {
+				size.Min += fieldSize.Min
+				size.Typical += fieldSize.Typical
+				continue
+			}
+			if oneof := oneofs[field.GetOneofIndex()]; oneof == nil {
+				oneofs[field.GetOneofIndex()] = &fieldSize
+			} else {
+				oneof.Min = min(oneof.Min, fieldSize.Min)
+				oneof.Typical = max(oneof.Typical, fieldSize.Typical)
+			}
+		}
+		for _, oneof := range oneofs {
+			if oneof != nil {
+				size.Min += oneof.Min
+				size.Typical += oneof.Typical
+			}
+		}
+		sizes[name] = size
+		return size
+	}
+
+	targets := make([]string, len(files))
+	for i, file := range files {
+		targets[i] = path.Clean(file)
+	}
+	estimates := make(map[string]SizeEstimate)
+	for _, file := range set.GetFile() {
+		if !slices.Contains(targets, file.GetName()) {
+			continue
+		}
+		forEachMessage(file.GetPackage(), file.GetMessageType(), func(name string, msg *descriptorpb.DescriptorProto) {
+			estimates[name] = estimate(name)
+		})
+	}
+	return estimates, nil
+}
+
+// estimateField returns the estimated size of one value of field, including
+// its tag, using estimate for message types.
+func estimateField(field *descriptorpb.FieldDescriptorProto, estimate func(name string) SizeEstimate) SizeEstimate {
+	tag := protowire.SizeTag(protowire.Number(field.GetNumber()))
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL, descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return SizeEstimate{Min: tag + 1, Typical: tag + 1}
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return SizeEstimate{Min: tag + 4, Typical: tag + 4}
+	case descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return SizeEstimate{Min: tag + 8, Typical: tag + 8}
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return SizeEstimate{Min: tag + 1, Typical: tag + 1 + typicalLengthSize}
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		nested := estimate(strings.TrimPrefix(field.GetTypeName(), "."))
+		return SizeEstimate{
+			Min:     tag + protowire.SizeVarint(uint64(nested.Min)) + nested.Min,
+			Typical: tag + protowire.SizeVarint(uint64(nested.Typical)) + nested.Typical,
+		}
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		nested := estimate(strings.TrimPrefix(field.GetTypeName(), "."))
+		return SizeEstimate{Min: 2*tag + nested.Min, Typical: 2*tag + nested.Typical}
+	default:
+		// The remaining types are varints.
+		return SizeEstimate{Min: tag + 1, Typical: tag + typicalVarintSize}
+	}
+}
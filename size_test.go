@@ -0,0 +1,54 @@
+package protoc
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProtocEstimateSizes(t *testing.T) {
+	ctx := context.Background()
+	memFS := fstest.MapFS{
+		"sizes.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto3";
+package test;
+message Varints { int32 a = 1; uint64 b = 2; bool c = 3; }
+message Fixed { fixed64 a = 1; }
+message Node {
+  string name = 1;
+  repeated Node children = 2;
+  oneof value {
+    int32 small = 3;
+    double large = 4;
+  }
+}
+message Wrapper { Fixed fixed = 1; }
+`)},
+	}
+
+	p := newTestProtoc(t, &Config{FS: memFS})
+
+	sizes, err := p.EstimateSizes(ctx, nil, []string{"sizes.proto"})
+	if err != nil {
+		t.Fatalf("EstimateSizes failed: %v", err)
+	}
+	if len(sizes) != 4 {
+		t.Fatalf("expected 4 messages, got %v", sizes)
+	}
+	// Each field takes a one-byte tag; the varints at least one byte and
+	// fixed64 eight.
+	if sizes["test.Varints"].Min != 6 || sizes["test.Fixed"].Min != 9 {
+		t.Errorf("unexpected estimates %v and %v", sizes["test.Varints"], sizes["test.Fixed"])
+	}
+	if sizes["test.Fixed"].Min <= sizes["test.Varints"].Min {
+		t.Error("expected fixed64 to have a larger minimum than varints")
+	}
+	// The name and the smaller oneof member.
+	if node := sizes["test.Node"]; node.Min != 4 || node.Typical <= node.Min {
+		t.Errorf("unexpected estimate %v", node)
+	}
+	// Tag and length prefix around the nested message.
+	if wrapper := sizes["test.Wrapper"]; wrapper.Min != 11 {
+		t.Errorf("unexpected estimate %v", wrapper)
+	}
+}
@@ -0,0 +1,61 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// CompileSpec declaratively describes a compilation, for workflows that keep
+// generator configuration in a file. It is unmarshalable from JSON:
+//
+//	{
+//	  "include_paths": ["/proto"],
+//	  "files": ["example/v1/greeter.proto"],
+//	  "generators": [
+//	    {"name": "cpp", "out_dir": "cpp"},
+//	    {"name": "python", "params": ["pyi_out"]}
+//	  ]
+//	}
+type CompileSpec struct {
+	// IncludePaths are the directories searched for imports.
+	// Default: the filesystem root.
+	IncludePaths []string `json:"include_paths,omitempty"`
+	// Files are the .proto files to compile.
+	Files []string `json:"files"`
+	// Generators are the generators to run.
+	Generators []GeneratorSpec `json:"generators"`
+	// GeneratorFilter is a glob pattern selecting which Generators run,
+	// for example "go*". See FilterGenerators. Default: all generators.
+	GeneratorFilter string `json:"generator_filter,omitempty"`
+}
+
+// ParseCompileSpec decodes a JSON CompileSpec. Unknown fields are rejected
+// so that typos in configuration files are reported.
+func ParseCompileSpec(data []byte) (CompileSpec, error) {
+	var spec CompileSpec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return CompileSpec{}, err
+	}
+	return spec, nil
+}
+
+// RunSpec runs the compilation described by spec. The generated files are
+// returned as by RunGenerators, for the generators selected by
+// spec.GeneratorFilter.
+//
+// If protoc fails the returned error is a *CompileError.
+// Init() must be called first.
+func (p *Protoc) RunSpec(ctx context.Context, spec CompileSpec) (map[string]map[string][]byte, error) {
+	if len(spec.Files) == 0 {
+		return nil, errors.New("compile spec has no files")
+	}
+	gens, err := FilterGenerators(spec.Generators, spec.GeneratorFilter)
+	if err != nil {
+		return nil, err
+	}
+	return p.RunGenerators(ctx, spec.IncludePaths, spec.Files, gens)
+}
@@ -0,0 +1,53 @@
+package protoc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProtocRunSpec(t *testing.T) {
+	ctx := context.Background()
+	spec, err := ParseCompileSpec([]byte(`{
+  "include_paths": ["/testdata/protos"],
+  "files": ["example/v1/greeter.proto"],
+  "generators": [
+    {"name": "cpp", "out_dir": "cpp"},
+    {"name": "python", "params": ["pyi_out"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("ParseCompileSpec failed: %v", err)
+	}
+
+	p := newTestProtoc(t, &Config{FS: testProtos})
+
+	outputs, err := p.RunSpec(ctx, spec)
+	if err != nil {
+		t.Fatalf("RunSpec failed: %v", err)
+	}
+	for gen, file := range map[string]string{
+		"cpp":    "cpp/example/v1/greeter.pb.h",
+		"python": "example/v1/greeter_pb2.pyi",
+	} {
+		if _, ok := outputs[gen][file]; !ok {
+			t.Errorf("%s: expected %s, got %v", gen, file, keys(outputs[gen]))
+		}
+	}
+
+	// The filter selects a subset of the generators.
+	spec.GeneratorFilter = "py*"
+	outputs, err = p.RunSpec(ctx, spec)
+	if err != nil {
+		t.Fatalf("RunSpec failed: %v", err)
+	}
+	if len(outputs) != 1 || outputs["python"] == nil {
+		t.Errorf("expected only python outputs, got %v", keys(outputs))
+	}
+
+	if _, err := ParseCompileSpec([]byte(`{"files": ["a.proto"], "generator": []}`)); err == nil {
+		t.Error("expected error for unknown field")
+	}
+	if _, err := p.RunSpec(ctx, CompileSpec{}); err == nil {
+		t.Error("expected error for empty spec")
+	}
+}
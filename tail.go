@@ -0,0 +1,81 @@
+package protoc
+
+import (
+	"bytes"
+	"sync"
+)
+
+// lineRing is an io.Writer keeping only the last lines written to it.
+type lineRing struct {
+	mu sync.Mutex
+	// lines is a ring of complete lines, oldest at start.
+	lines []string
+	start int
+	count int
+	// partial is the trailing line not yet terminated by a newline.
+	partial []byte
+}
+
+// newLineRing creates a lineRing keeping the last n lines.
+func newLineRing(n int) *lineRing {
+	return &lineRing{lines: make([]string, n)}
+}
+
+// Write implements io.Writer.
+func (r *lineRing) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(b)
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			r.partial = append(r.partial, b...)
+			return n, nil
+		}
+		r.push(string(r.partial) + string(b[:i]))
+		r.partial = r.partial[:0]
+		b = b[i+1:]
+	}
+}
+
+// push appends a complete line, dropping the oldest if the ring is full.
+// r.mu must be held.
+func (r *lineRing) push(line string) {
+	if r.count < len(r.lines) {
+		r.lines[(r.start+r.count)%len(r.lines)] = line
+		r.count++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+// reset discards all lines.
+func (r *lineRing) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.lines)
+	r.start, r.count = 0, 0
+	r.partial = r.partial[:0]
+}
+
+// bytes returns the retained lines, newline-terminated, followed by any
+// unterminated trailing output. A trailing partial line counts towards the
+// limit.
+func (r *lineRing) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	skip := 0
+	if len(r.partial) != 0 && r.count == len(r.lines) {
+		skip = 1
+	}
+	var buf bytes.Buffer
+	for i := skip; i < r.count; i++ {
+		buf.WriteString(r.lines[(r.start+i)%len(r.lines)])
+		buf.WriteByte('\n')
+	}
+	buf.Write(r.partial)
+	return buf.Bytes()
+}
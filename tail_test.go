@@ -0,0 +1,70 @@
+package protoc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLineRing(t *testing.T) {
+	r := newLineRing(2)
+	for _, chunk := range []string{"one\ntw", "o\nthree\n", "fo"} {
+		if _, err := r.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The unterminated line counts towards the limit.
+	if s := string(r.bytes()); s != "three\nfo" {
+		t.Errorf("unexpected tail %q", s)
+	}
+	r.Write([]byte("ur\n"))
+	if s := string(r.bytes()); s != "three\nfour\n" {
+		t.Errorf("unexpected tail %q", s)
+	}
+	r.reset()
+	if s := string(r.bytes()); s != "" {
+		t.Errorf("expected empty tail after reset, got %q", s)
+	}
+}
+
+func TestProtocLastStderr(t *testing.T) {
+	ctx := context.Background()
+	var src strings.Builder
+	src.WriteString("syntax = \"proto3\";\nmessage Bad {\n")
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&src, "  Missing%d m%d = %d;\n", i, i, i)
+	}
+	src.WriteString("}\n")
+	memFS := fstest.MapFS{
+		"bad.proto": &fstest.MapFile{Data: []byte(src.String())},
+	}
+
+	var stderr bytes.Buffer
+	p := newTestProtoc(t, &Config{FS: memFS, Stderr: &stderr, StderrTailLines: 3})
+
+	if _, err := p.Check(ctx, nil, []string{"bad.proto"}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	tail := strings.Split(strings.TrimSuffix(string(p.LastStderr()), "\n"), "\n")
+	if len(tail) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %q", len(tail), tail)
+	}
+	if !strings.Contains(tail[2], "Missing10") {
+		t.Errorf("expected tail to end with the last error, got %q", tail)
+	}
+	if all := strings.Count(stderr.String(), "\n"); all < 10 {
+		t.Errorf("expected Stderr to receive all %d+ lines, got %d", 10, all)
+	}
+
+	// Each run starts a new tail.
+	if _, err := p.Run(ctx, []string{"protoc", "--version"}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if tail := p.LastStderr(); len(tail) != 0 {
+		t.Errorf("expected empty tail after successful run, got %q", tail)
+	}
+}
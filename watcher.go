@@ -0,0 +1,173 @@
+package protoc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchDebounce is the default Watcher debounce interval.
+const DefaultWatchDebounce = 100 * time.Millisecond
+
+// WatcherConfig configures a Watcher.
+type WatcherConfig struct {
+	// Debounce is how long to wait after the last change before compiling.
+	// Default: DefaultWatchDebounce.
+	Debounce time.Duration
+	// Filter reports whether a change to path should trigger a compile.
+	// Default: all changes trigger a compile.
+	Filter func(path string) bool
+	// Compile runs the compilation for the coalesced set of changed paths.
+	// ctx is canceled if another change arrives while it is running, in
+	// which case the changes are folded into the next compile.
+	Compile func(ctx context.Context, changed []string) error
+}
+
+// WatchResult is the outcome of a compile triggered by a Watcher.
+type WatchResult struct {
+	// Changed is the sorted set of paths that triggered the compile.
+	Changed []string
+	// Err is the error returned by Compile.
+	Err error
+	// Duration is how long Compile took.
+	Duration time.Duration
+}
+
+// Watcher debounces change notifications and re-runs a compilation.
+//
+// The package does not watch the host filesystem: callers report changes
+// with Notify, for example from an fsnotify watcher or an editor.
+type Watcher struct {
+	cfg     WatcherConfig
+	results chan WatchResult
+	signal  chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+}
+
+// watchRun is an in-flight compile started by a Watcher.
+type watchRun struct {
+	changed  []string
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// NewWatcher creates a new Watcher. Call Run to start processing changes.
+func NewWatcher(cfg WatcherConfig) *Watcher {
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DefaultWatchDebounce
+	}
+	return &Watcher{
+		cfg:     cfg,
+		results: make(chan WatchResult),
+		signal:  make(chan struct{}, 1),
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Notify reports that paths changed. It does not block.
+func (w *Watcher) Notify(paths ...string) {
+	w.mu.Lock()
+	var added bool
+	for _, p := range paths {
+		if w.cfg.Filter == nil || w.cfg.Filter(p) {
+			w.pending[p] = struct{}{}
+			added = true
+		}
+	}
+	w.mu.Unlock()
+
+	if added {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Results returns the channel compile results are sent on.
+// The channel is closed when Run returns.
+func (w *Watcher) Results() <-chan WatchResult {
+	return w.results
+}
+
+// Run processes change notifications until ctx is canceled.
+// Any in-flight compile is canceled and waited for before returning.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer close(w.results)
+
+	timer := time.NewTimer(w.cfg.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	done := make(chan WatchResult, 1)
+	var running *watchRun
+	var due bool
+
+	start := func() {
+		w.mu.Lock()
+		changed := make([]string, 0, len(w.pending))
+		for p := range w.pending {
+			changed = append(changed, p)
+		}
+		clear(w.pending)
+		w.mu.Unlock()
+		if len(changed) == 0 {
+			return
+		}
+		sort.Strings(changed)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		running = &watchRun{changed: changed, cancel: cancel}
+		go func() {
+			defer cancel()
+			t := time.Now()
+			err := w.cfg.Compile(runCtx, changed)
+			done <- WatchResult{Changed: changed, Err: err, Duration: time.Since(t)}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running != nil {
+				running.cancel()
+				<-done
+			}
+			return ctx.Err()
+		case <-w.signal:
+			if running != nil && !running.canceled {
+				running.cancel()
+				running.canceled = true
+			}
+			timer.Reset(w.cfg.Debounce)
+		case <-timer.C:
+			if running != nil {
+				due = true
+				continue
+			}
+			start()
+		case res := <-done:
+			if running.canceled {
+				// Fold the superseded changes into the next compile.
+				w.mu.Lock()
+				for _, p := range running.changed {
+					w.pending[p] = struct{}{}
+				}
+				w.mu.Unlock()
+			} else {
+				select {
+				case w.results <- res:
+				case <-ctx.Done():
+				}
+			}
+			running = nil
+			if due {
+				due = false
+				start()
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan []string, 10)
+	var blocked bool
+	w := NewWatcher(WatcherConfig{
+		Debounce: 20 * time.Millisecond,
+		Filter: func(path string) bool {
+			return strings.HasSuffix(path, ".proto")
+		},
+		Compile: func(ctx context.Context, changed []string) error {
+			started <- changed
+			if slices.Equal(changed, []string{"slow.proto"}) && !blocked {
+				// Block until superseded by another change.
+				blocked = true
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			if slices.Contains(changed, "bad.proto") {
+				return errors.New("compile failed")
+			}
+			return nil
+		},
+	})
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- w.Run(ctx) }()
+
+	nextResult := func() WatchResult {
+		t.Helper()
+		select {
+		case res := <-w.Results():
+			return res
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for result")
+			return WatchResult{}
+		}
+	}
+
+	// Bursts of changes are coalesced and filtered.
+	w.Notify("a.proto")
+	w.Notify("b.proto", "README.md")
+	w.Notify("a.proto")
+	res := nextResult()
+	if !slices.Equal(res.Changed, []string{"a.proto", "b.proto"}) {
+		t.Errorf("unexpected changed paths: %v", res.Changed)
+	}
+	if res.Err != nil {
+		t.Errorf("unexpected error: %v", res.Err)
+	}
+
+	// Changes to filtered paths alone do not trigger a compile.
+	w.Notify("notes.txt")
+
+	// An in-flight compile is canceled and its changes are folded into the
+	// next compile.
+	<-started
+	w.Notify("slow.proto")
+	if changed := <-started; !slices.Equal(changed, []string{"slow.proto"}) {
+		t.Fatalf("unexpected changed paths: %v", changed)
+	}
+	w.Notify("bad.proto")
+	res = nextResult()
+	if !slices.Equal(res.Changed, []string{"bad.proto", "slow.proto"}) {
+		t.Errorf("unexpected changed paths: %v", res.Changed)
+	}
+	if res.Err == nil || res.Err.Error() != "compile failed" {
+		t.Errorf("unexpected error: %v", res.Err)
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+	if _, ok := <-w.Results(); ok {
+		t.Error("expected results channel to be closed")
+	}
+}
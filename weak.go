@@ -0,0 +1,102 @@
+package protoc
+
+import (
+	"context"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// weakStubsFile is the descriptor set in descriptorSetsDir holding empty
+// stand-ins for missing weak imports, present only while compiling.
+const weakStubsFile = "weak.pb"
+
+// compileWithWeakStubs retries a compilation that failed with result failed,
+// providing empty files for the missing imports, as allowed by
+// Config.AllowMissingWeakImports. If any missing file is not only imported
+// weakly, or the retry fails for another reason, failed is returned.
+// p.mu must be held.
+func (p *Protoc) compileWithWeakStubs(ctx context.Context, opts CompileOptions, gens []GeneratorSpec, failed *compileResult) (*compileResult, error) {
+	missing := missingImports(failed.diagnostics, opts.Files)
+	if len(missing) == 0 {
+		return failed, nil
+	}
+	stubs := &descriptorpb.FileDescriptorSet{}
+	for _, name := range missing {
+		stubs.File = append(stubs.File, &descriptorpb.FileDescriptorProto{Name: proto.String(name)})
+	}
+	data, err := proto.Marshal(stubs)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.descSets.WriteFile(weakStubsFile, data); err != nil {
+		return nil, err
+	}
+	defer p.descSets.remove(weakStubsFile)
+
+	// Compile with imports first to find every file importing a stub.
+	checkOpts := opts
+	checkOpts.IncludeImports = true
+	res, err := p.compileOnce(ctx, checkOpts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.exitCode != 0 {
+		return failed, nil
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(res.descSet, set); err != nil {
+		return nil, err
+	}
+	if !onlyWeaklyImported(set, missing) {
+		return failed, nil
+	}
+
+	if !opts.IncludeImports || len(gens) != 0 {
+		res, err = p.compileOnce(ctx, opts, gens)
+		if err != nil {
+			return nil, err
+		}
+		if res.exitCode != 0 {
+			return failed, nil
+		}
+	}
+	if opts.IncludeImports {
+		if res.descSet, err = removeFiles(res.descSet, missing); err != nil {
+			return nil, err
+		}
+	}
+	res.diagnostics = slices.DeleteFunc(res.diagnostics, func(diag Diagnostic) bool {
+		return slices.ContainsFunc(missing, func(name string) bool {
+			return diag.Message == "Import "+name+" is unused."
+		})
+	})
+	return res, nil
+}
+
+// onlyWeaklyImported reports whether every import of the files named
+// missing in set is a weak import.
+func onlyWeaklyImported(set *descriptorpb.FileDescriptorSet, missing []string) bool {
+	for _, file := range set.GetFile() {
+		for i, dep := range file.GetDependency() {
+			if slices.Contains(missing, dep) && !slices.Contains(file.GetWeakDependency(), int32(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// removeFiles removes the files named names from the encoded
+// FileDescriptorSet data.
+func removeFiles(data []byte, names []string) ([]byte, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	set.File = slices.DeleteFunc(set.File, func(file *descriptorpb.FileDescriptorProto) bool {
+		return slices.Contains(names, file.GetName())
+	})
+	return proto.Marshal(set)
+}
@@ -0,0 +1,67 @@
+package protoc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProtocAllowMissingWeakImports(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"a.proto": &fstest.MapFile{Data: []byte(`
+syntax = "proto2";
+import weak "missing.proto";
+import "b.proto";
+message A { optional B b = 1; }
+`)},
+		"b.proto": &fstest.MapFile{Data: []byte(`syntax = "proto2"; message B {}`)},
+		"c.proto": &fstest.MapFile{Data: []byte(`syntax = "proto2"; import "missing.proto";`)},
+	}
+
+	// protoc fails by default.
+	p := newTestProtoc(t, &Config{FS: fsys})
+	_, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+
+	p = newTestProtoc(t, &Config{FS: fsys, AllowMissingWeakImports: true})
+	for _, includeImports := range []bool{false, true} {
+		data, err := p.Compile(ctx, CompileOptions{Files: []string{"a.proto"}, IncludeImports: includeImports})
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range set.GetFile() {
+			names = append(names, file.GetName())
+		}
+		if slices.Contains(names, "missing.proto") {
+			t.Errorf("expected stub to be left out, got %v", names)
+		}
+		a := set.GetFile()[len(set.GetFile())-1]
+		if !slices.Equal(a.GetDependency(), []string{"missing.proto", "b.proto"}) || !slices.Equal(a.GetWeakDependency(), []int32{0}) {
+			t.Errorf("unexpected dependencies %v, weak %v", a.GetDependency(), a.GetWeakDependency())
+		}
+	}
+
+	// Regular imports of a missing file still fail.
+	_, err = p.Compile(ctx, CompileOptions{Files: []string{"c.proto"}})
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+	if p.descSets.exists(weakStubsFile) {
+		t.Error("expected stubs to be removed")
+	}
+}
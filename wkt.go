@@ -0,0 +1,75 @@
+package protoc
+
+import (
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/gofeaturespb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// wellKnownTypesFile is the name of the well-known types descriptor set in
+// descriptorSetsDir.
+const wellKnownTypesFile = "wkt.pb"
+
+// wellKnownTypeFiles are the files providing the well-known types. They are
+// referenced so that they are linked into the global registry.
+var wellKnownTypeFiles = []protoreflect.FileDescriptor{
+	anypb.File_google_protobuf_any_proto,
+	apipb.File_google_protobuf_api_proto,
+	descriptorpb.File_google_protobuf_descriptor_proto,
+	durationpb.File_google_protobuf_duration_proto,
+	emptypb.File_google_protobuf_empty_proto,
+	fieldmaskpb.File_google_protobuf_field_mask_proto,
+	gofeaturespb.File_google_protobuf_go_features_proto,
+	pluginpb.File_google_protobuf_compiler_plugin_proto,
+	sourcecontextpb.File_google_protobuf_source_context_proto,
+	structpb.File_google_protobuf_struct_proto,
+	timestamppb.File_google_protobuf_timestamp_proto,
+	typepb.File_google_protobuf_type_proto,
+	wrapperspb.File_google_protobuf_wrappers_proto,
+}
+
+// isWellKnownType reports whether name is the path of a well-known type
+// file, e.g. "google/protobuf/timestamp.proto".
+func isWellKnownType(name string) bool {
+	for _, fd := range wellKnownTypeFiles {
+		if fd.Path() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// wellKnownTypes returns the encoded FileDescriptorSet of the well-known
+// types. The compile helpers pass it to protoc with --descriptor_set_in so
+// that imports of google/protobuf/*.proto resolve without the sources. Files
+// found in the include paths take precedence.
+var wellKnownTypes = sync.OnceValue(func() []byte {
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range wellKnownTypeFiles {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	sort.Slice(set.File, func(i, j int) bool {
+		return set.File[i].GetName() < set.File[j].GetName()
+	})
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		panic(err)
+	}
+	return data
+})
@@ -0,0 +1,45 @@
+package protoc
+
+import (
+	"path"
+	"strings"
+)
+
+// withWorkingDir returns args with the relative output paths joined to
+// Config.WorkingDir: the directories of --<name>_out and the files of
+// --descriptor_set_out, -o and --dependency_out. The WASI guest has no
+// current directory of its own, so protoc resolves relative paths against
+// the root. Values must be given as --flag=value.
+func (p *Protoc) withWorkingDir(args []string) []string {
+	if p.workingDir == "" || len(args) < 2 {
+		return args
+	}
+	resolved := append([]string{args[0]}, args[1:]...)
+	for i, arg := range resolved[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok:
+			if file, ok := strings.CutPrefix(arg, "-o"); ok && file != "" {
+				resolved[i+1] = "-o" + p.workingPath(file)
+			}
+		case name == "--descriptor_set_out" || name == "--dependency_out":
+			resolved[i+1] = name + "=" + p.workingPath(value)
+		case strings.HasPrefix(name, "--") && strings.HasSuffix(name, "_out"):
+			// protoc splits off the generator parameters at the first colon.
+			if params, dir, ok := strings.Cut(value, ":"); ok {
+				resolved[i+1] = name + "=" + params + ":" + p.workingPath(dir)
+			} else {
+				resolved[i+1] = name + "=" + p.workingPath(value)
+			}
+		}
+	}
+	return resolved
+}
+
+// workingPath returns name joined to Config.WorkingDir if it is relative.
+func (p *Protoc) workingPath(name string) string {
+	if path.IsAbs(name) {
+		return name
+	}
+	return path.Join(p.workingDir, name)
+}
@@ -0,0 +1,39 @@
+package protoc
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestProtocWorkingDir(t *testing.T) {
+	ctx := context.Background()
+	memFS := writableFooProtoFS(t, "work/cpp")
+
+	p := newTestProtoc(t, &Config{FS: memFS, WorkingDir: "work"})
+
+	report, err := p.RunReport(ctx, []string{"protoc", "-I/", "--descriptor_set_out=rel.pb", "--cpp_out=lite:cpp", "foo.proto"})
+	if err != nil {
+		t.Fatalf("RunReport failed: %v", err)
+	}
+	if report.ExitCode != 0 {
+		t.Fatalf("unexpected failure: %+v", report)
+	}
+	expected := []string{"protoc", "-I/", "--descriptor_set_out=/work/rel.pb", "--cpp_out=lite:/work/cpp", "foo.proto"}
+	if !slices.Equal(report.FinalArgs, expected) {
+		t.Errorf("expected final args %v, got %v", expected, report.FinalArgs)
+	}
+	for _, name := range []string{"work/rel.pb", "work/cpp/foo.pb.h"} {
+		if data, err := memFS.ReadFile(name); err != nil || len(data) == 0 {
+			t.Errorf("expected %s to be written: %v", name, err)
+		}
+	}
+
+	// Absolute paths are kept.
+	if exitCode, err := p.Run(ctx, []string{"protoc", "-I/", "-o/abs.pb", "foo.proto"}); err != nil || exitCode != 0 {
+		t.Fatalf("Run failed: %d, %v", exitCode, err)
+	}
+	if _, err := memFS.ReadFile("abs.pb"); err != nil {
+		t.Errorf("expected abs.pb to be written: %v", err)
+	}
+}